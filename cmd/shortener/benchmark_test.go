@@ -30,7 +30,23 @@ func setupBenchServer(b *testing.B) (*resty.Client, *httptest.Server) {
 
 	deleteWorker := repository.NewDeleteURLsWorkers(storageData, 3, 2*time.Second, 50)
 	audit := repository.NewAuditPublisher(100)
-	router := setupRouter(cfg, storageData, deleteWorker, audit)
+	events := repository.NewClickStream(cfg.EventsBuffer)
+	audit.RegisterLossless(events, 100)
+	codes, err := service.NewCodeGenerator(cfg, storageData)
+	assert.NoError(b, err)
+	aliases, err := service.NewAliasPolicy(cfg)
+	assert.NoError(b, err)
+	urls, err := service.NewURLPolicy(cfg)
+	assert.NoError(b, err)
+	clickWorkers := repository.NewClickWorkers(storageData, 1, 50*time.Millisecond, 10)
+	trending := repository.NewTrendingCounter(storageData, time.Second)
+	bots, err := service.NewBotClassifier(cfg)
+	assert.NoError(b, err)
+	geo, err := service.NewGeoResolver(cfg)
+	assert.NoError(b, err)
+	proxies, err := service.NewTrustedProxies(nil)
+	assert.NoError(b, err)
+	router := setupRouter(cfg, storageData, deleteWorker, audit, codes, aliases, urls, clickWorkers, bots, geo, proxies, trending, events)
 	srv := httptest.NewServer(router)
 
 	client := resty.New()
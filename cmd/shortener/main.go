@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,7 +17,9 @@ import (
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/handler"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/repository"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/tools"
 	"github.com/go-chi/chi/v5"
@@ -29,30 +32,60 @@ var (
 	buildCommit  string
 )
 
-func setupRouter(cfg *config.Config, store storage.Storage, deleteWorker *repository.DeleteURLsWorkers, audit *repository.AuditPublisher) *chi.Mux {
+func setupRouter(cfg *config.Config, store storage.Storage, deleteWorker *repository.DeleteURLsWorkers, audit *repository.AuditPublisher, codes service.CodeGenerator, aliases *service.AliasPolicy, urls *service.URLPolicy, clicks *repository.ClickWorkers, bots *service.BotClassifier, geo *service.GeoResolver, proxies *service.TrustedProxies, trending *repository.TrendingCounter, events *repository.ClickStream) *chi.Mux {
 	r := chi.NewRouter()
-	h := handler.NewHandler(cfg, store, deleteWorker, audit)
+	h := handler.NewHandler(cfg, store, deleteWorker, audit, codes, aliases, urls, clicks, bots, geo, proxies, trending, events)
 
 	r.Use(logger.RequestLogger)
 	r.Use(handler.GzipMiddleware)
 	r.Route("/", func(r chi.Router) {
 		r.Get("/{URLCode}", h.RedirectURL)
+		r.Head("/{URLCode}", h.RedirectURL)
 		r.With(h.GetOrCreateUserMiddleware).Post("/", h.GenerateURL)
 	})
 	r.Route("/api/shorten", func(r chi.Router) {
 		r.With(h.GetOrCreateUserMiddleware).Post("/", h.JSONGenerateURL)
-		r.Post("/batch", h.BatchGenerateURL)
+		r.With(h.GetOrCreateUserMiddleware).Post("/batch", h.BatchGenerateURL)
+		r.Get("/alias/{alias}/available", h.AliasAvailable)
 	})
 	r.Route("/api/user", func(r chi.Router) {
 		r.With(h.GetOrCreateUserMiddleware).Get("/urls", h.GetUserURLs)
 		r.With(h.GetOrCreateUserMiddleware).Delete("/urls", h.DeleteUserURLs)
+		r.With(h.GetOrCreateUserMiddleware).Post("/urls/restore", h.RestoreUserURLs)
+		r.With(h.GetOrCreateUserMiddleware).Patch("/urls/{code}", h.UpdateUserURL)
+		r.With(h.GetOrCreateUserMiddleware).Get("/urls/{code}/history", h.UserURLHistory)
+		r.With(h.GetOrCreateUserMiddleware).Post("/urls/{code}/rollback", h.RollbackUserURL)
+		r.With(h.GetOrCreateUserMiddleware).Get("/urls/{code}/stats", h.UserURLStats)
+		r.With(h.GetOrCreateUserMiddleware).Get("/urls/{code}/events", h.UserURLEvents)
+		r.With(h.GetOrCreateUserMiddleware).Get("/events", h.UserEvents)
+		r.With(h.GetOrCreateUserMiddleware).Get("/stats/timeseries", h.UserClickSeries)
+		r.With(h.GetOrCreateUserMiddleware).Get("/stats/top", h.UserTopLinks)
+	})
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(h.AdminMiddleware)
+		r.Get("/stats/top", h.AdminTopLinks)
 	})
 	r.Route("/ping", func(r chi.Router) {
 		r.Get("/", h.Ping)
 	})
+	aliases.Reserve(routePrefixes(r)...)
 	return r
 }
 
+// routePrefixes returns the first path segments of all static routes,
+// which would shadow or be shadowed by equally named aliases
+func routePrefixes(r chi.Routes) []string {
+	var prefixes []string
+	chi.Walk(r, func(_ string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+		if segment != "" && !strings.HasPrefix(segment, "{") {
+			prefixes = append(prefixes, segment)
+		}
+		return nil
+	})
+	return prefixes
+}
+
 func printBuildInfo() {
 	version := buildVersion
 	if version == "" {
@@ -107,6 +140,10 @@ func main() {
 		log.Panic(err)
 	}
 
+	if err := model.ValidateRedirectType(cfg.RedirectType); err != nil {
+		logger.Log.Fatal("invalid default redirect type", zap.Error(err))
+	}
+
 	store, err := storage.NewStorage(cfg)
 	if err != nil {
 		logger.Log.Fatal("storage init error", zap.Error(err))
@@ -119,9 +156,64 @@ func main() {
 		cfg.DeleteBachSize,
 	)
 
+	purgeWorker := repository.NewPurgeWorker(
+		store,
+		time.Duration(cfg.PurgeInterval)*time.Second,
+		time.Duration(cfg.PurgeRetention)*time.Second,
+		time.Duration(cfg.RestoreGracePeriod)*time.Second,
+	)
+
+	downsampleWorker := repository.NewDownsampleWorker(
+		store,
+		time.Duration(cfg.DownsampleInterval)*time.Second,
+		time.Duration(cfg.ClickRetention)*time.Second,
+	)
+
+	clickWorkers := repository.NewClickWorkers(
+		store,
+		2,
+		time.Duration(cfg.ClickFlushInterval)*time.Second,
+		cfg.ClickBatchSize,
+	)
+
+	trending := repository.NewTrendingCounter(store, time.Duration(cfg.TrendingInterval)*time.Second)
+
 	audit := setupAudit(cfg)
+	events := repository.NewClickStream(cfg.EventsBuffer)
+	audit.RegisterLossless(events, 100)
+
+	codes, err := service.NewCodeGenerator(cfg, store)
+	if err != nil {
+		logger.Log.Fatal("code generator init error", zap.Error(err))
+	}
+	aliases, err := service.NewAliasPolicy(cfg)
+	if err != nil {
+		logger.Log.Fatal("alias policy init error", zap.Error(err))
+	}
+
+	urls, err := service.NewURLPolicy(cfg)
+	if err != nil {
+		logger.Log.Fatal("url policy init error", zap.Error(err))
+	}
+	go urls.Watch(mainCtx, time.Duration(cfg.URLDomainsInterval)*time.Second)
+
+	bots, err := service.NewBotClassifier(cfg)
+	if err != nil {
+		logger.Log.Fatal("bot classifier init error", zap.Error(err))
+	}
+	go bots.Watch(mainCtx, time.Duration(cfg.BotPatternsInterval)*time.Second)
+
+	geo, err := service.NewGeoResolver(cfg)
+	if err != nil {
+		logger.Log.Fatal("geoip init error", zap.Error(err))
+	}
+	go geo.Watch(mainCtx, time.Duration(cfg.GeoIPInterval)*time.Second)
+	proxies, err := service.NewTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logger.Log.Fatal("trusted proxies init error", zap.Error(err))
+	}
 
-	r := setupRouter(cfg, store, deleteWorker, audit)
+	r := setupRouter(cfg, store, deleteWorker, audit, codes, aliases, urls, clickWorkers, bots, geo, proxies, trending, events)
 
 	httpServer := &http.Server{
 		Addr:    cfg.RunAddr,
@@ -130,6 +222,8 @@ func main() {
 			return mainCtx
 		},
 	}
+	// event streams never end on their own
+	httpServer.RegisterOnShutdown(events.Close)
 
 	pprofServer := &http.Server{
 		Addr: "localhost:6060",
@@ -138,5 +232,5 @@ func main() {
 		},
 	}
 
-	tools.RunServers(mainCtx, cfg, httpServer, pprofServer, store, deleteWorker, audit)
+	tools.RunServers(mainCtx, cfg, httpServer, pprofServer, store, deleteWorker, purgeWorker, downsampleWorker, clickWorkers, trending, audit)
 }
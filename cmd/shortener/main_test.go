@@ -1,40 +1,50 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/auth"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/repository"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service/geotest"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 )
 
 func setupTestServer() (*resty.Client, *httptest.Server, *config.Config) {
-	cfg := &config.Config{
-		RunAddr:    ":8080",
-		ServerAddr: "http://localhost:8080/",
-	}
+	return setupTestServerWithConfig(&config.Config{})
+}
+
+// setupTestServerWithConfig fills in the common test settings on top of cfg
+func setupTestServerWithConfig(cfg *config.Config) (*resty.Client, *httptest.Server, *config.Config) {
+	cfg.RunAddr = ":8080"
+	cfg.ServerAddr = "http://localhost:8080/"
+	cfg.SecretKey = "test_secret_key"
+	cfg.TokenExp = 1
 	storageData, err := storage.NewStorage(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	storageData.SaveURL(context.TODO(), storage.URL{Code: "qwerty", URL: "https://example.com"})
+	storageData.SaveURL(context.TODO(), storage.URL{Code: "qwerty", URL: "https://example.com/", Input: "https://example.com"})
 
-	deleteWorker := repository.NewDeleteURLsWorkers(storageData, 3, 2*time.Second, 50)
-	audit := repository.NewAuditPublisher(100)
-	router := setupRouter(cfg, storageData, deleteWorker, audit)
-	srv := httptest.NewServer(router)
+	srv := httptest.NewServer(setupTestRouter(cfg, storageData))
 
 	client := resty.New()
 	client.SetRedirectPolicy(resty.NoRedirectPolicy())
@@ -42,6 +52,41 @@ func setupTestServer() (*resty.Client, *httptest.Server, *config.Config) {
 	return client, srv, cfg
 }
 
+// setupTestRouter wires the handlers around storageData
+func setupTestRouter(cfg *config.Config, storageData storage.Storage) http.Handler {
+	deleteWorker := repository.NewDeleteURLsWorkers(storageData, 3, 2*time.Second, 50)
+	audit := repository.NewAuditPublisher(100)
+	events := repository.NewClickStream(cfg.EventsBuffer)
+	audit.RegisterLossless(events, 100)
+	codes, err := service.NewCodeGenerator(cfg, storageData)
+	if err != nil {
+		panic(err)
+	}
+	aliases, err := service.NewAliasPolicy(cfg)
+	if err != nil {
+		panic(err)
+	}
+	urls, err := service.NewURLPolicy(cfg)
+	if err != nil {
+		panic(err)
+	}
+	clickWorkers := repository.NewClickWorkers(storageData, 1, 50*time.Millisecond, 10)
+	trending := repository.NewTrendingCounter(storageData, time.Second)
+	bots, err := service.NewBotClassifier(cfg)
+	if err != nil {
+		panic(err)
+	}
+	geo, err := service.NewGeoResolver(cfg)
+	if err != nil {
+		panic(err)
+	}
+	proxies, err := service.NewTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	return setupRouter(cfg, storageData, deleteWorker, audit, codes, aliases, urls, clickWorkers, bots, geo, proxies, trending, events)
+}
+
 func TestGenerateURL(t *testing.T) {
 	client, srv, cfg := setupTestServer()
 	defer srv.Close()
@@ -56,10 +101,17 @@ func TestGenerateURL(t *testing.T) {
 		{
 			name:        "успешное создание",
 			contentType: "text/plain",
-			body:        "https://example.com",
+			body:        "https://practicum.yandex.ru",
 			wantStatus:  http.StatusCreated,
 			wantPrefix:  cfg.ServerAddr,
 		},
+		{
+			name:        "повторное сокращение",
+			contentType: "text/plain",
+			body:        "https://example.com",
+			wantStatus:  http.StatusConflict,
+			wantPrefix:  cfg.ServerAddr + "qwerty",
+		},
 		{
 			name:        "пустое тело",
 			contentType: "text/plain",
@@ -75,8 +127,8 @@ func TestGenerateURL(t *testing.T) {
 				Post(srv.URL + "/")
 			assert.NoError(t, err)
 			assert.Equal(t, tt.wantStatus, resp.StatusCode())
-			if tt.wantStatus == http.StatusCreated {
-				assert.Contains(t, resp.String(), cfg.ServerAddr)
+			if tt.wantPrefix != "" {
+				assert.Contains(t, resp.String(), tt.wantPrefix)
 			}
 		})
 	}
@@ -98,7 +150,7 @@ func TestRedirectURL(t *testing.T) {
 			name:       "успешный редирект",
 			path:       "/qwerty",
 			wantStatus: http.StatusTemporaryRedirect,
-			wantLoc:    "https://example.com",
+			wantLoc:    "https://example.com/",
 		},
 		{
 			name:       "пустой код",
@@ -108,7 +160,7 @@ func TestRedirectURL(t *testing.T) {
 		{
 			name:       "неизвестный код",
 			path:       "/unknown",
-			wantStatus: http.StatusBadRequest,
+			wantStatus: http.StatusNotFound,
 		},
 	}
 
@@ -143,10 +195,16 @@ func TestJSONGenerateURL(t *testing.T) {
 	}{
 		{
 			name:       "успешное создание",
-			req:        model.JSONGenerateURLRequest{URL: "https://example.com"},
+			req:        model.JSONGenerateURLRequest{URL: "https://practicum.yandex.ru"},
 			wantStatus: http.StatusCreated,
 			wantPrefix: cfg.ServerAddr,
 		},
+		{
+			name:       "повторное сокращение",
+			req:        model.JSONGenerateURLRequest{URL: "https://example.com"},
+			wantStatus: http.StatusConflict,
+			wantPrefix: cfg.ServerAddr + "qwerty",
+		},
 		{
 			name:       "пустой url",
 			req:        model.JSONGenerateURLRequest{URL: ""},
@@ -162,6 +220,27 @@ func TestJSONGenerateURL(t *testing.T) {
 			req:        map[string]interface{}{},
 			wantStatus: http.StatusBadRequest,
 		},
+		{
+			name:       "свой алиас",
+			req:        model.JSONGenerateURLRequest{URL: "https://go.dev", Alias: "my-link"},
+			wantStatus: http.StatusCreated,
+			wantPrefix: cfg.ServerAddr + "my-link",
+		},
+		{
+			name:       "занятый алиас",
+			req:        model.JSONGenerateURLRequest{URL: "https://go.dev/doc", Alias: "qwerty"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "зарезервированный алиас",
+			req:        model.JSONGenerateURLRequest{URL: "https://go.dev/doc", Alias: "API"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "недопустимый алиас",
+			req:        model.JSONGenerateURLRequest{URL: "https://go.dev/doc", Alias: "my/link"},
+			wantStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -172,8 +251,7 @@ func TestJSONGenerateURL(t *testing.T) {
 				Post(srv.URL + "/api/shorten")
 			assert.NoError(t, err)
 			assert.Equal(t, tt.wantStatus, resp.StatusCode())
-			if tt.wantStatus == http.StatusCreated {
-				assert.Contains(t, resp.String(), cfg.ServerAddr)
+			if tt.wantPrefix != "" {
 				var result model.JSONGenerateURLResponse
 				err := json.Unmarshal(resp.Body(), &result)
 				assert.NoError(t, err)
@@ -183,3 +261,1077 @@ func TestJSONGenerateURL(t *testing.T) {
 		})
 	}
 }
+
+func TestBatchGenerateURLWithAliases(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	var result []model.BatchGenerateURLResponse
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]model.BatchGenerateURLRequest{
+			{CorrelationID: "1", URL: "https://go.dev/blog", Alias: "go-blog"},
+			{CorrelationID: "2", URL: "https://go.dev/play"},
+		}).
+		SetResult(&result).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	if assert.Len(t, result, 2) {
+		assert.Equal(t, cfg.ServerAddr+"go-blog", result[0].ShortURL)
+		assert.True(t, strings.HasPrefix(result[1].ShortURL, cfg.ServerAddr))
+	}
+
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]model.BatchGenerateURLRequest{
+			{CorrelationID: "1", URL: "https://go.dev/tour", Alias: "qwerty"},
+			{CorrelationID: "2", URL: "https://go.dev/learn"},
+		}).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+
+	resp, err = client.R().Get(srv.URL + "/go-blog")
+	var urlErr *url.Error
+	if err != nil && !(errors.As(err, &urlErr) && urlErr.Err.Error() == "auto redirect is disabled") {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "https://go.dev/blog", resp.Header().Get("Location"))
+}
+
+func TestAliasAvailable(t *testing.T) {
+	client, srv, _ := setupTestServer()
+	defer srv.Close()
+
+	// использованная одноразовая ссылка продолжает занимать свой алиас
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.JSONGenerateURLRequest{URL: "https://go.dev/doc/faq", Alias: "spent-once", MaxClicks: 1}).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	resp, err = client.R().Get(srv.URL + "/spent-once")
+	var urlErr *url.Error
+	if err != nil && !(errors.As(err, &urlErr) && urlErr.Err.Error() == "auto redirect is disabled") {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]model.BatchGenerateURLRequest{
+			{CorrelationID: "1", URL: "https://go.dev/tour", Alias: "spent-once"},
+			{CorrelationID: "2", URL: "https://go.dev/learn"},
+		}).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+
+	tests := []struct {
+		name            string
+		alias           string
+		wantAvailable   bool
+		wantSuggestions bool
+	}{
+		{name: "свободный алиас", alias: "free-alias", wantAvailable: true},
+		{name: "занятый алиас", alias: "qwerty", wantSuggestions: true},
+		{name: "зарезервированный алиас", alias: "ping", wantSuggestions: true},
+		{name: "алиас исчерпанной ссылки", alias: "spent-once", wantSuggestions: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result model.AliasAvailabilityResponse
+			resp, err := client.R().
+				SetResult(&result).
+				Get(srv.URL + "/api/shorten/alias/" + tt.alias + "/available")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode())
+			assert.Equal(t, tt.alias, result.Alias)
+			assert.Equal(t, tt.wantAvailable, result.Available)
+			if tt.wantSuggestions {
+				assert.NotEmpty(t, result.Reason)
+				assert.NotEmpty(t, result.Suggestions)
+				assert.NotContains(t, result.Suggestions, tt.alias)
+			}
+		})
+	}
+}
+
+func TestDedupPerUser(t *testing.T) {
+	alice, srv, cfg := setupTestServerWithConfig(&config.Config{DedupScope: storage.DedupPerUser})
+	defer srv.Close()
+	bob := resty.New()
+
+	resp, err := alice.R().SetBody("https://go.dev").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	aliceLink := resp.String()
+
+	// тот же пользователь получает свою ссылку
+	resp, err = alice.R().SetBody("https://go.dev").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	assert.Equal(t, aliceLink, resp.String())
+
+	// другой пользователь получает собственную ссылку
+	resp, err = bob.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://go.dev"}`).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.NotContains(t, resp.String(), aliceLink)
+
+	var urls []map[string]any
+	resp, err = bob.R().SetResult(&urls).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	if assert.Len(t, urls, 1) {
+		assert.NotEqual(t, aliceLink, urls[0]["short_url"])
+		assert.True(t, strings.HasPrefix(urls[0]["short_url"].(string), cfg.ServerAddr))
+	}
+}
+
+func TestBatchOwner(t *testing.T) {
+	client, srv, _ := setupTestServerWithConfig(&config.Config{})
+	defer srv.Close()
+
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"1","original_url":"https://go.dev"},{"correlation_id":"2","original_url":"https://pkg.go.dev"}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	// ссылки пакета принадлежат пользователю из cookie
+	var urls []map[string]any
+	resp, err = client.R().SetResult(&urls).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Len(t, urls, 2)
+}
+
+func TestCanonicalURL(t *testing.T) {
+	client, srv, _ := setupTestServerWithConfig(&config.Config{StripTrackingParams: true})
+	defer srv.Close()
+
+	resp, err := client.R().SetBody("HTTPS://Go.dev:443?utm_source=mail&b=2&a=1").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	link := resp.String()
+
+	// варианты одного адреса дают ту же ссылку
+	for _, variant := range []string{"https://go.dev/?a=1&b=2", "https://GO.DEV/?b=2&a=1&fbclid=x"} {
+		resp, err = client.R().SetBody(variant).Post(srv.URL + "/")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode(), variant)
+		assert.Equal(t, link, resp.String(), variant)
+	}
+
+	resp, err = client.R().Get(srv.URL + link[strings.LastIndex(link, "/"):])
+	if err != nil {
+		assert.ErrorContains(t, err, "auto redirect is disabled")
+	}
+	assert.Equal(t, "https://go.dev/?a=1&b=2", resp.Header().Get("Location"))
+
+	// в списке ссылок виден исходный адрес
+	var urls []model.UserURLsResponse
+	resp, err = client.R().SetResult(&urls).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	if assert.Len(t, urls, 1) {
+		assert.Equal(t, "HTTPS://Go.dev:443?utm_source=mail&b=2&a=1", urls[0].OriginalURL)
+	}
+}
+
+func TestUnsafeURL(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	for _, target := range []string{"javascript:alert(1)", "not a url", "http://192.168.0.1/admin", cfg.ServerAddr + "qwerty"} {
+		resp, err := client.R().SetBody(target).Post(srv.URL + "/")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), target)
+	}
+
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"data://example.com/text/html,hi"}`).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"1","original_url":"https://go.dev"},{"correlation_id":"2","original_url":"http://127.0.0.1/"}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+}
+
+func TestURLStats(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	resp, err := client.R().SetBody("https://go.dev/doc").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+
+	for _, referer := range []string{"https://News.example.com/item?id=1", "https://news.example.com/", ""} {
+		_, err = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().
+			SetHeader("Referer", referer).
+			Get(srv.URL + "/" + code)
+		if err != nil {
+			assert.ErrorContains(t, err, "auto redirect is disabled")
+		}
+	}
+
+	// клики сохраняются в фоне
+	var stats model.URLStatsResponse
+	assert.Eventually(t, func() bool {
+		resp, err := client.R().SetResult(&stats).Get(srv.URL + "/api/user/urls/" + code + "/stats")
+		return err == nil && resp.StatusCode() == http.StatusOK && stats.Total == 3
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 1, stats.Unique, "one visitor from one address")
+	assert.Equal(t, 1, stats.UniqueWeek)
+	assert.Equal(t, []model.StatCountResponse{{Key: time.Now().UTC().Format("2006-01-02"), Count: 1}}, stats.UniqueDays)
+	assert.Equal(t, []model.StatCountResponse{{Key: "news.example.com", Count: 2}, {Key: "", Count: 1}}, stats.Referrers)
+	if assert.Len(t, stats.Days, 1) {
+		assert.Equal(t, time.Now().UTC().Format("2006-01-02"), stats.Days[0].Key)
+	}
+
+	// чужой пользователь статистику не видит
+	resp, err = resty.New().R().Get(srv.URL + "/api/user/urls/" + code + "/stats")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+}
+
+func TestBotClicks(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	var created model.JSONGenerateURLResponse
+	resp, err := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.JSONGenerateURLRequest{URL: "https://go.dev/play", MaxClicks: 1}).
+		SetResult(&created).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(created.Result, cfg.ServerAddr)
+
+	// превью мессенджеров, префетчи и HEAD не тратят одноразовую ссылку
+	// и не получают адрес, по которому могли бы пройти без учёта
+	visitor := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R
+	requests := []*resty.Request{
+		visitor().SetHeader("User-Agent", "TelegramBot (like TwitterBot)"),
+		visitor().SetHeader("Purpose", "prefetch"),
+	}
+	for _, req := range requests {
+		resp, err = req.Get(srv.URL + "/" + code)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+		assert.Empty(t, resp.Header().Get("Location"))
+	}
+	resp, err = visitor().Head(srv.URL + "/" + code)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+	assert.Empty(t, resp.Header().Get("Location"))
+
+	resp, err = visitor().Get(srv.URL + "/" + code)
+	if err != nil {
+		assert.ErrorContains(t, err, "auto redirect is disabled")
+	}
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode(), "the person still gets through")
+
+	// боты учитываются отдельно от людей
+	var stats model.URLStatsResponse
+	assert.Eventually(t, func() bool {
+		resp, err := client.R().SetResult(&stats).Get(srv.URL + "/api/user/urls/" + code + "/stats")
+		return err == nil && resp.StatusCode() == http.StatusOK && stats.Total+stats.Bots == 4
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 1, stats.Total)
+	assert.Equal(t, 3, stats.Bots)
+	assert.Equal(t, 1, stats.Unique)
+
+	// ссылку без ограничения боты проходят как обычно
+	resp, err = client.R().SetBody("https://go.dev/blog").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	resp, err = visitor().SetHeader("User-Agent", "TelegramBot (like TwitterBot)").Get(srv.URL + "/" + strings.TrimPrefix(resp.String(), cfg.ServerAddr))
+	if err != nil {
+		assert.ErrorContains(t, err, "auto redirect is disabled")
+	}
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	assert.Equal(t, "https://go.dev/blog", resp.Header().Get("Location"))
+}
+
+func TestGeoClicks(t *testing.T) {
+	geoFile := filepath.Join(t.TempDir(), "geo.mmdb")
+	geotest.WriteMMDB(t, geoFile, map[string]geotest.Record{
+		"203.0.113.0/24":  {Country: "DE", Region: "BE", ASN: 3320},
+		"198.51.100.0/24": {Country: "FR", ASN: 3215},
+	})
+	client, srv, cfg := setupTestServerWithConfig(&config.Config{
+		GeoIPFile:      geoFile,
+		TrustedProxies: []string{"127.0.0.1"},
+	})
+	defer srv.Close()
+
+	resp, err := client.R().SetBody("https://go.dev/doc").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+
+	// адрес посетителя берётся из X-Forwarded-For доверенного прокси
+	for _, forwarded := range []string{"203.0.113.7", "10.1.1.1, 203.0.113.8", "198.51.100.1"} {
+		_, err = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().
+			SetHeader("User-Agent", "Mozilla/5.0").
+			SetHeader("X-Forwarded-For", forwarded).
+			Get(srv.URL + "/" + code)
+		if err != nil {
+			assert.ErrorContains(t, err, "auto redirect is disabled")
+		}
+	}
+
+	var stats model.URLStatsResponse
+	assert.Eventually(t, func() bool {
+		resp, err := client.R().SetResult(&stats).Get(srv.URL + "/api/user/urls/" + code + "/stats")
+		return err == nil && resp.StatusCode() == http.StatusOK && stats.Total == 3
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []model.StatCountResponse{{Key: "DE", Count: 2}, {Key: "FR", Count: 1}}, stats.Countries)
+	assert.Equal(t, []model.StatCountResponse{{Key: "DE-BE", Count: 2}, {Key: "", Count: 1}}, stats.Regions)
+	assert.Equal(t, []model.StatCountResponse{{Key: "3320", Count: 2}, {Key: "3215", Count: 1}}, stats.ASNs)
+}
+
+func TestTopLinks(t *testing.T) {
+	client, srv, cfg := setupTestServerWithConfig(&config.Config{AdminToken: "admin_secret"})
+	defer srv.Close()
+
+	shorten := func(c *resty.Client, target string) string {
+		resp, err := c.R().SetBody(target).Post(srv.URL + "/")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		return strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+	}
+	visit := func(code string, times int, userAgent string) {
+		for i := 0; i < times; i++ {
+			_, err := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().
+				SetHeader("User-Agent", userAgent).
+				Get(srv.URL + "/" + code)
+			if err != nil {
+				assert.ErrorContains(t, err, "auto redirect is disabled")
+			}
+		}
+	}
+	hot := shorten(client, "https://go.dev/hot")
+	cold := shorten(client, "https://go.dev/cold")
+	foreign := shorten(resty.New(), "https://go.dev/foreign")
+	visit(hot, 3, "Mozilla/5.0")
+	visit(cold, 1, "Mozilla/5.0")
+	visit(cold, 2, "TelegramBot (like TwitterBot)")
+	visit(foreign, 5, "Mozilla/5.0")
+
+	// переходы ботов в рейтинг не попадают, чужие ссылки не видны
+	var top model.TopLinksResponse
+	resp, err := client.R().SetResult(&top).Get(srv.URL + "/api/user/stats/top?window=5m")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "5m", top.Window)
+	assert.Equal(t, []model.TopLinkResponse{
+		{ShortURL: cfg.ServerAddr + hot, OriginalURL: "https://go.dev/hot", Clicks: 3},
+		{ShortURL: cfg.ServerAddr + cold, OriginalURL: "https://go.dev/cold", Clicks: 1},
+	}, top.Links)
+
+	resp, err = client.R().SetResult(&top).Get(srv.URL + "/api/user/stats/top?limit=1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "1h", top.Window)
+	assert.Len(t, top.Links, 1)
+
+	for _, query := range []string{"window=2h", "limit=0", "limit=1000", "limit=ten"} {
+		resp, err = client.R().Get(srv.URL + "/api/user/stats/top?" + query)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), query)
+	}
+
+	// общий рейтинг доступен только с токеном администратора
+	for _, token := range []string{"", "wrong"} {
+		resp, err = resty.New().R().SetAuthToken(token).Get(srv.URL + "/api/admin/stats/top")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+	}
+	resp, err = resty.New().R().SetAuthToken("admin_secret").SetResult(&top).Get(srv.URL + "/api/admin/stats/top?window=24h")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	if assert.Len(t, top.Links, 3) {
+		assert.Equal(t, cfg.ServerAddr+foreign, top.Links[0].ShortURL)
+		assert.Equal(t, 5, top.Links[0].Clicks)
+		assert.NotZero(t, top.Links[0].UserID)
+		assert.NotEqual(t, top.Links[0].UserID, top.Links[1].UserID)
+	}
+
+	// сохранённые и ещё не сброшенные переходы складываются без повторов
+	visit(hot, 2, "Mozilla/5.0")
+	for range 15 {
+		resp, err = client.R().SetResult(&top).Get(srv.URL + "/api/user/stats/top?limit=1")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		if assert.Len(t, top.Links, 1) {
+			assert.Equal(t, 5, top.Links[0].Clicks)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	_, srv, _ := setupTestServer()
+	defer srv.Close()
+
+	resp, err := resty.New().R().SetAuthToken("").Get(srv.URL + "/api/admin/stats/top")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode(), "without a token the admin API is off")
+}
+
+// readEvent читает из потока следующее событие или комментарий
+func readEvent(t *testing.T, r *bufio.Reader) (name string, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return "", ""
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && (name != "" || data != ""):
+			return name, data
+		case strings.HasPrefix(line, ":"):
+			name = line
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestClickEvents(t *testing.T) {
+	client, srv, cfg := setupTestServerWithConfig(&config.Config{EventsHeartbeat: 1})
+	defer srv.Close()
+
+	shorten := func(c *resty.Client, target string) string {
+		resp, err := c.R().SetBody(target).Post(srv.URL + "/")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		return strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	subscribe := func(path string) *bufio.Reader {
+		resp, err := client.R().SetContext(ctx).SetDoNotParseResponse(true).Get(srv.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		assert.Equal(t, "text/event-stream", resp.Header().Get("Content-Type"))
+		t.Cleanup(func() { resp.RawBody().Close() })
+		return bufio.NewReader(resp.RawBody())
+	}
+	first := shorten(client, "https://go.dev/first")
+	second := shorten(client, "https://go.dev/second")
+	foreign := shorten(resty.New(), "https://go.dev/foreign")
+
+	// на чужие и несуществующие ссылки подписаться нельзя
+	for _, code := range []string{foreign, "missing"} {
+		resp, err := client.R().Get(srv.URL + "/api/user/urls/" + code + "/events")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode(), code)
+	}
+
+	linkStream := subscribe("/api/user/urls/" + first + "/events")
+	userStream := subscribe("/api/user/events")
+	for _, code := range []string{foreign, first, second} {
+		_, err := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().
+			SetHeader("Referer", "https://news.example.com/post").
+			Get(srv.URL + "/" + code)
+		if err != nil {
+			assert.ErrorContains(t, err, "auto redirect is disabled")
+		}
+	}
+
+	// поток ссылки получает только её переходы, поток пользователя — переходы всех его ссылок
+	var click model.ClickEventResponse
+	name, data := readEvent(t, linkStream)
+	assert.Equal(t, "click", name)
+	assert.NoError(t, json.Unmarshal([]byte(data), &click))
+	assert.Equal(t, cfg.ServerAddr+first, click.ShortURL)
+	assert.Equal(t, "news.example.com", click.Referrer)
+	assert.False(t, click.Bot)
+	assert.WithinDuration(t, time.Now(), click.TS, 5*time.Second)
+
+	for _, code := range []string{first, second} {
+		name, data = readEvent(t, userStream)
+		assert.Equal(t, "click", name)
+		assert.NoError(t, json.Unmarshal([]byte(data), &click))
+		assert.Equal(t, cfg.ServerAddr+code, click.ShortURL)
+	}
+
+	// в тишине соединение поддерживается комментариями
+	name, _ = readEvent(t, linkStream)
+	assert.Equal(t, ": keepalive", name)
+}
+
+func TestClickSeries(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	resp, err := client.R().SetBody("https://go.dev/blog").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+
+	for i := 0; i < 2; i++ {
+		_, err = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(srv.URL + "/" + code)
+		if err != nil {
+			assert.ErrorContains(t, err, "auto redirect is disabled")
+		}
+	}
+
+	// почасовой ряд за последние сутки, пустые часы заполнены нулями
+	var series model.TimeseriesResponse
+	assert.Eventually(t, func() bool {
+		resp, err := client.R().SetResult(&series).Get(srv.URL + "/api/user/stats/timeseries?interval=hour")
+		return err == nil && resp.StatusCode() == http.StatusOK &&
+			len(series.Points) > 0 && series.Points[len(series.Points)-1].Clicks == 2
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "hour", series.Interval)
+	assert.Len(t, series.Points, 25, "a partial hour on each end")
+	assert.Equal(t, time.Now().UTC().Truncate(time.Hour), series.Points[len(series.Points)-1].TS.UTC())
+
+	// по дням и по одной ссылке
+	resp, err = client.R().SetResult(&series).
+		SetQueryParams(map[string]string{"code": code, "from": time.Now().UTC().Format(time.DateOnly)}).
+		Get(srv.URL + "/api/user/stats/timeseries")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "day", series.Interval)
+	if assert.Len(t, series.Points, 1) {
+		assert.Equal(t, 2, series.Points[0].Clicks)
+	}
+
+	for _, query := range []string{"interval=week", "from=yesterday", "from=2025-03-02&to=2025-03-01", "interval=hour&from=2020-01-01&to=2025-01-01"} {
+		resp, err = client.R().Get(srv.URL + "/api/user/stats/timeseries?" + query)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), query)
+	}
+
+	// чужой пользователь не видит ряд ссылки
+	resp, err = resty.New().R().Get(srv.URL + "/api/user/stats/timeseries?code=" + code)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+}
+
+func TestDeleteUserURLs(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	resp, err := client.R().
+		SetBody("https://go.dev").
+		Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+
+	// чужой пользователь не может удалить ссылку
+	resp, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]string{"qwerty"}).
+		Delete(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]string{code}).
+		Delete(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+
+	assert.Eventually(t, func() bool {
+		resp, _ := client.R().Get(srv.URL + "/" + code)
+		return resp.StatusCode() == http.StatusGone
+	}, 5*time.Second, 100*time.Millisecond)
+
+	resp, _ = client.R().Get(srv.URL + "/qwerty")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+}
+
+func TestRestoreURLs(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	resp, err := client.R().SetBody("https://go.dev/play/").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]string{code}).
+		Delete(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+	assert.Eventually(t, func() bool {
+		resp, _ := client.R().Get(srv.URL + "/" + code)
+		return resp.StatusCode() == http.StatusGone
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// чужой пользователь не может восстановить ссылку
+	var restored []string
+	resp, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]string{code}).
+		SetResult(&restored).
+		Post(srv.URL + "/api/user/urls/restore")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Empty(t, restored)
+
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody([]string{code, "qwerty"}).
+		SetResult(&restored).
+		Post(srv.URL + "/api/user/urls/restore")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, []string{code}, restored)
+
+	resp, _ = client.R().Get(srv.URL + "/" + code)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+}
+
+func TestLinkExpiry(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	resp, err := client.R().
+		SetBody("https://go.dev/ref/spec").
+		Post(srv.URL + "/?ttl=bad")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = client.R().
+		SetBody("https://go.dev/ref/spec").
+		Post(srv.URL + "/?ttl=1h")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	var created model.JSONGenerateURLResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.JSONGenerateURLRequest{URL: "https://go.dev/ref/mem", TTL: "1s"}).
+		SetResult(&created).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(created.Result, cfg.ServerAddr)
+
+	var urls []model.UserURLsResponse
+	resp, err = client.R().SetResult(&urls).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	if assert.Len(t, urls, 2) {
+		for _, u := range urls {
+			assert.NotNil(t, u.ExpiresAt, "срок жизни виден владельцу")
+		}
+	}
+
+	// истёкшая ссылка отдаёт 410
+	assert.Eventually(t, func() bool {
+		resp, _ := client.R().Get(srv.URL + "/" + code)
+		return resp.StatusCode() == http.StatusGone
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestMaxClicks(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	resp, err := client.R().
+		SetBody("https://go.dev/doc/").
+		Post(srv.URL + "/?max_clicks=-1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	var created model.JSONGenerateURLResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.JSONGenerateURLRequest{URL: "https://go.dev/doc/faq", MaxClicks: 1}).
+		SetResult(&created).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(created.Result, cfg.ServerAddr)
+
+	// одноразовая ссылка срабатывает один раз, потом отдаёт 410
+	resp, err = client.R().Get(srv.URL + "/" + code)
+	var urlErr *url.Error
+	if err != nil && !(errors.As(err, &urlErr) && urlErr.Err.Error() == "auto redirect is disabled") {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	resp, err = client.R().Get(srv.URL + "/" + code)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, resp.StatusCode())
+
+	var urls []model.UserURLsResponse
+	resp, err = client.R().SetResult(&urls).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	if assert.Equal(t, http.StatusOK, resp.StatusCode()) && assert.Len(t, urls, 1) {
+		assert.Equal(t, 1, urls[0].MaxClicks)
+		assert.Equal(t, 1, urls[0].Clicks)
+	}
+
+	// исчерпанная ссылка не мешает сократить тот же URL снова
+	var again model.JSONGenerateURLResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.JSONGenerateURLRequest{URL: "https://go.dev/doc/faq"}).
+		SetResult(&again).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.NotEqual(t, created.Result, again.Result)
+}
+
+func TestEditURL(t *testing.T) {
+	client, srv, cfg := setupTestServer()
+	defer srv.Close()
+
+	resp, err := client.R().SetBody("https://go.dev/doc/effective_go").Post(srv.URL + "/")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	code := strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+	link := srv.URL + "/api/user/urls/" + code
+
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantOriginal string
+	}{
+		{"недопустимый URL", `{"url":"not a url"}`, http.StatusBadRequest, ""},
+		{"новый адрес", `{"url":"https://go.dev/doc/code"}`, http.StatusOK, "https://go.dev/doc/code"},
+		{"адрес в другом написании", `{"url":"HTTPS://Go.dev/doc/modules"}`, http.StatusOK, "HTTPS://Go.dev/doc/modules"},
+		{"тот же адрес в новом написании", `{"url":"https://GO.DEV/doc/modules"}`, http.StatusOK, "https://GO.DEV/doc/modules"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated model.UserURLsResponse
+			resp, err := client.R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(tt.body).
+				SetResult(&updated).
+				Patch(link)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode())
+			// в ответе виден адрес в том виде, в каком его прислали
+			assert.Equal(t, tt.wantOriginal, updated.OriginalURL)
+		})
+	}
+
+	var history []model.URLVersionResponse
+	resp, err = client.R().SetResult(&history).Get(link + "/history")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, "https://go.dev/doc/effective_go", history[0].OriginalURL)
+		assert.NotNil(t, history[0].ReplacedAt)
+		assert.Nil(t, history[2].ReplacedAt, "текущая версия не заменена")
+	}
+
+	// откат на первую версию
+	var rolledBack model.UserURLsResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.RollbackURLRequest{Version: 1}).
+		SetResult(&rolledBack).
+		Post(link + "/rollback")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "https://go.dev/doc/effective_go", rolledBack.OriginalURL)
+
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.RollbackURLRequest{Version: 10}).
+		Post(link + "/rollback")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	resp, err = client.R().Get(srv.URL + "/" + code)
+	var urlErr *url.Error
+	if err != nil && !(errors.As(err, &urlErr) && urlErr.Err.Error() == "auto redirect is disabled") {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "https://go.dev/doc/effective_go", resp.Header().Get("Location"))
+
+	// откат возвращает адрес в том написании, в каком он был
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(model.RollbackURLRequest{Version: 3}).
+		SetResult(&rolledBack).
+		Post(link + "/rollback")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "https://GO.DEV/doc/modules", rolledBack.OriginalURL)
+
+	// чужой пользователь ссылку не видит
+	stranger := resty.New()
+	resp, err = stranger.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://evil.example.com"}`).
+		Patch(link)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+	resp, err = stranger.R().Get(link + "/history")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+}
+
+func TestRedirectTypes(t *testing.T) {
+	client, srv, cfg := setupTestServerWithConfig(&config.Config{RedirectType: http.StatusFound, RedirectMaxAge: 3600})
+	defer srv.Close()
+
+	shorten := func(body string) string {
+		resp, err := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Post(srv.URL + "/api/shorten")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode(), body)
+		var result model.JSONGenerateURLResponse
+		assert.NoError(t, json.Unmarshal(resp.Body(), &result))
+		return strings.TrimPrefix(result.Result, cfg.ServerAddr)
+	}
+	follow := func(code string) *resty.Response {
+		resp, err := client.R().Get(srv.URL + "/" + code)
+		if err != nil {
+			assert.ErrorContains(t, err, "auto redirect is disabled")
+		}
+		return resp
+	}
+
+	resp, err := client.R().SetBody("https://go.dev/moved").Post(srv.URL + "/?redirect_type=301")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	moved := strings.TrimPrefix(resp.String(), cfg.ServerAddr)
+	plain := shorten(`{"url":"https://go.dev/plain"}`)
+	expiring := shorten(`{"url":"https://go.dev/expiring","redirect_type":308,"ttl":"30m"}`)
+	limited := shorten(`{"url":"https://go.dev/limited","redirect_type":308,"max_clicks":5}`)
+
+	// постоянные редиректы кэшируются не дольше, чем живёт ссылка; временные и ограниченные — никогда
+	tests := []struct {
+		name         string
+		code         string
+		wantStatus   int
+		wantCache    string
+		wantLocation string
+	}{
+		{"тип по умолчанию", plain, http.StatusFound, "no-store", "https://go.dev/plain"},
+		{"постоянный редирект", moved, http.StatusMovedPermanently, "public, max-age=3600", "https://go.dev/moved"},
+		{"ссылка с ограниченным числом переходов", limited, http.StatusPermanentRedirect, "no-store", "https://go.dev/limited"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := follow(tt.code)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode())
+			assert.Equal(t, tt.wantCache, resp.Header().Get("Cache-Control"))
+			assert.Equal(t, tt.wantLocation, resp.Header().Get("Location"))
+		})
+	}
+	resp = follow(expiring)
+	assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode())
+	var maxAge int
+	_, err = fmt.Sscanf(resp.Header().Get("Cache-Control"), "public, max-age=%d", &maxAge)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1800, maxAge, 5, "кэш не переживает срок ссылки")
+
+	// недопустимые типы отклоняются при создании и изменении
+	resp, err = client.R().SetBody("https://go.dev/see-other").Post(srv.URL + "/?redirect_type=303")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	for path, body := range map[string]string{
+		"/api/shorten":       `{"url":"https://go.dev/ok","redirect_type":200}`,
+		"/api/shorten/batch": `[{"correlation_id":"1","original_url":"https://go.dev/ok","redirect_type":999}]`,
+	} {
+		resp, err = client.R().SetHeader("Content-Type", "application/json").SetBody(body).Post(srv.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), path)
+	}
+
+	link := srv.URL + "/api/user/urls/" + moved
+	for _, body := range []string{`{"redirect_type":303}`, `{}`} {
+		resp, err = client.R().SetHeader("Content-Type", "application/json").SetBody(body).Patch(link)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), body)
+	}
+
+	// тип меняется без смены адреса, 0 возвращает тип по умолчанию
+	var updated model.UserURLsResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"redirect_type":307}`).
+		SetResult(&updated).
+		Patch(link)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, http.StatusTemporaryRedirect, updated.RedirectType)
+	assert.Equal(t, "https://go.dev/moved", updated.OriginalURL)
+	assert.Equal(t, http.StatusTemporaryRedirect, follow(moved).StatusCode())
+
+	// занятый адрес отклоняет всё изменение, тип остаётся прежним
+	shorten(`{"url":"https://go.dev/taken"}`)
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://go.dev/taken","redirect_type":308}`).
+		Patch(link)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	resp = follow(moved)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	assert.Equal(t, "https://go.dev/moved", resp.Header().Get("Location"))
+
+	var reset model.UserURLsResponse
+	resp, err = client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://go.dev/moved-again","redirect_type":0}`).
+		SetResult(&reset).
+		Patch(link)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Zero(t, reset.RedirectType)
+	resp = follow(moved)
+	assert.Equal(t, http.StatusFound, resp.StatusCode())
+	assert.Equal(t, "https://go.dev/moved-again", resp.Header().Get("Location"))
+
+	var urls []model.UserURLsResponse
+	resp, err = client.R().SetResult(&urls).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	types := make(map[string]int)
+	for _, u := range urls {
+		types[u.OriginalURL] = u.RedirectType
+	}
+	assert.Equal(t, map[string]int{
+		"https://go.dev/moved-again": 0,
+		"https://go.dev/plain":       0,
+		"https://go.dev/taken":       0,
+		"https://go.dev/expiring":    http.StatusPermanentRedirect,
+		"https://go.dev/limited":     http.StatusPermanentRedirect,
+	}, types)
+}
+
+// countingStorage counts the user lookups
+type countingStorage struct {
+	storage.Storage
+	lookups atomic.Int32
+}
+
+func (s *countingStorage) GetUser(ctx context.Context, id int) (storage.User, error) {
+	s.lookups.Add(1)
+	return s.Storage.GetUser(ctx, id)
+}
+
+func TestUserLookups(t *testing.T) {
+	cfg := &config.Config{ServerAddr: "http://localhost:8080/", SecretKey: "test_secret_key", TokenExp: 1}
+	store := &countingStorage{Storage: storage.NewMemoryStorage()}
+	srv := httptest.NewServer(setupTestRouter(cfg, store))
+	defer srv.Close()
+	client := resty.New()
+
+	resp, err := client.R().Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+	cookies := resp.Cookies()
+	assert.NotEmpty(t, cookies)
+
+	// созданный пользователь известен без обращения к хранилищу
+	for range 3 {
+		resp, err = client.R().SetCookies(cookies).Get(srv.URL + "/api/user/urls")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+		assert.Empty(t, resp.Cookies(), "the token is still valid")
+	}
+	assert.Zero(t, store.lookups.Load())
+
+	// токен пользователя, которого нет в хранилище, заменяется новым
+	token, err := auth.BuildJWTString(cfg.SecretKey, time.Hour, 999)
+	assert.NoError(t, err)
+	stale := []*http.Cookie{{Name: "jwt_token", Value: token}}
+	resp, err = client.R().SetCookies(stale).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+	assert.NotEmpty(t, resp.Cookies())
+	assert.EqualValues(t, 1, store.lookups.Load())
+
+	// существующий пользователь проверяется один раз
+	existing, err := store.CreateUser(context.Background())
+	assert.NoError(t, err)
+	token, err = auth.BuildJWTString(cfg.SecretKey, time.Hour, existing.ID)
+	assert.NoError(t, err)
+	for range 3 {
+		resp, err = client.R().SetCookies([]*http.Cookie{{Name: "jwt_token", Value: token}}).Get(srv.URL + "/api/user/urls")
+		assert.NoError(t, err)
+		assert.Empty(t, resp.Cookies())
+	}
+	assert.EqualValues(t, 2, store.lookups.Load())
+}
+
+// unavailableStorage fails every read as if the database were down
+type unavailableStorage struct {
+	storage.Storage
+}
+
+func (unavailableStorage) GetURL(context.Context, string) (storage.URL, error) {
+	return storage.URL{}, &storage.TransientError{Err: errors.New("connection refused")}
+}
+
+func (unavailableStorage) FollowURL(context.Context, string) (storage.URL, error) {
+	return storage.URL{}, &storage.TransientError{Err: errors.New("connection refused")}
+}
+
+func (unavailableStorage) Ping(context.Context) error {
+	return &storage.TransientError{Err: errors.New("connection refused")}
+}
+
+func TestStorageUnavailable(t *testing.T) {
+	cfg := &config.Config{ServerAddr: "http://localhost:8080/", SecretKey: "test_secret_key", TokenExp: 1}
+	store := unavailableStorage{Storage: storage.NewMemoryStorage()}
+	deleteWorker := repository.NewDeleteURLsWorkers(store, 1, time.Second, 10)
+	codes, err := service.NewCodeGenerator(cfg, store)
+	assert.NoError(t, err)
+	aliases, err := service.NewAliasPolicy(cfg)
+	assert.NoError(t, err)
+	urls, err := service.NewURLPolicy(cfg)
+	assert.NoError(t, err)
+	clickWorkers := repository.NewClickWorkers(store, 1, time.Second, 10)
+	trending := repository.NewTrendingCounter(store, time.Second)
+	bots, err := service.NewBotClassifier(cfg)
+	assert.NoError(t, err)
+	geo, err := service.NewGeoResolver(cfg)
+	assert.NoError(t, err)
+	proxies, err := service.NewTrustedProxies(nil)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(setupRouter(cfg, store, deleteWorker, repository.NewAuditPublisher(10), codes, aliases, urls, clickWorkers, bots, geo, proxies, trending, repository.NewClickStream(0)))
+	defer srv.Close()
+
+	client := resty.New()
+	client.SetRedirectPolicy(resty.NoRedirectPolicy())
+
+	for _, path := range []string{"/qwerty", "/ping"} {
+		resp, err := client.R().Get(srv.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode(), path)
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"), path)
+	}
+}
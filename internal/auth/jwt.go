@@ -79,6 +79,7 @@ func SetTokenInCookie(w http.ResponseWriter, token string, ttl time.Duration) {
 	cookie := &http.Cookie{
 		Name:     cookieUserJWT,
 		Value:    token,
+		Path:     "/",
 		HttpOnly: true,
 		Expires:  time.Now().Add(ttl),
 	}
@@ -102,11 +103,19 @@ func GetUserByCookie(r *http.Request, secretKey string) (storage.User, error) {
 	return storage.User{ID: userID}, nil
 }
 
-// GetOrCreateUser retrieves the user from the request cookie or creates a new user if not found.
-func GetOrCreateUser(w http.ResponseWriter, r *http.Request, store storage.Storage, secretKey string, tokenExp time.Duration) (storage.User, error) {
+// GetOrCreateUser retrieves the user from the request cookie or creates a new user
+// if there is no valid token or the user it names is no longer stored.
+// Users already in known are trusted without a storage lookup.
+func GetOrCreateUser(w http.ResponseWriter, r *http.Request, store storage.Storage, known *KnownUsers, secretKey string, tokenExp time.Duration) (storage.User, error) {
 	user, err := GetUserByCookie(r, secretKey)
+	if err == nil && !known.Has(user.ID) {
+		user, err = store.GetUser(r.Context(), user.ID)
+		if err == nil {
+			known.Add(user.ID)
+		}
+	}
 	if err != nil {
-		if errors.Is(err, ErrNoJWTInCookie) || errors.Is(err, ErrInvalidJWTToken) {
+		if errors.Is(err, ErrNoJWTInCookie) || errors.Is(err, ErrInvalidJWTToken) || errors.Is(err, storage.ErrUserNotFound) {
 			var tokenString string
 			user, err = store.CreateUser(r.Context())
 			if err != nil {
@@ -117,6 +126,7 @@ func GetOrCreateUser(w http.ResponseWriter, r *http.Request, store storage.Stora
 				return storage.User{}, err
 			}
 			SetTokenInCookie(w, tokenString, tokenExp)
+			known.Add(user.ID)
 			return user, nil
 		}
 		return storage.User{}, err
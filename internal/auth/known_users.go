@@ -0,0 +1,48 @@
+package auth
+
+import "sync"
+
+// defaultKnownUsers bounds the cache of user IDs confirmed in storage
+const defaultKnownUsers = 100_000
+
+// KnownUsers remembers the user IDs already found in storage, so that a
+// valid token costs one storage lookup per user instead of one per request.
+// Users are never deleted, a remembered ID stays valid; when the cache is
+// full it starts over.
+type KnownUsers struct {
+	mu  sync.RWMutex
+	ids map[int]struct{}
+	max int
+}
+
+// NewKnownUsers creates a cache of at most max user IDs, 0 means the default
+func NewKnownUsers(max int) *KnownUsers {
+	if max <= 0 {
+		max = defaultKnownUsers
+	}
+	return &KnownUsers{ids: make(map[int]struct{}), max: max}
+}
+
+// Has reports whether id was found in storage before
+func (k *KnownUsers) Has(id int) bool {
+	if k == nil {
+		return false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	_, ok := k.ids[id]
+	return ok
+}
+
+// Add remembers that id exists in storage
+func (k *KnownUsers) Add(id int) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.ids) >= k.max {
+		clear(k.ids)
+	}
+	k.ids[id] = struct{}{}
+}
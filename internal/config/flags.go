@@ -9,12 +9,14 @@ import (
 func ParseFlags(cfg *Config, onlyEmpty bool) {
 	var runAddr string
 	var serverAddr = "http://localhost:8080/"
+	var storageDSN string
 	var dataFilePath string
 	var databaseDsn string
 	var auditFile string
 	var auditURL string
 
 	flag.StringVar(&runAddr, "a", ":8080", "address and port to run server")
+	flag.StringVar(&storageDSN, "storage-dsn", "", "storage DSN, e.g. memory://, file:///path, sqlite://path, postgres://...")
 	flag.StringVar(&dataFilePath, "f", "", "saved urls path")
 	flag.StringVar(&databaseDsn, "d", "", "database")
 	flag.Func("b", "server address before short URL", func(s string) error {
@@ -36,6 +38,9 @@ func ParseFlags(cfg *Config, onlyEmpty bool) {
 	if onlyEmpty && cfg.ServerAddr == "" {
 		cfg.ServerAddr = serverAddr
 	}
+	if onlyEmpty && cfg.StorageDSN == "" {
+		cfg.StorageDSN = storageDSN
+	}
 	if onlyEmpty && cfg.DataFilePath == "" {
 		cfg.DataFilePath = dataFilePath
 	}
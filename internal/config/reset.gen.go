@@ -10,10 +10,14 @@ func (c *Config) Reset() {
 
 	c.ServerAddr = ""
 
+	c.StorageDSN = ""
+
 	c.DataFilePath = ""
 
 	c.DatabaseDsn = ""
 
+	c.SQLitePath = ""
+
 	c.MigrationsPath = ""
 
 	c.DevMode = false
@@ -30,4 +34,82 @@ func (c *Config) Reset() {
 
 	c.AuditURL = ""
 
+	c.ShutdownTimeout = 0
+
+	c.FileSyncPolicy = ""
+
+	c.FileSyncInterval = 0
+
+	c.FileCompactInterval = 0
+
+	c.CodeStrategy = ""
+
+	c.CodeLength = 0
+
+	c.CodeAlphabet = ""
+
+	c.CodeMaxAttempts = 0
+
+	c.CodeLeaseSize = 0
+
+	c.CodeLeaseEncoding = ""
+
+	c.AliasAlphabet = ""
+
+	c.AliasMinLength = 0
+
+	c.AliasMaxLength = 0
+
+	c.AliasReserved = c.AliasReserved[:0]
+
+	c.RestoreGracePeriod = 0
+
+	c.PurgeRetention = 0
+
+	c.PurgeInterval = 0
+
+	c.DedupScope = ""
+
+	c.StripTrackingParams = false
+
+	c.URLSchemes = c.URLSchemes[:0]
+
+	c.URLMaxLength = 0
+
+	c.URLDomainsFile = ""
+
+	c.URLDomainsInterval = 0
+
+	c.ClickBatchSize = 0
+
+	c.ClickFlushInterval = 0
+
+	c.ClickRetention = 0
+
+	c.DownsampleInterval = 0
+
+	c.BotPatternsFile = ""
+
+	c.BotPatternsInterval = 0
+
+	c.GeoIPFile = ""
+
+	c.GeoIPASNFile = ""
+
+	c.GeoIPInterval = 0
+
+	c.TrustedProxies = c.TrustedProxies[:0]
+
+	c.TrendingInterval = 0
+
+	c.AdminToken = ""
+
+	c.EventsBuffer = 0
+
+	c.EventsHeartbeat = 0
+
+	c.RedirectType = 0
+
+	c.RedirectMaxAge = 0
+
 }
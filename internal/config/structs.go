@@ -6,36 +6,114 @@ package config
 // Config variables
 // generate:reset
 type Config struct {
-	RunAddr            string `env:"SERVER_ADDRESS"`
-	ServerAddr         string `env:"BASE_URL"`
-	DataFilePath       string `env:"FILE_STORAGE_PATH"`
-	DatabaseDsn        string `env:"DATABASE_DSN"`
-	MigrationsPath     string `env:"MIGRATIONS_PATH"`
-	DevMode            bool   `env:"DEV_MODE"`
-	SecretKey          string `env:"SECRET_KEY"`
-	TokenExp           int    `env:"TOKEN_EXP"`
-	DeleteBachSize     int    `env:"DELETE_BACH_SIZE"`
-	DeleteTimeDuration int    `env:"DELETE_TIME_DURATION"`
-	AuditFile          string `env:"AUDIT_FILE"`
-	AuditURL           string `env:"AUDIT_URL"`
-	ShutdownTimeout    int    `env:"SHUTDOWN_TIMEOUT"`
+	RunAddr             string   `env:"SERVER_ADDRESS"`
+	ServerAddr          string   `env:"BASE_URL"`
+	StorageDSN          string   `env:"STORAGE_DSN"`
+	DataFilePath        string   `env:"FILE_STORAGE_PATH"`
+	DatabaseDsn         string   `env:"DATABASE_DSN"`
+	SQLitePath          string   `env:"SQLITE_PATH"`
+	MigrationsPath      string   `env:"MIGRATIONS_PATH"`
+	DevMode             bool     `env:"DEV_MODE"`
+	SecretKey           string   `env:"SECRET_KEY"`
+	TokenExp            int      `env:"TOKEN_EXP"`
+	DeleteBachSize      int      `env:"DELETE_BACH_SIZE"`
+	DeleteTimeDuration  int      `env:"DELETE_TIME_DURATION"`
+	AuditFile           string   `env:"AUDIT_FILE"`
+	AuditURL            string   `env:"AUDIT_URL"`
+	ShutdownTimeout     int      `env:"SHUTDOWN_TIMEOUT"`
+	FileSyncPolicy      string   `env:"FILE_SYNC_POLICY"`
+	FileSyncInterval    int      `env:"FILE_SYNC_INTERVAL"`
+	FileCompactInterval int      `env:"FILE_COMPACT_INTERVAL"`
+	CodeStrategy        string   `env:"CODE_STRATEGY"`
+	CodeLength          int      `env:"CODE_LENGTH"`
+	CodeAlphabet        string   `env:"CODE_ALPHABET"`
+	CodeMaxAttempts     int      `env:"CODE_MAX_ATTEMPTS"`
+	CodeLeaseSize       int      `env:"CODE_LEASE_SIZE"`
+	CodeLeaseEncoding   string   `env:"CODE_LEASE_ENCODING"`
+	AliasAlphabet       string   `env:"ALIAS_ALPHABET"`
+	AliasMinLength      int      `env:"ALIAS_MIN_LENGTH"`
+	AliasMaxLength      int      `env:"ALIAS_MAX_LENGTH"`
+	AliasReserved       []string `env:"ALIAS_RESERVED" envSeparator:","`
+	RestoreGracePeriod  int      `env:"RESTORE_GRACE_PERIOD"`
+	PurgeRetention      int      `env:"PURGE_RETENTION"`
+	PurgeInterval       int      `env:"PURGE_INTERVAL"`
+	DedupScope          string   `env:"DEDUP_SCOPE"`
+	StripTrackingParams bool     `env:"STRIP_TRACKING_PARAMS"`
+	URLSchemes          []string `env:"URL_SCHEMES" envSeparator:","`
+	URLMaxLength        int      `env:"URL_MAX_LENGTH"`
+	URLDomainsFile      string   `env:"URL_DOMAINS_FILE"`
+	URLDomainsInterval  int      `env:"URL_DOMAINS_INTERVAL"`
+	ClickBatchSize      int      `env:"CLICK_BATCH_SIZE"`
+	ClickFlushInterval  int      `env:"CLICK_FLUSH_INTERVAL"`
+	ClickRetention      int      `env:"CLICK_RETENTION"`
+	DownsampleInterval  int      `env:"DOWNSAMPLE_INTERVAL"`
+	BotPatternsFile     string   `env:"BOT_PATTERNS_FILE"`
+	BotPatternsInterval int      `env:"BOT_PATTERNS_INTERVAL"`
+	GeoIPFile           string   `env:"GEOIP_FILE"`
+	GeoIPASNFile        string   `env:"GEOIP_ASN_FILE"`
+	GeoIPInterval       int      `env:"GEOIP_INTERVAL"`
+	TrustedProxies      []string `env:"TRUSTED_PROXIES" envSeparator:","`
+	TrendingInterval    int      `env:"TRENDING_INTERVAL"`
+	AdminToken          string   `env:"ADMIN_TOKEN"`
+	EventsBuffer        int      `env:"EVENTS_BUFFER"`
+	EventsHeartbeat     int      `env:"EVENTS_HEARTBEAT"`
+	RedirectType        int      `env:"REDIRECT_TYPE"`
+	RedirectMaxAge      int      `env:"REDIRECT_MAX_AGE"`
 }
 
 // NewConfig create Config
 func NewConfig() *Config {
 	var cfg = Config{
-		RunAddr:            "",
-		ServerAddr:         "",
-		DataFilePath:       "",
-		DatabaseDsn:        "",
-		MigrationsPath:     "./migrations",
-		DevMode:            false,
-		SecretKey:          "my_secret_key",
-		TokenExp:           3,
-		DeleteTimeDuration: 5,
-		DeleteBachSize:     50,
-		AuditFile:          "./audit_data.json",
-		AuditURL:           "",
+		RunAddr:             "",
+		ServerAddr:          "",
+		StorageDSN:          "",
+		DataFilePath:        "",
+		DatabaseDsn:         "",
+		SQLitePath:          "",
+		MigrationsPath:      "./migrations",
+		DevMode:             false,
+		SecretKey:           "my_secret_key",
+		TokenExp:            3,
+		DeleteTimeDuration:  5,
+		DeleteBachSize:      50,
+		AuditFile:           "./audit_data.json",
+		AuditURL:            "",
+		FileSyncPolicy:      "interval",
+		FileSyncInterval:    1,
+		FileCompactInterval: 300,
+		CodeStrategy:        "random",
+		CodeLength:          6,
+		CodeAlphabet:        "",
+		CodeMaxAttempts:     5,
+		CodeLeaseSize:       100,
+		CodeLeaseEncoding:   "base62",
+		AliasAlphabet:       "",
+		AliasMinLength:      3,
+		AliasMaxLength:      32,
+		RestoreGracePeriod:  7 * 24 * 60 * 60,
+		PurgeRetention:      30 * 24 * 60 * 60,
+		PurgeInterval:       60 * 60,
+		DedupScope:          "global",
+		URLSchemes:          []string{"http", "https"},
+		URLMaxLength:        2048,
+		URLDomainsFile:      "",
+		URLDomainsInterval:  30,
+		ClickBatchSize:      100,
+		ClickFlushInterval:  1,
+		ClickRetention:      30 * 24 * 60 * 60,
+		DownsampleInterval:  60 * 60,
+		BotPatternsFile:     "",
+		BotPatternsInterval: 30,
+		GeoIPFile:           "",
+		GeoIPASNFile:        "",
+		GeoIPInterval:       60,
+		TrustedProxies:      nil,
+		TrendingInterval:    5,
+		AdminToken:          "",
+		EventsBuffer:        16,
+		EventsHeartbeat:     15,
+		RedirectType:        307,
+		RedirectMaxAge:      24 * 60 * 60,
 	}
 	LoadEnv(&cfg)
 	ParseFlags(&cfg, true)
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// aliasSuggestions is the number of alternatives offered for an unavailable alias
+const aliasSuggestions = 3
+
+// AliasAvailable reports whether an alias can be used and suggests free alternatives
+func (h *Handler) AliasAvailable(w http.ResponseWriter, r *http.Request) {
+	alias := chi.URLParam(r, "alias")
+	resp := model.AliasAvailabilityResponse{Alias: alias}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if err := h.aliases.Validate(alias); err != nil {
+		resp.Reason = err.Error()
+	} else {
+		available, err := h.aliasAvailable(ctx, alias)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		resp.Available = available
+		if !available {
+			resp.Reason = service.ErrAliasTaken.Error()
+		}
+	}
+
+	if !resp.Available {
+		suggestions, err := h.aliases.Suggest(ctx, alias, aliasSuggestions, h.aliasAvailable)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		resp.Suggestions = suggestions
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// aliasAvailable reports whether no link, deleted, expired and used up
+// ones included, uses alias
+func (h *Handler) aliasAvailable(ctx context.Context, alias string) (bool, error) {
+	_, err := h.store.GetURL(ctx, alias)
+	switch {
+	case errors.Is(err, storage.ErrURLNotFound):
+		return true, nil
+	case err == nil, errors.Is(err, storage.ErrURLDeleted),
+		errors.Is(err, storage.ErrURLExpired), errors.Is(err, storage.ErrURLExhausted):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// saveURL saves u under u.Code when the user chose an alias, otherwise
+// under a generated code, and returns the code
+func (h *Handler) saveURL(ctx context.Context, u storage.URL) (string, error) {
+	if u.Code != "" {
+		err := h.store.SaveURL(ctx, u)
+		if errors.Is(err, storage.ErrCodeAlreadyExists) {
+			return "", fmt.Errorf("%w: %s", service.ErrAliasTaken, u.Code)
+		}
+		return u.Code, err
+	}
+	return service.SaveWithCode(ctx, h.codes, h.cfg.CodeMaxAttempts, func(code string) error {
+		u.Code = code
+		return h.store.SaveURL(ctx, u)
+	})
+}
+
+// saveBatchURL gives generated codes to urls without an alias and saves
+// all of them at once. A taken alias fails the batch with ErrAliasTaken.
+func (h *Handler) saveBatchURL(ctx context.Context, urls []storage.URL) error {
+	var generated []int
+	for i, u := range urls {
+		if u.Code == "" {
+			generated = append(generated, i)
+		}
+	}
+
+	_, err := service.SaveBatchWithCodes(ctx, h.codes, h.cfg.CodeMaxAttempts, len(generated), func(codes []string) error {
+		for j, i := range generated {
+			urls[i].Code = codes[j]
+		}
+		err := h.store.SaveBatchURL(ctx, urls)
+		if !errors.Is(err, storage.ErrCodeAlreadyExists) || len(generated) == len(urls) {
+			return err
+		}
+		// the collision is either a chosen alias, which is final,
+		// or a generated code, which is retried
+		for i, u := range urls {
+			if slices.Contains(generated, i) {
+				continue
+			}
+			available, aliasErr := h.aliasAvailable(ctx, u.Code)
+			if aliasErr != nil {
+				return aliasErr
+			}
+			if !available {
+				return fmt.Errorf("%w: %s", service.ErrAliasTaken, u.Code)
+			}
+		}
+		return err
+	})
+	return err
+}
@@ -2,13 +2,13 @@ package handler
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/auth"
-	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
-	"go.uber.org/zap"
 )
 
 type ctxKey string
@@ -19,10 +19,9 @@ const userKey = ctxKey("user")
 func (h *Handler) GetOrCreateUserMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenExp := time.Hour * time.Duration(h.cfg.TokenExp)
-		user, err := auth.GetOrCreateUser(w, r, h.store, h.cfg.SecretKey, tokenExp)
+		user, err := auth.GetOrCreateUser(w, r, h.store, h.users, h.cfg.SecretKey, tokenExp)
 		if err != nil {
-			logger.Log.Error("error get or create user", zap.Error(err))
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			writeStoreError(w, err)
 			return
 		}
 		ctx := context.WithValue(r.Context(), userKey, user)
@@ -35,3 +34,21 @@ func (h *Handler) GetOrCreateUserMiddleware(next http.Handler) http.Handler {
 func GetUser(ctx context.Context) storage.User {
 	return ctx.Value(userKey).(storage.User)
 }
+
+// AdminMiddleware lets through requests with the configured admin token as
+// a bearer token. Without a configured token the admin API does not exist.
+func (h *Handler) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.cfg.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
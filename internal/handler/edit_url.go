@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/repository"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// UpdateUserURL points one of the user's links at a new original URL
+// and/or changes its redirect status
+func (h *Handler) UpdateUserURL(w http.ResponseWriter, r *http.Request) {
+	var req model.UpdateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	edit := storage.URLEdit{RedirectType: req.RedirectType}
+	if req.URL != "" {
+		canonical, err := h.canonicalURL(req.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		edit.URL, edit.Input = canonical.URL, canonical.Input
+	}
+	h.updateUserURL(w, r, edit, "update")
+}
+
+// RollbackUserURL points one of the user's links back at a previous version
+func (h *Handler) RollbackUserURL(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	code := chi.URLParam(r, "code")
+
+	var req model.RollbackURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	history, err := h.store.URLHistory(ctx, user.ID, code)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if req.Version < 1 || req.Version > len(history) {
+		http.Error(w, "version not found", http.StatusNotFound)
+		return
+	}
+	version := history[req.Version-1]
+	// the old target must still pass the policy
+	target, err := h.canonicalURL(version.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.updateUserURL(w, r, storage.URLEdit{URL: target.URL, Input: cmp.Or(version.Input, target.Input)}, "rollback")
+}
+
+// updateUserURL applies edit to the link in the path with a single storage
+// call, so a refused target leaves the redirect status as it was, records
+// action in the audit log and replies with the link
+func (h *Handler) updateUserURL(w http.ResponseWriter, r *http.Request, edit storage.URLEdit, action string) {
+	user := GetUser(r.Context())
+	code := chi.URLParam(r, "code")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	url, err := h.store.UpdateURL(ctx, user.ID, code, edit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.audit.Publish(repository.AuditEvent{
+		TS:     time.Now().Unix(),
+		Action: action,
+		UserID: user.ID,
+		URL:    url.URL,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(h.userURLResponse(url)); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// UserURLHistory lists every target one of the user's links has had, oldest first
+func (h *Handler) UserURLHistory(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	code := chi.URLParam(r, "code")
+
+	history, err := h.store.URLHistory(r.Context(), user.ID, code)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	responses := make([]model.URLVersionResponse, 0, len(history))
+	for _, v := range history {
+		resp := model.URLVersionResponse{Version: v.Version, OriginalURL: v.DisplayURL()}
+		if !v.ReplacedAt.IsZero() {
+			resp.ReplacedAt = &v.ReplacedAt
+		}
+		responses = append(responses, resp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(responses); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"go.uber.org/zap"
+)
+
+// retryAfterSeconds is suggested to clients when the storage is unavailable
+const retryAfterSeconds = "1"
+
+// storeErrorStatus maps a storage error to the HTTP status of the response
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrURLNotFound), errors.Is(err, storage.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrURLDeleted), errors.Is(err, storage.ErrURLExpired),
+		errors.Is(err, storage.ErrURLExhausted):
+		return http.StatusGone
+	case errors.Is(err, storage.ErrURLAlreadyExists), errors.Is(err, storage.ErrCodeAlreadyExists),
+		errors.Is(err, service.ErrAliasTaken):
+		return http.StatusConflict
+	case storage.IsTransient(err), errors.Is(err, service.ErrCodeSpaceExhausted):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeStoreError replies with the status matching a storage error.
+// Server side failures are logged, expected outcomes are not.
+func writeStoreError(w http.ResponseWriter, err error) {
+	status := storeErrorStatus(err)
+	switch status {
+	case http.StatusServiceUnavailable:
+		logger.Log.Warn("storage unavailable", zap.Error(err))
+		w.Header().Set("Retry-After", retryAfterSeconds)
+	case http.StatusInternalServerError:
+		logger.Log.Error("storage error", zap.Error(err))
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/repository"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const defaultEventsHeartbeat = 15 * time.Second
+
+// UserURLEvents streams the clicks on one of the user's links as
+// server-sent events
+func (h *Handler) UserURLEvents(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	code := chi.URLParam(r, "code")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	url, err := h.store.GetURL(ctx, code)
+	if err == nil && url.UserID != user.ID {
+		err = storage.ErrURLNotFound
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.streamClicks(w, r, user.ID, code)
+}
+
+// UserEvents streams the clicks on all of the user's links as server-sent
+// events
+func (h *Handler) UserEvents(w http.ResponseWriter, r *http.Request) {
+	h.streamClicks(w, r, GetUser(r.Context()).ID, "")
+}
+
+// streamClicks sends "click" events until the client goes away or the
+// server shuts down. Clicks the client was too slow for are reported in a
+// "dropped" event, and comments keep idle connections from timing out.
+func (h *Handler) streamClicks(w http.ResponseWriter, r *http.Request, userID int, code string) {
+	rc := http.NewResponseController(w)
+	// streams outlive any write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Log.Warn("write deadline is not reset", zap.Error(err))
+	}
+
+	sub := h.events.Subscribe(userID, code)
+	defer h.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logger.Log.Error("event stream is not supported", zap.Error(err))
+		return
+	}
+
+	heartbeat := time.Duration(h.cfg.EventsHeartbeat) * time.Second
+	if heartbeat <= 0 {
+		heartbeat = defaultEventsHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				err = writeEvent(w, "dropped", model.DroppedEventsResponse{Dropped: dropped})
+			}
+			if err == nil {
+				err = writeEvent(w, "click", h.clickEventResponse(event))
+			}
+		case <-ticker.C:
+			if dropped := sub.Dropped(); dropped > 0 {
+				err = writeEvent(w, "dropped", model.DroppedEventsResponse{Dropped: dropped})
+			} else {
+				_, err = fmt.Fprint(w, ": keepalive\n\n")
+			}
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			logger.Log.Info("event stream closed", zap.Error(err))
+			return
+		}
+	}
+}
+
+func (h *Handler) clickEventResponse(event repository.AuditEvent) model.ClickEventResponse {
+	return model.ClickEventResponse{
+		ShortURL: h.cfg.ServerAddr + event.Code,
+		TS:       event.Click.At.UTC(),
+		Referrer: event.Click.Referrer,
+		Country:  event.Click.Country,
+		Region:   event.Click.Region,
+		Bot:      event.Click.Bot,
+	}
+}
+
+// writeEvent writes a server-sent event with data encoded as JSON
+func writeEvent(w http.ResponseWriter, name string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+	return err
+}
@@ -4,14 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/repository"
-	"github.com/Quickaxe-Martina/link_shortening_service/internal/service"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
 	"go.uber.org/zap"
 )
@@ -25,6 +26,26 @@ func (h *Handler) GenerateURL(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	expiresAt, err := queryExpiry(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxClicks, err := queryMaxClicks(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	redirectType, err := queryRedirectType(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := h.canonicalURL(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	h.audit.Publish(repository.AuditEvent{
 		TS:     time.Now().Unix(),
@@ -33,22 +54,15 @@ func (h *Handler) GenerateURL(w http.ResponseWriter, r *http.Request) {
 		URL:    string(body),
 	})
 
-	URLCode, err := service.GenerateRandomString(6)
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	logger.Log.Info("URL code", zap.String("URLCode", URLCode))
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	if err := h.store.SaveURL(ctx, storage.URL{Code: URLCode, URL: string(body), UserID: user.ID}); err != nil {
+	URLCode, err := h.saveURL(ctx, storage.URL{URL: target.URL, Input: target.Input, UserID: user.ID, ExpiresAt: expiresAt, MaxClicks: maxClicks, RedirectType: redirectType})
+	if err != nil {
 		if errors.Is(err, storage.ErrURLAlreadyExists) {
 			var url storage.URL
-			url, err = h.store.GetByURL(ctx, string(body))
+			url, err = h.store.GetByURL(ctx, user.ID, target.URL)
 			if err != nil {
-				logger.Log.Error("error get by url", zap.Error(err))
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				writeStoreError(w, err)
 				return
 			}
 
@@ -56,10 +70,10 @@ func (h *Handler) GenerateURL(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(h.cfg.ServerAddr + url.Code))
 			return
 		}
-		logger.Log.Error("", zap.Error(err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeStoreError(w, err)
 		return
 	}
+	logger.Log.Info("URL code", zap.String("URLCode", URLCode))
 
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte(h.cfg.ServerAddr + URLCode))
@@ -82,6 +96,22 @@ func (h *Handler) JSONGenerateURL(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	target, err := h.canonicalURL(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Alias != "" {
+		if err := h.aliases.Validate(req.Alias); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	expiresAt, err := model.Expiry(req.ExpiresAt, req.TTL, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	h.audit.Publish(repository.AuditEvent{
 		TS:     time.Now().Unix(),
@@ -90,24 +120,16 @@ func (h *Handler) JSONGenerateURL(w http.ResponseWriter, r *http.Request) {
 		URL:    req.URL,
 	})
 
-	URLCode, err := service.GenerateRandomString(6)
-	if err != nil {
-		logger.Log.Error("", zap.Error(err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-	logger.Log.Info("URL code", zap.String("URLCode", URLCode))
-
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	if err := h.store.SaveURL(ctx, storage.URL{Code: URLCode, URL: req.URL, UserID: user.ID}); err != nil {
+	URLCode, err := h.saveURL(ctx, storage.URL{Code: req.Alias, URL: target.URL, Input: target.Input, UserID: user.ID, ExpiresAt: expiresAt, MaxClicks: req.MaxClicks, RedirectType: req.RedirectType})
+	if err != nil {
 		if errors.Is(err, storage.ErrURLAlreadyExists) {
 			logger.Log.Info("ErrURLAlreadyExists")
 			var url storage.URL
-			url, err = h.store.GetByURL(ctx, req.URL)
+			url, err = h.store.GetByURL(ctx, user.ID, target.URL)
 			if err != nil {
-				logger.Log.Error("error get by url", zap.Error(err))
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				writeStoreError(w, err)
 				return
 			}
 
@@ -125,10 +147,10 @@ func (h *Handler) JSONGenerateURL(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
-		logger.Log.Error("", zap.Error(err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeStoreError(w, err)
 		return
 	}
+	logger.Log.Info("URL code", zap.String("URLCode", URLCode))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -146,9 +168,8 @@ func (h *Handler) JSONGenerateURL(w http.ResponseWriter, r *http.Request) {
 
 // BatchGenerateURL handles HTTP JSON requests to create a shortened URL.
 func (h *Handler) BatchGenerateURL(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
 	var requests []model.BatchGenerateURLRequest
-	var urls []storage.URL
-	var responses []model.BatchGenerateURLResponse
 
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&requests); err != nil {
@@ -157,28 +178,53 @@ func (h *Handler) BatchGenerateURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	aliases := make(map[string]bool)
 	for _, req := range requests {
 		if err := req.Validate(); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		URLCode, err := service.GenerateRandomString(6)
+		if req.Alias == "" {
+			continue
+		}
+		if err := h.aliases.Validate(req.Alias); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if aliases[req.Alias] {
+			http.Error(w, "alias repeats in batch: "+req.Alias, http.StatusBadRequest)
+			return
+		}
+		aliases[req.Alias] = true
+	}
+
+	now := time.Now()
+	urls := make([]storage.URL, len(requests))
+	for i, req := range requests {
+		expiresAt, err := model.Expiry(req.ExpiresAt, req.TTL, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		target, err := h.canonicalURL(req.URL)
 		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		url := storage.URL{Code: URLCode, URL: req.URL}
-		urls = append(urls, url)
-		resp := model.BatchGenerateURLResponse{CorrelationID: req.CorrelationID, ShortURL: h.cfg.ServerAddr + URLCode}
-		responses = append(responses, resp)
+		urls[i] = storage.URL{Code: req.Alias, URL: target.URL, Input: target.Input, UserID: user.ID, ExpiresAt: expiresAt, MaxClicks: req.MaxClicks, RedirectType: req.RedirectType}
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
-	if err := h.store.SaveBatchURL(ctx, urls); err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if err := h.saveBatchURL(ctx, urls); err != nil {
+		writeStoreError(w, err)
 		return
 	}
 
+	responses := make([]model.BatchGenerateURLResponse, len(requests))
+	for i, req := range requests {
+		responses[i] = model.BatchGenerateURLResponse{CorrelationID: req.CorrelationID, ShortURL: h.cfg.ServerAddr + urls[i].Code}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 
@@ -188,3 +234,60 @@ func (h *Handler) BatchGenerateURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// canonicalURL returns the link for raw with the URL canonicalized and raw
+// kept as the input when it differs. URLs the policy refuses are errors.
+func (h *Handler) canonicalURL(raw string) (storage.URL, error) {
+	canonical, err := model.CanonicalURL(raw, h.cfg.StripTrackingParams)
+	if err != nil {
+		return storage.URL{}, err
+	}
+	if err := h.urls.Validate(canonical); err != nil {
+		return storage.URL{}, err
+	}
+	u := storage.URL{URL: canonical}
+	if canonical != raw {
+		u.Input = raw
+	}
+	return u, nil
+}
+
+// queryExpiry reads the optional expires_at (RFC 3339) and ttl query parameters
+func queryExpiry(r *http.Request, now time.Time) (time.Time, error) {
+	query := r.URL.Query()
+	var expiresAt *time.Time
+	if v := query.Get("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expires_at: %w", err)
+		}
+		expiresAt = &t
+	}
+	return model.Expiry(expiresAt, query.Get("ttl"), now)
+}
+
+// queryMaxClicks reads the optional max_clicks query parameter
+func queryMaxClicks(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("max_clicks")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid max_clicks %q", v)
+	}
+	return n, nil
+}
+
+// queryRedirectType reads the optional redirect_type query parameter
+func queryRedirectType(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("redirect_type")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || model.ValidateRedirectType(n) != nil {
+		return 0, fmt.Errorf("invalid redirect_type %q", v)
+	}
+	return n, nil
+}
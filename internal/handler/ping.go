@@ -4,9 +4,6 @@ import (
 	"context"
 	"net/http"
 	"time"
-
-	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
-	"go.uber.org/zap"
 )
 
 // Ping db
@@ -14,8 +11,8 @@ func (h *Handler) Ping(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 	if err := h.store.Ping(ctx); err != nil {
-		logger.Log.Error("db error", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, err)
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
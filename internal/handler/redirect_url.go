@@ -1,8 +1,9 @@
 package handler
 
 import (
+	"cmp"
 	"context"
-	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -19,24 +20,74 @@ func (h *Handler) RedirectURL(w http.ResponseWriter, r *http.Request) {
 	if len(URLCode) == 0 {
 		logger.Log.Info("URLCode is empty")
 		http.Error(w, "URLCode is empty", http.StatusBadRequest)
+		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	url, err := h.store.GetURL(ctx, URLCode)
+	// bots and prefetches must not spend the clicks of limited links
+	bot := h.bots.IsBot(r)
+	follow := h.store.FollowURL
+	if bot {
+		follow = h.store.GetURL
+	}
+	url, err := follow(ctx, URLCode)
 	if err != nil {
-		if errors.Is(err, storage.ErrURLDeleted) {
-			http.Error(w, "Status Gone", http.StatusGone)
-		} else {
-			http.Error(w, "Bad Request", http.StatusBadRequest)
-		}
+		writeStoreError(w, err)
 		return
 	}
+	click := h.recordClick(r, URLCode, bot)
 	h.audit.Publish(repository.AuditEvent{
-		TS:     time.Now().Unix(),
-		Action: "follow",
-		UserID: 0,
-		URL:    url.URL,
+		TS:      time.Now().Unix(),
+		Action:  "follow",
+		UserID:  0,
+		URL:     url.URL,
+		Code:    URLCode,
+		OwnerID: url.UserID,
+		Click:   &click,
 	})
+	if url.Exhausted() {
+		// this redirect spent the last click
+		h.audit.Publish(repository.AuditEvent{
+			TS:     time.Now().Unix(),
+			Action: "exhausted",
+			UserID: url.UserID,
+			URL:    url.URL,
+		})
+	}
+	if bot && url.MaxClicks > 0 {
+		// a bot given the target could follow a limited link without
+		// spending its clicks
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	status := cmp.Or(url.RedirectType, h.cfg.RedirectType, http.StatusTemporaryRedirect)
+	w.Header().Set("Cache-Control", h.redirectCacheControl(url, status, time.Now()))
 	w.Header().Set("Location", url.URL)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	w.WriteHeader(status)
+}
+
+// defaultRedirectMaxAge applies when the configuration leaves it unset
+const defaultRedirectMaxAge = 24 * time.Hour
+
+// redirectCacheControl lets clients cache permanent redirects for the
+// configured max age, cut short by the expiry of the link. Temporary
+// redirects and links with limited clicks are never cached, so each of
+// them reaches the server and is counted.
+func (h *Handler) redirectCacheControl(url storage.URL, status int, now time.Time) string {
+	permanent := status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+	if !permanent || url.MaxClicks > 0 {
+		return "no-store"
+	}
+	maxAge := time.Duration(h.cfg.RedirectMaxAge) * time.Second
+	if maxAge <= 0 {
+		maxAge = defaultRedirectMaxAge
+	}
+	if !url.ExpiresAt.IsZero() {
+		maxAge = min(maxAge, url.ExpiresAt.Sub(now))
+	}
+	if maxAge < time.Second {
+		return "no-store"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
 }
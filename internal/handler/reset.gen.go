@@ -13,6 +13,10 @@ func (c *compressWriter) Reset() {
 		return
 	}
 
+	c.wroteHeader = false
+
+	c.skipped = false
+
 }
 
 func (c *compressReader) Reset() {
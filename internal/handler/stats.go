@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// maxUserAgentLength keeps a hostile client from filling the clicks store
+const maxUserAgentLength = 512
+
+// recordClick queues the redirect r through the link with code for
+// analytics and returns the click. The visitor address is located before
+// it is anonymized.
+func (h *Handler) recordClick(r *http.Request, code string, bot bool) storage.Click {
+	ip := h.proxies.ClientIP(r)
+	geo := h.geo.Lookup(ip)
+	click := storage.Click{
+		Code:      code,
+		At:        time.Now(),
+		Referrer:  referrerHost(r.Referer()),
+		UserAgent: truncate(r.UserAgent(), maxUserAgentLength),
+		IP:        service.AnonymizeIP(ip.String()),
+		Bot:       bot,
+		Country:   geo.Country,
+		Region:    geo.Region,
+		ASN:       geo.ASN,
+	}
+	if err := h.clicks.Record(click); err != nil {
+		logger.Log.Warn("click is not recorded", zap.String("code", code), zap.Error(err))
+	}
+	if !bot {
+		h.trending.Add(code)
+	}
+	return click
+}
+
+// referrerHost returns the host of the referring page, which is all the
+// breakdowns need; empty for direct visits
+func referrerHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// UserURLStats replies with the click statistics of one of the user's links
+func (h *Handler) UserURLStats(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	code := chi.URLParam(r, "code")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := h.store.URLStats(ctx, user.ID, code)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	resp := model.URLStatsResponse{
+		Total:      stats.Total,
+		Bots:       stats.Bots,
+		Unique:     stats.Unique,
+		UniqueWeek: stats.UniqueWeek,
+		Referrers:  statCountResponses(stats.Referrers),
+		Countries:  statCountResponses(stats.Countries),
+		Regions:    statCountResponses(stats.Regions),
+		ASNs:       statCountResponses(stats.ASNs),
+		Days:       statCountResponses(stats.Days),
+		UniqueDays: statCountResponses(stats.UniqueDays),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+func statCountResponses(counts []storage.StatCount) []model.StatCountResponse {
+	resp := make([]model.StatCountResponse, 0, len(counts))
+	for _, c := range counts {
+		resp = append(resp, model.StatCountResponse(c))
+	}
+	return resp
+}
+
+// maxSeriesPoints bounds the buckets one timeseries request may span
+const maxSeriesPoints = 1000
+
+// seriesIntervals are the bucket lengths of the timeseries intervals, and
+// the number of buckets a series spans by default. Buckets are in UTC, so
+// every day is 24 hours long.
+var seriesIntervals = map[string]struct {
+	length time.Duration
+	points int
+}{
+	storage.IntervalHour: {time.Hour, 24},
+	storage.IntervalDay:  {24 * time.Hour, 30},
+}
+
+// UserClickSeries replies with the clicks of the user's links, or of the one
+// in the code query parameter, per hour or day between from and to. Empty
+// buckets are included, so the points can be charted as they are.
+func (h *Handler) UserClickSeries(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	interval, from, to, err := querySeriesRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	points, err := h.store.ClickSeries(ctx, user.ID, r.URL.Query().Get("code"), interval, from, to)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	resp := model.TimeseriesResponse{
+		Interval: interval,
+		From:     from,
+		To:       to,
+		Points:   fillSeries(points, seriesIntervals[interval].length, from, to),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// querySeriesRange reads the interval ("hour" or "day", by default "day")
+// and the from and to query parameters (RFC 3339 or a date). from is moved
+// back to the start of its bucket; by default the range ends now.
+func querySeriesRange(r *http.Request, now time.Time) (string, time.Time, time.Time, error) {
+	query := r.URL.Query()
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = storage.IntervalDay
+	}
+	bucket, ok := seriesIntervals[interval]
+	if !ok {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("invalid interval %q", interval)
+	}
+
+	to, err := parseSeriesTime(query.Get("to"), now)
+	if err != nil {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("invalid to: %w", err)
+	}
+	from, err := parseSeriesTime(query.Get("from"), to.Add(-time.Duration(bucket.points)*bucket.length))
+	if err != nil {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("invalid from: %w", err)
+	}
+	from = storage.BucketStart(interval, from)
+	if !from.Before(to) {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from) > maxSeriesPoints*bucket.length {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("range spans more than %d %ss", maxSeriesPoints, interval)
+	}
+	return interval, from, to, nil
+}
+
+// parseSeriesTime parses v as a time in UTC, or returns def for an empty v
+func parseSeriesTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def.UTC(), nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t.UTC(), err
+}
+
+// fillSeries lists every bucket from from to to, with zero clicks for the
+// ones missing in points
+func fillSeries(points []storage.SeriesPoint, length time.Duration, from, to time.Time) []model.TimeseriesPointResponse {
+	byStart := make(map[int64]storage.SeriesPoint, len(points))
+	for _, p := range points {
+		byStart[p.At.Unix()] = p
+	}
+	resp := make([]model.TimeseriesPointResponse, 0, to.Sub(from)/length+1)
+	for t := from; t.Before(to); t = t.Add(length) {
+		p := byStart[t.Unix()]
+		resp = append(resp, model.TimeseriesPointResponse{TS: t, Clicks: p.Clicks, Bots: p.Bots})
+	}
+	return resp
+}
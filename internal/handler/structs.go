@@ -4,8 +4,10 @@ Package handler for handlers
 package handler
 
 import (
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/auth"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/repository"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
 )
 
@@ -16,14 +18,34 @@ type Handler struct {
 	store        storage.Storage
 	deleteWorker *repository.DeleteURLsWorkers
 	audit        *repository.AuditPublisher
+	codes        service.CodeGenerator
+	aliases      *service.AliasPolicy
+	urls         *service.URLPolicy
+	clicks       *repository.ClickWorkers
+	bots         *service.BotClassifier
+	geo          *service.GeoResolver
+	proxies      *service.TrustedProxies
+	trending     *repository.TrendingCounter
+	events       *repository.ClickStream
+	users        *auth.KnownUsers
 }
 
 // NewHandler create Handler
-func NewHandler(cfg *config.Config, store storage.Storage, deleteWorker *repository.DeleteURLsWorkers, audit *repository.AuditPublisher) *Handler {
+func NewHandler(cfg *config.Config, store storage.Storage, deleteWorker *repository.DeleteURLsWorkers, audit *repository.AuditPublisher, codes service.CodeGenerator, aliases *service.AliasPolicy, urls *service.URLPolicy, clicks *repository.ClickWorkers, bots *service.BotClassifier, geo *service.GeoResolver, proxies *service.TrustedProxies, trending *repository.TrendingCounter, events *repository.ClickStream) *Handler {
 	return &Handler{
 		cfg:          cfg,
 		store:        store,
 		deleteWorker: deleteWorker,
 		audit:        audit,
+		codes:        codes,
+		aliases:      aliases,
+		urls:         urls,
+		clicks:       clicks,
+		bots:         bots,
+		geo:          geo,
+		proxies:      proxies,
+		trending:     trending,
+		events:       events,
+		users:        auth.NewKnownUsers(0),
 	}
 }
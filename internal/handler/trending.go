@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
+
+	"go.uber.org/zap"
+)
+
+// Defaults and bounds of the leaderboard query parameters
+const (
+	defaultTopWindow = "1h"
+	defaultTopLimit  = 20
+	maxTopLimit      = 100
+)
+
+// topWindows are the windows the leaderboard may be asked for
+var topWindows = map[string]time.Duration{
+	"5m":  5 * time.Minute,
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+}
+
+// UserTopLinks replies with the user's links that had the most redirects
+// within the window query parameter ("5m", "1h" or "24h", by default "1h")
+func (h *Handler) UserTopLinks(w http.ResponseWriter, r *http.Request) {
+	h.topLinks(w, r, GetUser(r.Context()).ID)
+}
+
+// AdminTopLinks replies with the links of every user that had the most
+// redirects within the window, with their owners
+func (h *Handler) AdminTopLinks(w http.ResponseWriter, r *http.Request) {
+	h.topLinks(w, r, 0)
+}
+
+// topLinks replies with the leaderboard of the user's links, or of all
+// links for userID 0
+func (h *Handler) topLinks(w http.ResponseWriter, r *http.Request, userID int) {
+	window, limit, err := queryTopParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	counts, err := h.trending.Top(ctx, userID, topWindows[window], limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	resp := model.TopLinksResponse{
+		Window: window,
+		Links:  make([]model.TopLinkResponse, 0, len(counts)),
+	}
+	for _, c := range counts {
+		link := model.TopLinkResponse{
+			ShortURL:    h.cfg.ServerAddr + c.Code,
+			OriginalURL: c.URL,
+			Clicks:      c.Clicks,
+		}
+		if userID == 0 {
+			link.UserID = c.UserID
+		}
+		resp.Links = append(resp.Links, link)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// queryTopParams reads the window and limit query parameters
+func queryTopParams(r *http.Request) (string, int, error) {
+	query := r.URL.Query()
+	window := query.Get("window")
+	if window == "" {
+		window = defaultTopWindow
+	}
+	if _, ok := topWindows[window]; !ok {
+		return "", 0, fmt.Errorf("invalid window %q", window)
+	}
+
+	limit := defaultTopLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopLimit {
+			return "", 0, fmt.Errorf("limit must be between 1 and %d", maxTopLimit)
+		}
+		limit = n
+	}
+	return window, limit, nil
+}
@@ -3,9 +3,12 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/repository"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
 
 	"go.uber.org/zap"
 )
@@ -17,8 +20,7 @@ func (h *Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 
 	urls, err := h.store.GetURLsByUserID(r.Context(), user.ID)
 	if err != nil {
-		logger.Log.Error("error get urls by user id", zap.Error(err))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeStoreError(w, err)
 		return
 	}
 	if len(urls) == 0 {
@@ -28,10 +30,7 @@ func (h *Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, url := range urls {
-		responses = append(responses, model.UserURLsResponse{
-			ShortURL:    h.cfg.ServerAddr + url.Code,
-			OriginalURL: url.URL,
-		})
+		responses = append(responses, h.userURLResponse(url))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -43,6 +42,21 @@ func (h *Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// userURLResponse describes one of the user's links
+func (h *Handler) userURLResponse(url storage.URL) model.UserURLsResponse {
+	resp := model.UserURLsResponse{
+		ShortURL:     h.cfg.ServerAddr + url.Code,
+		OriginalURL:  url.DisplayURL(),
+		MaxClicks:    url.MaxClicks,
+		Clicks:       url.Clicks,
+		RedirectType: url.RedirectType,
+	}
+	if !url.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &url.ExpiresAt
+	}
+	return resp
+}
+
 // DeleteUserURLs delete users's urls
 func (h *Handler) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 	var codes []string
@@ -56,3 +70,36 @@ func (h *Handler) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 	h.deleteWorker.AddTask(user.ID, codes)
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// RestoreUserURLs undeletes user's urls deleted within the grace period
+// and replies with the codes that were restored
+func (h *Handler) RestoreUserURLs(w http.ResponseWriter, r *http.Request) {
+	var codes []string
+	user := GetUser(r.Context())
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&codes); err != nil {
+		logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	grace := time.Duration(h.cfg.RestoreGracePeriod) * time.Second
+	if grace <= 0 {
+		grace = repository.DefaultRestoreGracePeriod
+	}
+	restored, err := h.store.RestoreUserURLs(r.Context(), user.ID, codes, time.Now().Add(-grace))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if restored == nil {
+		restored = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(restored); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
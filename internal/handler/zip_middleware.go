@@ -11,8 +11,10 @@ import (
 // сжимать передаваемые данные и выставлять правильные HTTP-заголовки
 // generate:reset
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w           http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+	skipped     bool
 }
 
 func newCompressWriter(w http.ResponseWriter) *compressWriter {
@@ -29,19 +31,48 @@ func (c *compressWriter) Header() http.Header {
 
 // Write записывает данные в gzip.Writer, сжимая их перед отправкой
 func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.skipped {
+		return c.w.Write(p)
+	}
 	return c.zw.Write(p)
 }
 
-// WriteHeader отправляет HTTP-статус код
+// WriteHeader отправляет HTTP-статус код.
+// Ответы со статусом 300 и выше и потоки событий отправляются без сжатия.
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
+	c.wroteHeader = true
+	if statusCode < 300 && c.w.Header().Get("Content-Type") != "text/event-stream" {
 		c.w.Header().Set("Content-Encoding", "gzip")
+	} else {
+		c.skipped = true
 	}
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush отправляет клиенту уже записанные данные
+func (c *compressWriter) Flush() {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.skipped {
+		c.zw.Flush()
+	}
+	http.NewResponseController(c.w).Flush()
+}
+
+// Unwrap возвращает исходный http.ResponseWriter
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
 // Close закрывает gzip.Writer и досылает все данные из буфера.
 func (c *compressWriter) Close() error {
+	if c.skipped || !c.wroteHeader {
+		return nil
+	}
 	return c.zw.Close()
 }
 
@@ -39,6 +39,12 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Unwrap возвращает оригинальный http.ResponseWriter, чтобы http.ResponseController
+// мог сбрасывать буфер потоковых ответов
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // Log будет доступен всему коду как синглтон.
 // Никакой код навыка, кроме функции Initialize, не должен модифицировать эту переменную.
 // По умолчанию установлен no-op-логер, который не выводит никаких сообщений.
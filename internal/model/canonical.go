@@ -0,0 +1,107 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts are dropped from canonical URLs
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+// trackingParams are query parameters that only identify the campaign
+// or click a visitor came from
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+	"_ga":     true,
+	"_gl":     true,
+}
+
+// isTrackingParam reports utm_* and the well-known click IDs
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "utm_") || trackingParams[key]
+}
+
+// CanonicalURL returns the form of raw two links are compared by: scheme
+// and host in lower case, IDN hosts in punycode, no default port, "/" for
+// an empty path, and query parameters sorted by name, with tracking
+// parameters dropped when stripTracking is set. raw must pass validateURL.
+func CanonicalURL(raw string, stripTracking bool) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) == nil {
+		if host, err = idna.Punycode.ToASCII(host); err != nil {
+			return "", fmt.Errorf("invalid host: %w", err)
+		}
+	}
+	switch port := u.Port(); {
+	case port != "" && port != defaultPorts[u.Scheme]:
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if u.Path == "" && u.Opaque == "" {
+		u.Path = "/"
+	}
+	u.RawQuery = canonicalQuery(u.RawQuery, stripTracking)
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// canonicalQuery sorts parameters by name, keeping their encoding and the
+// order of repeated ones, and drops empty and, optionally, tracking ones
+func canonicalQuery(query string, stripTracking bool) string {
+	if query == "" {
+		return ""
+	}
+	type param struct{ key, raw string }
+	var params []param
+	for _, raw := range strings.Split(query, "&") {
+		if raw == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(raw, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if stripTracking && isTrackingParam(key) {
+			continue
+		}
+		params = append(params, param{key, raw})
+	}
+	slices.SortStableFunc(params, func(a, b param) int {
+		return strings.Compare(a.key, b.key)
+	})
+
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
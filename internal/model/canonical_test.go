@@ -0,0 +1,36 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		stripTracking bool
+		want          string
+	}{
+		{"регистр схемы и хоста", "HTTPS://Example.COM/Path", false, "https://example.com/Path"},
+		{"пустой путь", "https://example.com", false, "https://example.com/"},
+		{"пустой запрос", "https://example.com/?", false, "https://example.com/"},
+		{"порт по умолчанию", "http://example.com:80/a", false, "http://example.com/a"},
+		{"нестандартный порт", "https://example.com:8443/a", false, "https://example.com:8443/a"},
+		{"IDN", "https://Пример.рф/", false, "https://xn--e1afmkfd.xn--p1ai/"},
+		{"IPv6", "http://[::1]:80/", false, "http://[::1]/"},
+		{"сортировка параметров", "https://example.com/?b=2&a=1&b=1", false, "https://example.com/?a=1&b=2&b=1"},
+		{"метки остаются", "https://example.com/?utm_source=x&q=go", false, "https://example.com/?q=go&utm_source=x"},
+		{"метки удаляются", "https://example.com/?utm_source=x&q=go&fbclid=y", true, "https://example.com/?q=go"},
+		{"фрагмент", "https://example.com/#Top", false, "https://example.com/#Top"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CanonicalURL(tt.raw, tt.stripTracking)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Expiry resolves an absolute expiry or a TTL into the moment a link
+// expires; the zero time means the link never expires. ttl is either a
+// duration such as "90m" or a number of seconds.
+func Expiry(expiresAt *time.Time, ttl string, now time.Time) (time.Time, error) {
+	switch {
+	case expiresAt != nil && ttl != "":
+		return time.Time{}, errors.New("use either expires_at or ttl, not both")
+	case expiresAt != nil:
+		if !expiresAt.After(now) {
+			return time.Time{}, errors.New("expires_at must be in the future")
+		}
+		return *expiresAt, nil
+	case ttl != "":
+		d, err := parseTTL(ttl)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
+func parseTTL(ttl string) (time.Duration, error) {
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(ttl)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid ttl: %w", err)
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return d, nil
+}
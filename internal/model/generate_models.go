@@ -4,23 +4,34 @@ Package model for models
 package model
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"time"
 )
 
-// JSONGenerateURLRequest model for request
-// generate:reset
-type JSONGenerateURLRequest struct {
-	URL string `json:"url"`
+var errMaxClicks = errors.New("max_clicks must not be negative")
+
+var errRedirectType = errors.New("redirect_type must be 301, 302, 307 or 308")
+
+// ValidateRedirectType accepts the statuses a link may redirect with, and 0
+// for the server default
+func ValidateRedirectType(status int) error {
+	switch status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return errRedirectType
 }
 
-// Validate validation method
-func (r *JSONGenerateURLRequest) Validate() error {
-	if r.URL == "" {
+// validateURL accepts absolute URLs with a scheme and a host
+func validateURL(raw string) error {
+	if raw == "" {
 		return fmt.Errorf("url is required")
 	}
 
-	u, err := url.ParseRequestURI(r.URL)
+	u, err := url.ParseRequestURI(raw)
 	if err != nil {
 		return fmt.Errorf("invalid url: %w", err)
 	}
@@ -28,10 +39,33 @@ func (r *JSONGenerateURLRequest) Validate() error {
 	if u.Scheme == "" || u.Host == "" {
 		return fmt.Errorf("url must include scheme and host (e.g. https://example.com)")
 	}
-
 	return nil
 }
 
+// JSONGenerateURLRequest model for request
+// generate:reset
+type JSONGenerateURLRequest struct {
+	URL          string     `json:"url"`
+	Alias        string     `json:"alias,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	TTL          string     `json:"ttl,omitempty"`
+	MaxClicks    int        `json:"max_clicks,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+}
+
+// Validate validation method. The expiry is checked by Expiry, which the
+// handler calls anyway to compute it.
+func (r *JSONGenerateURLRequest) Validate() error {
+	if err := validateURL(r.URL); err != nil {
+		return err
+	}
+
+	if r.MaxClicks < 0 {
+		return errMaxClicks
+	}
+	return ValidateRedirectType(r.RedirectType)
+}
+
 // JSONGenerateURLResponse model for response
 // generate:reset
 type JSONGenerateURLResponse struct {
@@ -41,26 +75,26 @@ type JSONGenerateURLResponse struct {
 // BatchGenerateURLRequest model for request
 // generate:reset
 type BatchGenerateURLRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	URL           string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	URL           string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTL           string     `json:"ttl,omitempty"`
+	MaxClicks     int        `json:"max_clicks,omitempty"`
+	RedirectType  int        `json:"redirect_type,omitempty"`
 }
 
-// Validate validation method
+// Validate validation method. The expiry is checked by Expiry, which the
+// handler calls anyway to compute it.
 func (r *BatchGenerateURLRequest) Validate() error {
-	if r.URL == "" {
-		return fmt.Errorf("url is required")
+	if err := validateURL(r.URL); err != nil {
+		return err
 	}
 
-	u, err := url.ParseRequestURI(r.URL)
-	if err != nil {
-		return fmt.Errorf("invalid url: %w", err)
+	if r.MaxClicks < 0 {
+		return errMaxClicks
 	}
-
-	if u.Scheme == "" || u.Host == "" {
-		return fmt.Errorf("url must include scheme and host (e.g. https://example.com)")
-	}
-
-	return nil
+	return ValidateRedirectType(r.RedirectType)
 }
 
 // BatchGenerateURLResponse model for response
@@ -70,9 +104,133 @@ type BatchGenerateURLResponse struct {
 	ShortURL      string `json:"short_url"`
 }
 
+// AliasAvailabilityResponse model for response
+// generate:reset
+type AliasAvailabilityResponse struct {
+	Alias       string   `json:"alias"`
+	Available   bool     `json:"available"`
+	Reason      string   `json:"reason,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
 // UserURLsResponse model for response
 // generate:reset
 type UserURLsResponse struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxClicks   int        `json:"max_clicks,omitempty"`
+	Clicks      int        `json:"clicks,omitempty"`
+	// RedirectType is left out for links that use the server default
+	RedirectType int `json:"redirect_type,omitempty"`
+}
+
+// UpdateURLRequest model for request
+// generate:reset
+type UpdateURLRequest struct {
+	URL string `json:"url,omitempty"`
+	// RedirectType, when set, changes the redirect status; 0 returns the
+	// link to the server default
+	RedirectType *int `json:"redirect_type,omitempty"`
+}
+
+// Validate validation method. The URL may be left out when only the
+// redirect status changes.
+func (r *UpdateURLRequest) Validate() error {
+	if r.RedirectType != nil {
+		if err := ValidateRedirectType(*r.RedirectType); err != nil {
+			return err
+		}
+		if r.URL == "" {
+			return nil
+		}
+	}
+	return validateURL(r.URL)
+}
+
+// RollbackURLRequest model for request
+// generate:reset
+type RollbackURLRequest struct {
+	Version int `json:"version"`
+}
+
+// URLVersionResponse model for response
+// generate:reset
+type URLVersionResponse struct {
+	Version     int        `json:"version"`
+	OriginalURL string     `json:"original_url"`
+	ReplacedAt  *time.Time `json:"replaced_at,omitempty"`
+}
+
+// URLStatsResponse model for response
+// generate:reset
+type URLStatsResponse struct {
+	Total      int                 `json:"total"`
+	Bots       int                 `json:"bots"`
+	Unique     int                 `json:"unique"`
+	UniqueWeek int                 `json:"unique_week"`
+	Referrers  []StatCountResponse `json:"referrers"`
+	Countries  []StatCountResponse `json:"countries"`
+	Regions    []StatCountResponse `json:"regions"`
+	ASNs       []StatCountResponse `json:"asns"`
+	Days       []StatCountResponse `json:"days"`
+	UniqueDays []StatCountResponse `json:"unique_days"`
+}
+
+// StatCountResponse model for response
+// generate:reset
+type StatCountResponse struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// TimeseriesResponse model for response
+// generate:reset
+type TimeseriesResponse struct {
+	Interval string                    `json:"interval"`
+	From     time.Time                 `json:"from"`
+	To       time.Time                 `json:"to"`
+	Points   []TimeseriesPointResponse `json:"points"`
+}
+
+// TimeseriesPointResponse model for response
+// generate:reset
+type TimeseriesPointResponse struct {
+	TS     time.Time `json:"ts"`
+	Clicks int       `json:"clicks"`
+	Bots   int       `json:"bots"`
+}
+
+// TopLinksResponse model for response
+// generate:reset
+type TopLinksResponse struct {
+	Window string            `json:"window"`
+	Links  []TopLinkResponse `json:"links"`
+}
+
+// TopLinkResponse model for response
+// generate:reset
+type TopLinkResponse struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	// UserID is the owner of the link, shown to admins only
+	UserID int `json:"user_id,omitempty"`
+	Clicks int `json:"clicks"`
+}
+
+// ClickEventResponse model for a streamed click
+// generate:reset
+type ClickEventResponse struct {
+	ShortURL string    `json:"short_url"`
+	TS       time.Time `json:"ts"`
+	Referrer string    `json:"referrer,omitempty"`
+	Country  string    `json:"country,omitempty"`
+	Region   string    `json:"region,omitempty"`
+	Bot      bool      `json:"bot"`
+}
+
+// DroppedEventsResponse model for the clicks a slow stream missed
+// generate:reset
+type DroppedEventsResponse struct {
+	Dropped int `json:"dropped"`
 }
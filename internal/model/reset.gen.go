@@ -8,6 +8,14 @@ func (j *JSONGenerateURLRequest) Reset() {
 
 	j.URL = ""
 
+	j.Alias = ""
+
+	j.TTL = ""
+
+	j.MaxClicks = 0
+
+	j.RedirectType = 0
+
 }
 
 func (j *JSONGenerateURLResponse) Reset() {
@@ -28,6 +36,14 @@ func (b *BatchGenerateURLRequest) Reset() {
 
 	b.URL = ""
 
+	b.Alias = ""
+
+	b.TTL = ""
+
+	b.MaxClicks = 0
+
+	b.RedirectType = 0
+
 }
 
 func (b *BatchGenerateURLResponse) Reset() {
@@ -41,6 +57,21 @@ func (b *BatchGenerateURLResponse) Reset() {
 
 }
 
+func (a *AliasAvailabilityResponse) Reset() {
+	if a == nil {
+		return
+	}
+
+	a.Alias = ""
+
+	a.Available = false
+
+	a.Reason = ""
+
+	a.Suggestions = a.Suggestions[:0]
+
+}
+
 func (u *UserURLsResponse) Reset() {
 	if u == nil {
 		return
@@ -50,4 +81,151 @@ func (u *UserURLsResponse) Reset() {
 
 	u.OriginalURL = ""
 
+	u.MaxClicks = 0
+
+	u.Clicks = 0
+
+	u.RedirectType = 0
+
+}
+
+func (u *UpdateURLRequest) Reset() {
+	if u == nil {
+		return
+	}
+
+	u.URL = ""
+
+}
+
+func (r *RollbackURLRequest) Reset() {
+	if r == nil {
+		return
+	}
+
+	r.Version = 0
+
+}
+
+func (u *URLVersionResponse) Reset() {
+	if u == nil {
+		return
+	}
+
+	u.Version = 0
+
+	u.OriginalURL = ""
+
+}
+
+func (u *URLStatsResponse) Reset() {
+	if u == nil {
+		return
+	}
+
+	u.Total = 0
+
+	u.Bots = 0
+
+	u.Unique = 0
+
+	u.UniqueWeek = 0
+
+	u.Referrers = u.Referrers[:0]
+
+	u.Countries = u.Countries[:0]
+
+	u.Regions = u.Regions[:0]
+
+	u.ASNs = u.ASNs[:0]
+
+	u.Days = u.Days[:0]
+
+	u.UniqueDays = u.UniqueDays[:0]
+
+}
+
+func (s *StatCountResponse) Reset() {
+	if s == nil {
+		return
+	}
+
+	s.Key = ""
+
+	s.Count = 0
+
+}
+
+func (t *TimeseriesResponse) Reset() {
+	if t == nil {
+		return
+	}
+
+	t.Interval = ""
+
+	t.Points = t.Points[:0]
+
+}
+
+func (t *TimeseriesPointResponse) Reset() {
+	if t == nil {
+		return
+	}
+
+	t.Clicks = 0
+
+	t.Bots = 0
+
+}
+
+func (t *TopLinksResponse) Reset() {
+	if t == nil {
+		return
+	}
+
+	t.Window = ""
+
+	t.Links = t.Links[:0]
+
+}
+
+func (t *TopLinkResponse) Reset() {
+	if t == nil {
+		return
+	}
+
+	t.ShortURL = ""
+
+	t.OriginalURL = ""
+
+	t.UserID = 0
+
+	t.Clicks = 0
+
+}
+
+func (c *ClickEventResponse) Reset() {
+	if c == nil {
+		return
+	}
+
+	c.ShortURL = ""
+
+	c.Referrer = ""
+
+	c.Country = ""
+
+	c.Region = ""
+
+	c.Bot = false
+
+}
+
+func (d *DroppedEventsResponse) Reset() {
+	if d == nil {
+		return
+	}
+
+	d.Dropped = 0
+
 }
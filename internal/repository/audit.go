@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
 )
 
 // AuditEvent represents a user action event for auditing purposes.
@@ -15,6 +16,11 @@ type AuditEvent struct {
 	Action string `json:"action"`
 	UserID int    `json:"-"`
 	URL    string `json:"url"`
+	// Code, OwnerID and Click describe a redirect to ClickStream and are
+	// left out of the audit log
+	Code    string         `json:"-"`
+	OwnerID int            `json:"-"`
+	Click   *storage.Click `json:"-"`
 }
 
 // MarshalJSON customizes JSON serialization for AuditEvent.
@@ -43,8 +49,10 @@ type observerWorker struct {
 // generate:reset
 type AuditPublisher struct {
 	observers []observerWorker
-	ch        chan AuditEvent
-	wg        sync.WaitGroup
+	// lossless observers are fed by Publish itself, past the shared queue
+	lossless []observerWorker
+	ch       chan AuditEvent
+	wg       sync.WaitGroup
 }
 
 // NewAuditPublisher creates a new AuditPublisher with a buffered channel.
@@ -61,13 +69,23 @@ func NewAuditPublisher(buffer int) *AuditPublisher {
 
 // Register adds a new observer to receive audit events.
 func (p *AuditPublisher) Register(obs AuditObserver) {
+	p.observers = append(p.observers, p.start(obs, 10))
+}
+
+// RegisterLossless adds an observer that receives every event, even when
+// the shared queue is full. Publish hands events to it on a channel of its
+// own and waits while that channel is full, so its Notify must return
+// quickly.
+func (p *AuditPublisher) RegisterLossless(obs AuditObserver, buffer int) {
+	p.lossless = append(p.lossless, p.start(obs, buffer))
+}
+
+func (p *AuditPublisher) start(obs AuditObserver, buffer int) observerWorker {
 	w := observerWorker{
 		obs: obs,
-		ch:  make(chan AuditEvent, 10),
+		ch:  make(chan AuditEvent, buffer),
 	}
 
-	p.observers = append(p.observers, w)
-
 	p.wg.Add(1)
 	go func(w observerWorker) {
 		defer p.wg.Done()
@@ -75,10 +93,15 @@ func (p *AuditPublisher) Register(obs AuditObserver) {
 			w.obs.Notify(ev)
 		}
 	}(w)
+	return w
 }
 
 // Publish sends an audit event to all registered observers asynchronously.
 func (p *AuditPublisher) Publish(event AuditEvent) {
+	for _, o := range p.lossless {
+		o.ch <- event
+	}
+
 	select {
 	case p.ch <- event:
 		logger.Log.Info("event pushed to channel")
@@ -92,6 +115,9 @@ func (p *AuditPublisher) Publish(event AuditEvent) {
 // Stop signals the worker to stop and waits for all events to be processed.
 func (p *AuditPublisher) Stop() {
 	close(p.ch)
+	for _, o := range p.lossless {
+		close(o.ch)
+	}
 	p.wg.Wait()
 	logger.Log.Info("All workers stopped")
 }
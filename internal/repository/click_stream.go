@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"sync"
+)
+
+const defaultClickStreamBuffer = 16
+
+// ClickSubscription receives the clicks on the links of one user, or on one
+// of them, as they happen
+type ClickSubscription struct {
+	userID int
+	code   string
+	ch     chan AuditEvent
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// Events returns the channel the clicks arrive on. It is closed when the
+// stream closes.
+func (s *ClickSubscription) Events() <-chan AuditEvent {
+	return s.ch
+}
+
+// Dropped returns how many clicks were dropped since the last call because
+// the subscriber fell behind
+func (s *ClickSubscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+func (s *ClickSubscription) matches(event AuditEvent) bool {
+	return event.OwnerID == s.userID && (s.code == "" || event.Code == s.code)
+}
+
+// ClickStream is an AuditObserver that fans redirects out to subscribed
+// connections. It is registered with AuditPublisher.RegisterLossless, so a
+// full audit queue never drops clicks no subscriber learns of. Every
+// subscriber has a bounded buffer, and clicks that do not fit are dropped
+// and counted rather than holding up the audit.
+type ClickStream struct {
+	buffer int
+
+	mu     sync.Mutex
+	subs   map[*ClickSubscription]struct{}
+	closed bool
+}
+
+// NewClickStream creates a ClickStream that buffers up to buffer clicks per
+// subscriber
+func NewClickStream(buffer int) *ClickStream {
+	if buffer <= 0 {
+		buffer = defaultClickStreamBuffer
+	}
+	return &ClickStream{
+		buffer: buffer,
+		subs:   make(map[*ClickSubscription]struct{}),
+	}
+}
+
+// Subscribe starts delivering the clicks on the links of the user, or only
+// on the link with code when it is not empty. The subscription must be
+// ended with Unsubscribe.
+func (cs *ClickStream) Subscribe(userID int, code string) *ClickSubscription {
+	sub := &ClickSubscription{
+		userID: userID,
+		code:   code,
+		ch:     make(chan AuditEvent, cs.buffer),
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		close(sub.ch)
+		return sub
+	}
+	cs.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops delivering clicks to sub and closes its channel
+func (cs *ClickStream) Unsubscribe(sub *ClickSubscription) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, ok := cs.subs[sub]; ok {
+		delete(cs.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Notify delivers a redirect to the subscribers of its link
+func (cs *ClickStream) Notify(event AuditEvent) {
+	if event.Action != "follow" || event.Click == nil {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for sub := range cs.subs {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.mu.Lock()
+			sub.dropped++
+			sub.mu.Unlock()
+		}
+	}
+}
+
+// Close ends every subscription, so the connections streaming them finish
+// before the server shuts down
+func (cs *ClickStream) Close() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.closed = true
+	for sub := range cs.subs {
+		close(sub.ch)
+	}
+	clear(cs.subs)
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ErrClickQueueFull the storage does not keep up with redirects
+var ErrClickQueueFull = errors.New("click queue is full")
+
+// Defaults for non-positive ClickWorkers settings
+const (
+	defaultClickFlushDelay = time.Second
+	defaultClickBatchSize  = 100
+	clickQueueSize         = 1000
+)
+
+// ClickWorkers collect clicks into batches and save them in the background,
+// so redirects never wait for the storage
+type ClickWorkers struct {
+	store      storage.Storage
+	inputCh    chan storage.Click
+	workerCh   chan []storage.Click
+	doneCh     chan struct{}
+	flushDelay time.Duration
+	batchSize  int
+	wg         sync.WaitGroup
+
+	// mu orders Record sends before Stop, so the aggregator drains every
+	// queued click; stopped is set under it
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// NewClickWorkers create ClickWorkers
+func NewClickWorkers(store storage.Storage, numWorkers int, flushDelay time.Duration, batchSize int) *ClickWorkers {
+	if flushDelay <= 0 {
+		flushDelay = defaultClickFlushDelay
+	}
+	if batchSize <= 0 {
+		batchSize = defaultClickBatchSize
+	}
+	numWorkers = max(numWorkers, 1)
+	cw := &ClickWorkers{
+		store:      store,
+		inputCh:    make(chan storage.Click, clickQueueSize),
+		workerCh:   make(chan []storage.Click, numWorkers),
+		doneCh:     make(chan struct{}),
+		flushDelay: flushDelay,
+		batchSize:  batchSize,
+	}
+
+	cw.wg.Add(1)
+	go cw.aggregator()
+	for i := 0; i < numWorkers; i++ {
+		cw.wg.Add(1)
+		go cw.worker(i)
+	}
+
+	return cw
+}
+
+func (cw *ClickWorkers) aggregator() {
+	logger.Log.Info("click aggregator started")
+	defer cw.wg.Done()
+	ticker := time.NewTicker(cw.flushDelay)
+	defer ticker.Stop()
+
+	batch := make([]storage.Click, 0, cw.batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		cw.workerCh <- batch
+		batch = make([]storage.Click, 0, cw.batchSize)
+	}
+
+	for {
+		select {
+		case <-cw.doneCh:
+			// save what redirects queued before the stop
+		drain:
+			for {
+				select {
+				case click := <-cw.inputCh:
+					batch = append(batch, click)
+					if len(batch) >= cw.batchSize {
+						flush()
+					}
+				default:
+					break drain
+				}
+			}
+			flush()
+			close(cw.workerCh)
+			logger.Log.Info("click aggregator stopped")
+			return
+		case <-ticker.C:
+			flush()
+		case click := <-cw.inputCh:
+			batch = append(batch, click)
+			if len(batch) >= cw.batchSize {
+				flush()
+			}
+		}
+	}
+}
+
+// worker saves batches until the aggregator closes workerCh
+func (cw *ClickWorkers) worker(id int) {
+	defer cw.wg.Done()
+	logger.Log.Info(fmt.Sprintf("click worker-%d started", id))
+	for batch := range cw.workerCh {
+		cw.saveClicks(batch)
+	}
+	logger.Log.Info(fmt.Sprintf("click worker-%d stopped", id))
+}
+
+func (cw *ClickWorkers) saveClicks(batch []storage.Click) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := cw.store.SaveClicks(ctx, batch); err != nil {
+		logger.Log.Error("save clicks error", zap.Int("clicks", len(batch)), zap.Error(err))
+	}
+}
+
+// Record queues click for saving. It never blocks: when the queue is full
+// the click is dropped with ErrClickQueueFull.
+func (cw *ClickWorkers) Record(click storage.Click) error {
+	cw.mu.RLock()
+	defer cw.mu.RUnlock()
+	if cw.stopped {
+		return ErrWorkerStopped
+	}
+
+	select {
+	case cw.inputCh <- click:
+		return nil
+	default:
+		return ErrClickQueueFull
+	}
+}
+
+// Stop saves queued clicks and ends workers work
+func (cw *ClickWorkers) Stop() {
+	cw.mu.Lock()
+	if cw.stopped {
+		cw.mu.Unlock()
+		return
+	}
+	cw.stopped = true
+	close(cw.doneCh)
+	cw.mu.Unlock()
+
+	cw.wg.Wait()
+	logger.Log.Info("All click workers stopped")
+}
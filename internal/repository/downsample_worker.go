@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Defaults for non-positive DownsampleWorker settings
+const (
+	defaultDownsampleInterval = time.Hour
+	defaultClickRetention     = 30 * 24 * time.Hour
+)
+
+// DownsampleWorker periodically drops raw clicks and hourly rollups older
+// than the retention; daily rollups keep counting them
+type DownsampleWorker struct {
+	store     storage.Storage
+	interval  time.Duration
+	retention time.Duration
+	doneCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewDownsampleWorker starts a DownsampleWorker that runs every interval
+func NewDownsampleWorker(store storage.Storage, interval time.Duration, retention time.Duration) *DownsampleWorker {
+	if interval <= 0 {
+		interval = defaultDownsampleInterval
+	}
+	if retention <= 0 {
+		retention = defaultClickRetention
+	}
+	dw := &DownsampleWorker{
+		store:     store,
+		interval:  interval,
+		retention: retention,
+		doneCh:    make(chan struct{}),
+	}
+
+	dw.wg.Add(1)
+	go dw.run()
+
+	return dw
+}
+
+func (dw *DownsampleWorker) run() {
+	defer dw.wg.Done()
+	logger.Log.Info("downsample worker started")
+	ticker := time.NewTicker(dw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dw.doneCh:
+			logger.Log.Info("downsample worker stopped")
+			return
+		case <-ticker.C:
+			dw.downsample()
+		}
+	}
+}
+
+func (dw *DownsampleWorker) downsample() {
+	ctx, cancel := context.WithTimeout(context.Background(), dw.interval)
+	defer cancel()
+
+	n, err := dw.store.DownsampleClicks(ctx, time.Now().Add(-dw.retention))
+	if err != nil {
+		logger.Log.Error("downsample clicks error", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		logger.Log.Info("raw clicks downsampled", zap.Int("count", n))
+	}
+}
+
+// Stop ends the worker and waits for a running downsample to finish
+func (dw *DownsampleWorker) Stop() {
+	close(dw.doneCh)
+	dw.wg.Wait()
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Defaults for non-positive PurgeWorker settings
+const (
+	defaultPurgeInterval  = time.Hour
+	defaultPurgeRetention = 30 * 24 * time.Hour
+)
+
+// DefaultRestoreGracePeriod applies when the configuration leaves the
+// period in which deleted links can be restored unset
+const DefaultRestoreGracePeriod = 7 * 24 * time.Hour
+
+// PurgeWorker periodically removes links that were deleted longer than
+// the retention ago, freeing their codes and URLs
+type PurgeWorker struct {
+	store     storage.Storage
+	interval  time.Duration
+	retention time.Duration
+	doneCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewPurgeWorker starts a PurgeWorker that runs every interval. A retention
+// shorter than the restore grace period is raised to it, so links are never
+// purged while they can still be restored.
+func NewPurgeWorker(store storage.Storage, interval time.Duration, retention time.Duration, grace time.Duration) *PurgeWorker {
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+	if retention <= 0 {
+		retention = defaultPurgeRetention
+	}
+	if grace <= 0 {
+		grace = DefaultRestoreGracePeriod
+	}
+	if retention < grace {
+		logger.Log.Warn("purge retention is raised to the restore grace period",
+			zap.Duration("retention", retention), zap.Duration("grace", grace))
+		retention = grace
+	}
+	pw := &PurgeWorker{
+		store:     store,
+		interval:  interval,
+		retention: retention,
+		doneCh:    make(chan struct{}),
+	}
+
+	pw.wg.Add(1)
+	go pw.run()
+
+	return pw
+}
+
+func (pw *PurgeWorker) run() {
+	defer pw.wg.Done()
+	logger.Log.Info("purge worker started")
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pw.doneCh:
+			logger.Log.Info("purge worker stopped")
+			return
+		case <-ticker.C:
+			pw.purge()
+		}
+	}
+}
+
+func (pw *PurgeWorker) purge() {
+	ctx, cancel := context.WithTimeout(context.Background(), pw.interval)
+	defer cancel()
+
+	n, err := pw.store.PurgeDeletedURLs(ctx, time.Now().Add(-pw.retention))
+	if err != nil {
+		logger.Log.Error("purge urls error", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		logger.Log.Info("deleted urls purged", zap.Int("count", n))
+	}
+}
+
+// Stop ends the worker and waits for a running purge to finish
+func (pw *PurgeWorker) Stop() {
+	close(pw.doneCh)
+	pw.wg.Wait()
+}
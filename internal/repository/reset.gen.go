@@ -43,6 +43,10 @@ func (a *AuditEvent) Reset() {
 
 	a.URL = ""
 
+	a.Code = ""
+
+	a.OwnerID = 0
+
 }
 
 func (a *AuditPublisher) Reset() {
@@ -52,6 +56,8 @@ func (a *AuditPublisher) Reset() {
 
 	a.observers = a.observers[:0]
 
+	a.lossless = a.lossless[:0]
+
 }
 
 func (f *FileAuditObserver) Reset() {
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"go.uber.org/zap"
+)
+
+// TrendingHorizon is the longest window the leaderboard covers; older
+// counts are dropped
+const TrendingHorizon = 24 * time.Hour
+
+const defaultTrendingFlushDelay = 5 * time.Second
+
+// trendingTopAttempts bounds how often Top reads the storage again because
+// a flush moved counts while it read
+const trendingTopAttempts = 3
+
+// trendingKey identifies the count of one link in one minute
+type trendingKey struct {
+	code string
+	// minute is the Unix time the minute starts at
+	minute int64
+}
+
+// TrendingCounter counts redirects per link and minute in memory and adds
+// the counts to the storage in the background, so redirects never wait
+// for it. The storage sums the counts of every instance, and windows slide
+// over its per-minute counters.
+type TrendingCounter struct {
+	store      storage.Storage
+	flushDelay time.Duration
+	doneCh     chan struct{}
+	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[trendingKey]int
+	// flushes counts the flushes that took pending counts, and saving is
+	// set until the last of them has stored them; Top reads both to tell
+	// whether counts moved to the storage while it read
+	flushes int
+	saving  bool
+	// flushMu keeps flushes in order, so the counts of a failed flush are
+	// put back before the next one takes them
+	flushMu sync.Mutex
+}
+
+// NewTrendingCounter starts a TrendingCounter that flushes every flushDelay
+func NewTrendingCounter(store storage.Storage, flushDelay time.Duration) *TrendingCounter {
+	if flushDelay <= 0 {
+		flushDelay = defaultTrendingFlushDelay
+	}
+	tc := &TrendingCounter{
+		store:      store,
+		flushDelay: flushDelay,
+		doneCh:     make(chan struct{}),
+		pending:    make(map[trendingKey]int),
+	}
+
+	tc.wg.Add(1)
+	go tc.run()
+
+	return tc
+}
+
+func (tc *TrendingCounter) run() {
+	defer tc.wg.Done()
+	logger.Log.Info("trending counter started")
+	ticker := time.NewTicker(tc.flushDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tc.doneCh:
+			logger.Log.Info("trending counter stopped")
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), tc.flushDelay)
+			if err := tc.flush(ctx); err != nil {
+				logger.Log.Error("save trending counts error", zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
+
+// Add counts a redirect through the link with code now
+func (tc *TrendingCounter) Add(code string) {
+	minute := time.Now().Truncate(time.Minute).Unix()
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.pending[trendingKey{code, minute}]++
+}
+
+// flush adds the pending counts to the storage. Counts that fail to save
+// are kept for the next flush unless they have expired by then.
+func (tc *TrendingCounter) flush(ctx context.Context) error {
+	tc.flushMu.Lock()
+	defer tc.flushMu.Unlock()
+
+	tc.mu.Lock()
+	pending := tc.pending
+	if len(pending) == 0 {
+		tc.mu.Unlock()
+		return nil
+	}
+	tc.pending = make(map[trendingKey]int)
+	tc.flushes++
+	tc.saving = true
+	tc.mu.Unlock()
+
+	expired := time.Now().Add(-TrendingHorizon).Truncate(time.Minute)
+	counts := make([]storage.TrendingCount, 0, len(pending))
+	for k, n := range pending {
+		counts = append(counts, storage.TrendingCount{Code: k.code, Minute: time.Unix(k.minute, 0).UTC(), Clicks: n})
+	}
+	// a fixed order, so concurrent instances update rows in the same order
+	slices.SortFunc(counts, func(a, b storage.TrendingCount) int {
+		return cmp.Or(cmp.Compare(a.Code, b.Code), a.Minute.Compare(b.Minute))
+	})
+
+	err := tc.store.SaveTrending(ctx, counts, expired)
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.saving = false
+	if err != nil {
+		for k, n := range pending {
+			if k.minute >= expired.Unix() {
+				tc.pending[k] += n
+			}
+		}
+	}
+	return err
+}
+
+// Top returns up to limit links of the user, or of every user when userID
+// is 0, with the most redirects within window, which is counted in whole
+// minutes and includes the current one. The counts of the storage are
+// merged with the pending ones of this instance, so its own redirects are
+// never missing and nothing is flushed. The storage is read without
+// holding up flushes; when one moved counts meanwhile, Top reads again.
+func (tc *TrendingCounter) Top(ctx context.Context, userID int, window time.Duration, limit int) ([]storage.LinkCount, error) {
+	since := time.Now().Truncate(time.Minute).Add(time.Minute - window)
+
+	for attempt := 1; ; attempt++ {
+		pending := make(map[string]int)
+		tc.mu.Lock()
+		for k, n := range tc.pending {
+			if k.minute >= since.Unix() {
+				pending[k.code] += n
+			}
+		}
+		flushes, saving := tc.flushes, tc.saving
+		tc.mu.Unlock()
+
+		top, err := tc.merge(ctx, userID, since, limit, pending)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.mu.Lock()
+		moved := saving || tc.flushes != flushes
+		tc.mu.Unlock()
+		if !moved || attempt == trendingTopAttempts {
+			return top, nil
+		}
+	}
+}
+
+// merge returns the top limit links of the storage with pending counts
+// added
+func (tc *TrendingCounter) merge(ctx context.Context, userID int, since time.Time, limit int, pending map[string]int) ([]storage.LinkCount, error) {
+	// a link without pending counts can only be pushed out of the top by
+	// the ones with, so limit+len(pending) stored links always hold it
+	top, err := tc.store.TopLinks(ctx, userID, since, limit+len(pending))
+	if err != nil || len(pending) == 0 {
+		return top, err
+	}
+	var missing []string
+	for code := range pending {
+		if !slices.ContainsFunc(top, func(c storage.LinkCount) bool { return c.Code == code }) {
+			missing = append(missing, code)
+		}
+	}
+	if len(missing) > 0 {
+		counts, err := tc.store.LinkCounts(ctx, missing, since)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range counts {
+			if userID == 0 || c.UserID == userID {
+				top = append(top, c)
+			}
+		}
+	}
+
+	for i := range top {
+		top[i].Clicks += pending[top[i].Code]
+	}
+	// in the order TopLinks returns
+	slices.SortFunc(top, func(a, b storage.LinkCount) int {
+		return cmp.Or(cmp.Compare(b.Clicks, a.Clicks), cmp.Compare(a.Code, b.Code))
+	})
+	return top[:min(len(top), limit)], nil
+}
+
+// Stop saves pending counts and ends the counter
+func (tc *TrendingCounter) Stop() {
+	close(tc.doneCh)
+	tc.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tc.flush(ctx); err != nil {
+		logger.Log.Error("save trending counts error", zap.Error(err))
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+)
+
+// DefaultAliasAlphabet characters a custom alias may consist of by default
+const DefaultAliasAlphabet = Base62Alphabet + "-_"
+
+const (
+	defaultAliasMinLength = 3
+	defaultAliasMaxLength = 32
+	aliasSuggestTries     = 20
+)
+
+// builtinReservedAliases can never be chosen, whatever the configuration says
+var builtinReservedAliases = []string{"api", "ping"}
+
+var (
+	// ErrInvalidAlias alias does not match the configured alphabet or length
+	ErrInvalidAlias = errors.New("invalid alias")
+	// ErrReservedAlias alias is reserved for the service itself
+	ErrReservedAlias = errors.New("alias is reserved")
+	// ErrAliasTaken alias is already used by another link
+	ErrAliasTaken = errors.New("alias already taken")
+)
+
+// AliasPolicy decides which user chosen codes are acceptable
+type AliasPolicy struct {
+	alphabet string
+	minLen   int
+	maxLen   int
+	// namespace of generated codes aliases must stay out of
+	codeAlphabet string
+	codeLen      int
+
+	mu       sync.RWMutex
+	reserved map[string]struct{}
+}
+
+// NewAliasPolicy creates AliasPolicy from cfg; zero values fall back to the defaults
+func NewAliasPolicy(cfg *config.Config) (*AliasPolicy, error) {
+	alphabet := cfg.AliasAlphabet
+	if alphabet == "" {
+		alphabet = DefaultAliasAlphabet
+	}
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, fmt.Errorf("alias %w", err)
+	}
+
+	minLen, maxLen := cfg.AliasMinLength, cfg.AliasMaxLength
+	if minLen == 0 {
+		minLen = defaultAliasMinLength
+	}
+	if maxLen == 0 {
+		maxLen = defaultAliasMaxLength
+	}
+	if minLen < 1 || maxLen < minLen || maxLen > MaxCodeLength {
+		return nil, fmt.Errorf("alias length range %d..%d is not within 1..%d", minLen, maxLen, MaxCodeLength)
+	}
+
+	p := &AliasPolicy{
+		alphabet: alphabet,
+		minLen:   minLen,
+		maxLen:   maxLen,
+		reserved: make(map[string]struct{}),
+	}
+	p.codeAlphabet, p.codeLen, _ = leaseNamespace(cfg)
+	p.Reserve(builtinReservedAliases...)
+	p.Reserve(cfg.AliasReserved...)
+	return p, nil
+}
+
+// Reserve forbids words as aliases. Words are compared case-insensitively.
+func (p *AliasPolicy) Reserve(words ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			p.reserved[strings.ToLower(w)] = struct{}{}
+		}
+	}
+}
+
+// Validate returns ErrInvalidAlias or ErrReservedAlias, wrapped with the reason
+func (p *AliasPolicy) Validate(alias string) error {
+	if len(alias) < p.minLen || len(alias) > p.maxLen {
+		return fmt.Errorf("%w: length must be between %d and %d", ErrInvalidAlias, p.minLen, p.maxLen)
+	}
+	for i := 0; i < len(alias); i++ {
+		if strings.IndexByte(p.alphabet, alias[i]) < 0 {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidAlias, alias[i])
+		}
+	}
+
+	if p.isGeneratedCode(alias) {
+		return fmt.Errorf("%w: codes of %d characters are generated", ErrReservedAlias, p.codeLen)
+	}
+
+	p.mu.RLock()
+	_, ok := p.reserved[strings.ToLower(alias)]
+	p.mu.RUnlock()
+	if ok {
+		return fmt.Errorf("%w: %s", ErrReservedAlias, alias)
+	}
+	return nil
+}
+
+// isGeneratedCode reports whether the code generator may produce alias
+func (p *AliasPolicy) isGeneratedCode(alias string) bool {
+	if p.codeLen == 0 || len(alias) != p.codeLen {
+		return false
+	}
+	for i := 0; i < len(alias); i++ {
+		if strings.IndexByte(p.codeAlphabet, alias[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Suggest returns up to n valid aliases close to alias for which available
+// reports true: alias with a numeric suffix first, then with a random one
+func (p *AliasPolicy) Suggest(ctx context.Context, alias string, n int, available func(context.Context, string) (bool, error)) ([]string, error) {
+	base := p.sanitize(alias)
+	suggestions := make([]string, 0, n)
+	seen := make(map[string]bool)
+
+	for i := 1; i <= aliasSuggestTries && len(suggestions) < n; i++ {
+		var suffix string
+		if i <= aliasSuggestTries/2 {
+			suffix = strconv.Itoa(i + 1)
+		} else {
+			var err error
+			if suffix, err = randomString(p.alphabet, 3); err != nil {
+				return nil, err
+			}
+		}
+		candidate := p.withSuffix(base, suffix)
+		if seen[candidate] || p.Validate(candidate) != nil {
+			continue
+		}
+		seen[candidate] = true
+
+		ok, err := available(ctx, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions, nil
+}
+
+// sanitize drops characters outside the alphabet
+func (p *AliasPolicy) sanitize(alias string) string {
+	var b strings.Builder
+	for i := 0; i < len(alias) && b.Len() < p.maxLen; i++ {
+		if strings.IndexByte(p.alphabet, alias[i]) >= 0 {
+			b.WriteByte(alias[i])
+		}
+	}
+	return b.String()
+}
+
+// withSuffix appends suffix, cutting base so the result fits maxLen
+func (p *AliasPolicy) withSuffix(base, suffix string) string {
+	if len(base)+len(suffix) > p.maxLen {
+		base = base[:max(0, p.maxLen-len(suffix))]
+	}
+	return base + suffix
+}
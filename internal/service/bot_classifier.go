@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+)
+
+// DefaultBotPatterns match the user agents of common crawlers and link
+// preview fetchers of messengers and social networks
+var DefaultBotPatterns = []string{
+	`bot\b`,
+	`crawl`,
+	`spider`,
+	`slurp`,
+	`facebookexternalhit`,
+	`whatsapp`,
+	`skypeuripreview`,
+	`embedly`,
+	`preview`,
+	`headlesschrome`,
+}
+
+const defaultBotPatternsInterval = 30 * time.Second
+
+// prefetchHeaders are the headers browsers mark speculative loads with
+var prefetchHeaders = []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"}
+
+// BotClassifier tells the redirects of bots and prefetches from the ones
+// people follow
+type BotClassifier struct {
+	file *watchedFile
+
+	mu      sync.RWMutex
+	pattern *regexp.Regexp
+}
+
+// NewBotClassifier creates BotClassifier from cfg. The patterns file, if
+// set, replaces DefaultBotPatterns and must be readable.
+func NewBotClassifier(cfg *config.Config) (*BotClassifier, error) {
+	c := &BotClassifier{}
+	c.file = newWatchedFile("bot patterns", cfg.BotPatternsFile, c.load)
+	if cfg.BotPatternsFile != "" {
+		if err := c.Reload(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	pattern, err := compileBotPatterns(DefaultBotPatterns)
+	if err != nil {
+		return nil, err
+	}
+	c.pattern = pattern
+	return c, nil
+}
+
+// IsBot reports whether r is a HEAD request, a prefetch or preview, comes
+// without a user agent or with one that matches the bot patterns
+func (c *BotClassifier) IsBot(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	for _, name := range prefetchHeaders {
+		v := strings.ToLower(r.Header.Get(name))
+		if strings.Contains(v, "prefetch") || strings.Contains(v, "preview") || strings.Contains(v, "prerender") {
+			return true
+		}
+	}
+	ua := r.UserAgent()
+	if ua == "" {
+		return true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pattern.MatchString(ua)
+}
+
+// compileBotPatterns joins case-insensitive regular expressions into one
+func compileBotPatterns(patterns []string) (*regexp.Regexp, error) {
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("bot pattern %q: %w", p, err)
+		}
+	}
+	if len(patterns) == 0 {
+		// matches nothing
+		return regexp.MustCompile(`[^\s\S]`), nil
+	}
+	return regexp.Compile(`(?i)(?:` + strings.Join(patterns, `)|(?:`) + `)`)
+}
+
+// Reload rereads the patterns file if it changed since the last load. The
+// file has one regular expression per line, matched against user agents
+// case-insensitively; blank lines and lines starting with # are skipped.
+func (c *BotClassifier) Reload() error {
+	return c.file.reload()
+}
+
+// load puts the patterns of the file at path in force
+func (c *BotClassifier) load(path string) error {
+	patterns, err := readBotPatterns(path)
+	if err != nil {
+		return err
+	}
+	pattern, err := compileBotPatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pattern = pattern
+	return nil
+}
+
+func readBotPatterns(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// Watch reloads the patterns file every interval until ctx is done.
+// A file that fails to load keeps the previous patterns in force.
+func (c *BotClassifier) Watch(ctx context.Context, interval time.Duration) {
+	c.file.watch(ctx, interval, defaultBotPatternsInterval)
+}
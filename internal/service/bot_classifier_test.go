@@ -0,0 +1,75 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+)
+
+const firefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+func TestBotClassifierIsBot(t *testing.T) {
+	c, err := NewBotClassifier(&config.Config{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		bot     bool
+	}{
+		{"браузер", http.MethodGet, map[string]string{"User-Agent": firefoxUA}, false},
+		{"Slack", http.MethodGet, map[string]string{"User-Agent": "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}, true},
+		{"Telegram", http.MethodGet, map[string]string{"User-Agent": "TelegramBot (like TwitterBot)"}, true},
+		{"Facebook", http.MethodGet, map[string]string{"User-Agent": "facebookexternalhit/1.1"}, true},
+		{"без user agent", http.MethodGet, nil, true},
+		{"HEAD", http.MethodHead, map[string]string{"User-Agent": firefoxUA}, true},
+		{"prefetch", http.MethodGet, map[string]string{"User-Agent": firefoxUA, "Purpose": "prefetch"}, true},
+		{"Sec-Purpose", http.MethodGet, map[string]string{"User-Agent": firefoxUA, "Sec-Purpose": "prefetch;prerender"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/abc", nil)
+			r.Header.Del("User-Agent")
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.bot, c.IsBot(r))
+		})
+	}
+}
+
+func TestBotClassifierReload(t *testing.T) {
+	patterns := filepath.Join(t.TempDir(), "bots.txt")
+	require.NoError(t, os.WriteFile(patterns, []byte("# свои шаблоны\nacme-checker\n"), 0644))
+	c, err := NewBotClassifier(&config.Config{BotPatternsFile: patterns})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	r.Header.Set("User-Agent", "ACME-Checker/2.0")
+	assert.True(t, c.IsBot(r), "patterns are case-insensitive")
+	r.Header.Set("User-Agent", "Googlebot/2.1")
+	assert.False(t, c.IsBot(r), "the file replaces the default patterns")
+
+	require.NoError(t, os.WriteFile(patterns, []byte("googlebot\n"), 0644))
+	require.NoError(t, os.Chtimes(patterns, time.Now(), time.Now().Add(time.Second)))
+	require.NoError(t, c.Reload())
+	assert.True(t, c.IsBot(r))
+
+	// испорченный файл не отменяет действующие шаблоны
+	require.NoError(t, os.WriteFile(patterns, []byte("bad(\n"), 0644))
+	require.NoError(t, os.Chtimes(patterns, time.Now(), time.Now().Add(2*time.Second)))
+	assert.Error(t, c.Reload())
+	assert.True(t, c.IsBot(r))
+
+	_, err = NewBotClassifier(&config.Config{BotPatternsFile: filepath.Join(t.TempDir(), "missing.txt")})
+	assert.Error(t, err)
+}
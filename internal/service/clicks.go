@@ -0,0 +1,36 @@
+package service
+
+import (
+	"net"
+	"net/netip"
+)
+
+// Prefix lengths that survive anonymization: the network of a visitor is
+// kept for unique counts and breakdowns, the host is dropped
+const (
+	anonymizedIPv4Bits = 24
+	anonymizedIPv6Bits = 48
+)
+
+// AnonymizeIP zeroes the host part of addr, given with or without a port.
+// Unparsable addresses give an empty string.
+func AnonymizeIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	ip = ip.Unmap().WithZone("")
+
+	bits := anonymizedIPv6Bits
+	if ip.Is4() {
+		bits = anonymizedIPv4Bits
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"203.0.113.77", "203.0.113.0"},
+		{"203.0.113.77:54321", "203.0.113.0"},
+		{"[2001:db8:abcd:12::1]:443", "2001:db8:abcd::"},
+		{"::ffff:203.0.113.77", "203.0.113.0"},
+		{"fe80::1%eth0", "fe80::"},
+		{"not an ip", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.want, AnonymizeIP(tt.addr))
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+)
+
+// Code generation strategies
+const (
+	// CodeStrategyRandom draws every character from crypto/rand
+	CodeStrategyRandom = "random"
+	// CodeStrategySequence encodes an incrementing counter
+	CodeStrategySequence = "sequence"
+	// CodeStrategyFeistel encodes a counter scrambled by a Feistel network,
+	// so consecutive codes do not look consecutive
+	CodeStrategyFeistel = "feistel"
+	// CodeStrategyLease encodes IDs from blocks leased from the storage,
+	// so instances never hand out the same code
+	CodeStrategyLease = "lease"
+)
+
+// Encodings of leased IDs
+const (
+	// CodeEncodingBase62 writes IDs in Base62Alphabet
+	CodeEncodingBase62 = "base62"
+	// CodeEncodingFeistel scrambles IDs and writes them in the code alphabet
+	CodeEncodingFeistel = "feistel"
+)
+
+// Base62Alphabet digits and latin letters of both cases
+const Base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// DefaultAlphabet is Base62Alphabet without characters that are easy to
+// confuse when a link is read aloud or retyped: 0 O o 1 l I
+const DefaultAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+
+const (
+	defaultCodeLength      = 6
+	defaultCodeMaxAttempts = 5
+	defaultCodeLeaseSize   = 100
+)
+
+// MaxCodeLength is the width of the urls.code column
+const MaxCodeLength = 64
+
+// ErrCodeSpaceExhausted every attempt produced a code that is already taken
+var ErrCodeSpaceExhausted = errors.New("no free short code found")
+
+// CodeGenerator produces short codes
+type CodeGenerator interface {
+	// Generate returns a new code. Codes may collide with stored ones;
+	// use SaveWithCode to retry on collisions.
+	Generate(ctx context.Context) (string, error)
+}
+
+// NewCodeGenerator creates the generator selected by cfg.CodeStrategy.
+// Zero values of the code settings fall back to the defaults.
+// leaser is only used by the lease strategy.
+func NewCodeGenerator(cfg *config.Config, leaser storage.IDLeaser) (CodeGenerator, error) {
+	alphabet := cfg.CodeAlphabet
+	if alphabet == "" {
+		alphabet = DefaultAlphabet
+	}
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+
+	length := cfg.CodeLength
+	if length == 0 {
+		length = defaultCodeLength
+	}
+	if length < 1 || length > MaxCodeLength {
+		return nil, fmt.Errorf("code length %d is out of range 1..%d", length, MaxCodeLength)
+	}
+
+	switch cfg.CodeStrategy {
+	case "", CodeStrategyRandom:
+		return &randomGenerator{alphabet: alphabet, length: length}, nil
+	case CodeStrategySequence:
+		space, err := codeSpace(alphabet, length)
+		if err != nil {
+			return nil, err
+		}
+		return newSequenceGenerator(alphabet, length, space, nil)
+	case CodeStrategyFeistel:
+		space, err := codeSpace(alphabet, length)
+		if err != nil {
+			return nil, err
+		}
+		return newSequenceGenerator(alphabet, length, space, newFeistel(space, cfg.SecretKey))
+	case CodeStrategyLease:
+		return newLeaseGenerator(cfg, leaser)
+	default:
+		return nil, fmt.Errorf("unknown code strategy %q", cfg.CodeStrategy)
+	}
+}
+
+// validateAlphabet accepts at least two distinct characters that need no
+// escaping in a URL path
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("code alphabet needs at least two characters")
+	}
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if !isUnreserved(c) {
+			return fmt.Errorf("code alphabet: %q is not allowed in a URL path", c)
+		}
+		if strings.IndexByte(alphabet[:i], c) >= 0 {
+			return fmt.Errorf("code alphabet: %q repeats", c)
+		}
+	}
+	return nil
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '~'
+}
+
+// codeSpace returns the number of distinct codes of length characters
+func codeSpace(alphabet string, length int) (uint64, error) {
+	space := uint64(1)
+	for range length {
+		hi, lo := bits.Mul64(space, uint64(len(alphabet)))
+		if hi != 0 || lo > math.MaxInt64 {
+			return 0, fmt.Errorf("%d characters of a %d letter alphabet do not fit into 63 bits", length, len(alphabet))
+		}
+		space = lo
+	}
+	return space, nil
+}
+
+// leaseNamespace returns the alphabet and the length of the codes the lease
+// strategy produces. ok is false for the other strategies.
+func leaseNamespace(cfg *config.Config) (alphabet string, length int, ok bool) {
+	if cfg.CodeStrategy != CodeStrategyLease {
+		return "", 0, false
+	}
+	length = cfg.CodeLength
+	if length == 0 {
+		length = defaultCodeLength
+	}
+	switch cfg.CodeLeaseEncoding {
+	case "", CodeEncodingBase62:
+		return Base62Alphabet, length, true
+	default:
+		return cmp.Or(cfg.CodeAlphabet, DefaultAlphabet), length, true
+	}
+}
+
+// SaveWithCode calls save with codes from gen until it stops failing with
+// storage.ErrCodeAlreadyExists, at most attempts times, and returns the code
+// that was saved
+func SaveWithCode(ctx context.Context, gen CodeGenerator, attempts int, save func(code string) error) (string, error) {
+	var code string
+	err := retryOnCollision(attempts, func() error {
+		var err error
+		if code, err = gen.Generate(ctx); err != nil {
+			return err
+		}
+		return save(code)
+	})
+	return code, err
+}
+
+// SaveBatchWithCodes is SaveWithCode for n codes saved at once:
+// on a collision the whole batch gets fresh codes
+func SaveBatchWithCodes(ctx context.Context, gen CodeGenerator, attempts int, n int, save func(codes []string) error) ([]string, error) {
+	codes := make([]string, n)
+	err := retryOnCollision(attempts, func() error {
+		for i := range codes {
+			code, err := gen.Generate(ctx)
+			if err != nil {
+				return err
+			}
+			codes[i] = code
+		}
+		return save(codes)
+	})
+	return codes, err
+}
+
+func retryOnCollision(attempts int, try func() error) error {
+	if attempts <= 0 {
+		attempts = defaultCodeMaxAttempts
+	}
+	for range attempts {
+		err := try()
+		if !errors.Is(err, storage.ErrCodeAlreadyExists) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w after %d attempts", ErrCodeSpaceExhausted, attempts)
+}
+
+// randomGenerator draws every character independently from crypto/rand
+type randomGenerator struct {
+	alphabet string
+	length   int
+}
+
+func (g *randomGenerator) Generate(context.Context) (string, error) {
+	return randomString(g.alphabet, g.length)
+}
+
+func randomString(alphabet string, length int) (string, error) {
+	ret := make([]byte, length)
+	limit := big.NewInt(int64(len(alphabet)))
+	for i := range ret {
+		num, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		ret[i] = alphabet[num.Int64()]
+	}
+	return string(ret), nil
+}
+
+// sequenceGenerator encodes an in-process counter, optionally scrambled.
+// The counter starts at a random point of the code space, so restarted or
+// parallel instances rarely walk over the same codes; when they do, the
+// collision is retried like any other.
+type sequenceGenerator struct {
+	alphabet string
+	length   int
+	space    uint64
+	scramble *feistel
+	next     atomic.Uint64
+}
+
+func newSequenceGenerator(alphabet string, length int, space uint64, scramble *feistel) (*sequenceGenerator, error) {
+	start, err := rand.Int(rand.Reader, new(big.Int).SetUint64(space))
+	if err != nil {
+		return nil, err
+	}
+	g := &sequenceGenerator{alphabet: alphabet, length: length, space: space, scramble: scramble}
+	g.next.Store(start.Uint64())
+	return g, nil
+}
+
+func (g *sequenceGenerator) Generate(context.Context) (string, error) {
+	n := (g.next.Add(1) - 1) % g.space
+	if g.scramble != nil {
+		n = g.scramble.permute(n)
+	}
+	return EncodeCode(n, g.alphabet, g.length), nil
+}
+
+// leaseGenerator hands out IDs of a block leased from the storage and
+// leases the next block once the current one is used up. Its codes have a
+// fixed length, so AliasPolicy can keep aliases out of their namespace.
+// Codes left by an earlier strategy or by aliases made before that can
+// still be taken; a taken code is retried with the next ID.
+type leaseGenerator struct {
+	leaser storage.IDLeaser
+	size   int
+	space  uint64
+	encode func(id uint64) string
+
+	mu   sync.Mutex
+	next int64
+	end  int64
+}
+
+func newLeaseGenerator(cfg *config.Config, leaser storage.IDLeaser) (*leaseGenerator, error) {
+	if leaser == nil {
+		return nil, errors.New("lease strategy needs a storage that leases IDs")
+	}
+	size := cfg.CodeLeaseSize
+	if size == 0 {
+		size = defaultCodeLeaseSize
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("code lease size %d is negative", size)
+	}
+	if enc := cfg.CodeLeaseEncoding; enc != "" && enc != CodeEncodingBase62 && enc != CodeEncodingFeistel {
+		return nil, fmt.Errorf("unknown code lease encoding %q", enc)
+	}
+
+	alphabet, length, _ := leaseNamespace(cfg)
+	space, err := codeSpace(alphabet, length)
+	if err != nil {
+		return nil, err
+	}
+	g := &leaseGenerator{leaser: leaser, size: size, space: space}
+	g.encode = func(id uint64) string {
+		return EncodeCode(id, alphabet, length)
+	}
+	if cfg.CodeLeaseEncoding == CodeEncodingFeistel {
+		scramble := newFeistel(space, cfg.SecretKey)
+		g.encode = func(id uint64) string {
+			return EncodeCode(scramble.permute(id), alphabet, length)
+		}
+	}
+	return g, nil
+}
+
+func (g *leaseGenerator) Generate(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next == g.end {
+		start, err := g.leaser.LeaseIDs(ctx, g.size)
+		if err != nil {
+			return "", fmt.Errorf("lease ids: %w", err)
+		}
+		g.next, g.end = start, start+int64(g.size)
+	}
+	id := g.next
+	if uint64(id) >= g.space {
+		return "", fmt.Errorf("%w: leased id %d needs more than the code length", ErrCodeSpaceExhausted, id)
+	}
+	g.next++
+	return g.encode(uint64(id)), nil
+}
+
+// EncodeCode writes n in base len(alphabet), left-padded to length characters
+func EncodeCode(n uint64, alphabet string, length int) string {
+	base := uint64(len(alphabet))
+	buf := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buf[i] = alphabet[n%base]
+		n /= base
+	}
+	for n > 0 {
+		buf = append([]byte{alphabet[n%base]}, buf...)
+		n /= base
+	}
+	return string(buf)
+}
+
+// feistelRounds is enough rounds for the output to look unrelated to the input
+const feistelRounds = 4
+
+// feistel is a keyed permutation of [0, space): a balanced Feistel network
+// over the smallest even bit width covering space, with cycle walking to
+// stay inside it
+type feistel struct {
+	key   []byte
+	half  uint
+	mask  uint64
+	space uint64
+}
+
+func newFeistel(space uint64, secret string) *feistel {
+	width := uint(bits.Len64(space - 1))
+	half := (width + 1) / 2
+	key := sha256.Sum256([]byte("short code feistel:" + secret))
+	return &feistel{key: key[:], half: half, mask: 1<<half - 1, space: space}
+}
+
+func (f *feistel) permute(n uint64) uint64 {
+	for {
+		n = f.round(n)
+		if n < f.space {
+			return n
+		}
+	}
+}
+
+func (f *feistel) round(n uint64) uint64 {
+	l, r := n>>f.half, n&f.mask
+	for i := range feistelRounds {
+		l, r = r, l^f.f(byte(i), r)
+	}
+	return l<<f.half | r
+}
+
+func (f *feistel) f(round byte, r uint64) uint64 {
+	mac := hmac.New(sha256.New, f.key)
+	var buf [9]byte
+	buf[0] = round
+	binary.BigEndian.PutUint64(buf[1:], r)
+	mac.Write(buf[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil)) & f.mask
+}
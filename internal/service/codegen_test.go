@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+)
+
+func TestCodeGenerators(t *testing.T) {
+	for _, strategy := range []string{CodeStrategyRandom, CodeStrategySequence, CodeStrategyFeistel} {
+		t.Run(strategy, func(t *testing.T) {
+			gen, err := NewCodeGenerator(&config.Config{CodeStrategy: strategy, CodeLength: 4, SecretKey: "secret"}, nil)
+			require.NoError(t, err)
+
+			seen := make(map[string]bool)
+			for range 1000 {
+				code, err := gen.Generate(context.Background())
+				require.NoError(t, err)
+				require.Len(t, code, 4)
+				for _, c := range code {
+					require.True(t, strings.ContainsRune(DefaultAlphabet, c), "unexpected %q in %s", c, code)
+				}
+				seen[code] = true
+			}
+			if strategy != CodeStrategyRandom {
+				assert.Len(t, seen, 1000, "counter based codes must not repeat")
+			}
+		})
+	}
+}
+
+func TestLeaseGenerator(t *testing.T) {
+	for _, encoding := range []string{CodeEncodingBase62, CodeEncodingFeistel} {
+		t.Run(encoding, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			cfg := &config.Config{CodeStrategy: CodeStrategyLease, CodeLeaseSize: 10, CodeLeaseEncoding: encoding}
+
+			// two instances sharing one storage never produce the same code
+			seen := make(map[string]bool)
+			for range 2 {
+				gen, err := NewCodeGenerator(cfg, store)
+				require.NoError(t, err)
+				for range 55 {
+					code, err := gen.Generate(context.Background())
+					require.NoError(t, err)
+					require.False(t, seen[code], "%s is produced twice", code)
+					seen[code] = true
+				}
+			}
+		})
+	}
+}
+
+func TestLeaseNamespace(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := &config.Config{CodeStrategy: CodeStrategyLease, CodeLength: 2, CodeLeaseSize: 1000, AliasMinLength: 2}
+	gen, err := NewCodeGenerator(cfg, store)
+	require.NoError(t, err)
+	aliases, err := NewAliasPolicy(cfg)
+	require.NoError(t, err)
+
+	// коды фиксированной длины, а алиасы в их пространство не попадают
+	for {
+		code, err := gen.Generate(context.Background())
+		if err != nil {
+			assert.ErrorIs(t, err, ErrCodeSpaceExhausted)
+			break
+		}
+		require.Len(t, code, 2)
+		require.ErrorIs(t, aliases.Validate(code), ErrReservedAlias)
+	}
+
+	aliases, err = NewAliasPolicy(&config.Config{CodeStrategy: CodeStrategyLease, AliasMinLength: 2})
+	require.NoError(t, err)
+	assert.ErrorIs(t, aliases.Validate("promo1"), ErrReservedAlias)
+	assert.NoError(t, aliases.Validate("promo"))
+	assert.NoError(t, aliases.Validate("promo12"))
+	assert.NoError(t, aliases.Validate("promo-"))
+
+	// без аренды длина кода алиасам не мешает
+	aliases, err = NewAliasPolicy(&config.Config{})
+	require.NoError(t, err)
+	assert.NoError(t, aliases.Validate("promo1"))
+}
+
+func TestFeistelIsPermutation(t *testing.T) {
+	const space = 1000
+	f := newFeistel(space, "secret")
+	seen := make(map[uint64]bool, space)
+	for n := range uint64(space) {
+		p := f.permute(n)
+		require.Less(t, p, uint64(space))
+		require.False(t, seen[p], "%d is produced twice", p)
+		seen[p] = true
+	}
+}
+
+func TestNewCodeGeneratorValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{"неизвестная стратегия", config.Config{CodeStrategy: "uuid"}},
+		{"слишком длинный код", config.Config{CodeLength: 65}},
+		{"повтор символа", config.Config{CodeAlphabet: "abca"}},
+		{"символ вне URL", config.Config{CodeAlphabet: "ab/"}},
+		{"аренда без хранилища", config.Config{CodeStrategy: CodeStrategyLease}},
+		{"неизвестная кодировка", config.Config{CodeStrategy: CodeStrategyLease, CodeLeaseEncoding: "hex"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCodeGenerator(&tt.cfg, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSaveWithCode(t *testing.T) {
+	gen, err := NewCodeGenerator(&config.Config{CodeStrategy: CodeStrategySequence}, nil)
+	require.NoError(t, err)
+
+	calls := 0
+	code, err := SaveWithCode(context.Background(), gen, 3, func(code string) error {
+		calls++
+		if calls < 3 {
+			return storage.ErrCodeAlreadyExists
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, code)
+	assert.Equal(t, 3, calls, "collisions must be retried")
+
+	_, err = SaveWithCode(context.Background(), gen, 2, func(string) error {
+		return storage.ErrCodeAlreadyExists
+	})
+	assert.ErrorIs(t, err, ErrCodeSpaceExhausted)
+
+	calls = 0
+	_, err = SaveWithCode(context.Background(), gen, 3, func(string) error {
+		calls++
+		return storage.ErrURLAlreadyExists
+	})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+	assert.Equal(t, 1, calls, "other errors must not be retried")
+
+	leased, err := NewCodeGenerator(&config.Config{CodeStrategy: CodeStrategyLease}, storage.NewMemoryStorage())
+	require.NoError(t, err)
+	var tried []string
+	code, err = SaveWithCode(context.Background(), leased, 3, func(code string) error {
+		tried = append(tried, code)
+		if len(tried) < 3 {
+			// left over from an earlier strategy
+			return storage.ErrCodeAlreadyExists
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"000001", "000002", "000003"}, tried, "a taken leased code is retried with the next ID")
+	assert.Equal(t, "000003", code)
+}
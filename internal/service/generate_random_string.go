@@ -3,11 +3,6 @@ Package service for tools
 */
 package service
 
-import (
-	"crypto/rand"
-	"math/big"
-)
-
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
 // GenerateRandomString returns a securely generated random string.
@@ -15,14 +10,5 @@ const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 // number generator fails to function correctly, in which
 // case the caller should not continue.
 func GenerateRandomString(length int) (string, error) {
-	ret := make([]byte, length)
-	for i := 0; i < length; i++ {
-		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
-		if err != nil {
-			return "", err
-		}
-		ret[i] = charset[num.Int64()]
-	}
-
-	return string(ret), nil
+	return randomString(charset, length)
 }
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const defaultGeoIPInterval = time.Minute
+
+// GeoInfo is where an address is registered; fields the database does not
+// know are empty
+type GeoInfo struct {
+	// Country is an ISO 3166-1 code, like "DE"
+	Country string
+	// Region is an ISO 3166-2 code, like "DE-BE"
+	Region string
+	// ASN is the number of the autonomous system that announces the address
+	ASN int
+}
+
+// geoRecord is the part of a GeoIP2/GeoLite2 City, Country or ASN record
+// GeoInfo is filled from
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	ASN int `maxminddb:"autonomous_system_number"`
+}
+
+// GeoResolver looks addresses up in local MaxMind-format databases, so
+// no visitor address leaves the service. Countries and regions come from
+// a City or Country database, ASNs from a separate ASN database when one
+// is configured and from the first one otherwise.
+type GeoResolver struct {
+	location *geoDatabase
+	asn      *geoDatabase
+}
+
+// geoDatabase is one database file, read again when it changes
+type geoDatabase struct {
+	file *watchedFile
+
+	mu     sync.RWMutex
+	reader *maxminddb.Reader
+}
+
+// NewGeoResolver creates GeoResolver for the databases in cfg. Without
+// them every lookup is empty; configured databases must be readable.
+func NewGeoResolver(cfg *config.Config) (*GeoResolver, error) {
+	g := &GeoResolver{
+		location: newGeoDatabase("geoip database", cfg.GeoIPFile),
+		asn:      newGeoDatabase("geoip asn database", cfg.GeoIPASNFile),
+	}
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func newGeoDatabase(name, path string) *geoDatabase {
+	d := &geoDatabase{}
+	d.file = newWatchedFile(name, path, d.load)
+	return d
+}
+
+// Lookup returns where addr is registered
+func (g *GeoResolver) Lookup(addr netip.Addr) GeoInfo {
+	if !addr.IsValid() {
+		return GeoInfo{}
+	}
+	ip := net.IP(addr.Unmap().AsSlice())
+
+	var rec geoRecord
+	g.location.lookup(ip, &rec)
+	info := GeoInfo{Country: rec.Country.ISOCode, ASN: rec.ASN}
+	if len(rec.Subdivisions) > 0 && rec.Subdivisions[0].ISOCode != "" && info.Country != "" {
+		info.Region = info.Country + "-" + rec.Subdivisions[0].ISOCode
+	}
+	var asn geoRecord
+	if g.asn.lookup(ip, &asn) {
+		info.ASN = asn.ASN
+	}
+	return info
+}
+
+// Reload rereads the databases whose files changed since the last load.
+// The files are read into memory, so they may be replaced in place.
+func (g *GeoResolver) Reload() error {
+	return errors.Join(g.location.reload(), g.asn.reload())
+}
+
+// Watch reloads the databases every interval until ctx is done.
+// A file that fails to load keeps the previous database in use.
+func (g *GeoResolver) Watch(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	for _, d := range []*geoDatabase{g.location, g.asn} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.file.watch(ctx, interval, defaultGeoIPInterval)
+		}()
+	}
+	wg.Wait()
+}
+
+// reload rereads the database if it is configured and its file changed
+func (d *geoDatabase) reload() error {
+	if d.file.path == "" {
+		return nil
+	}
+	return d.file.reload()
+}
+
+// lookup reads the record of ip into rec and reports whether the database
+// is in use
+func (d *geoDatabase) lookup(ip net.IP, rec *geoRecord) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.reader == nil {
+		return false
+	}
+	if err := d.reader.Lookup(ip, rec); err != nil {
+		// an IPv6 address in an IPv4 database
+		*rec = geoRecord{}
+	}
+	return true
+}
+
+// load puts the database at path in use
+func (d *geoDatabase) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reader = reader
+	return nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/service/geotest"
+)
+
+func TestGeoResolverLookup(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "geo.mmdb")
+	geotest.WriteMMDB(t, db, map[string]geotest.Record{
+		"81.2.69.0/24":   {Country: "GB", Region: "ENG", ASN: 20712},
+		"89.160.20.0/22": {Country: "SE"},
+	})
+	g, err := NewGeoResolver(&config.Config{GeoIPFile: db})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		addr string
+		want GeoInfo
+	}{
+		{"страна, регион и AS", "81.2.69.160", GeoInfo{Country: "GB", Region: "GB-ENG", ASN: 20712}},
+		{"только страна", "89.160.23.1", GeoInfo{Country: "SE"}},
+		{"IPv4 в IPv6", "::ffff:81.2.69.1", GeoInfo{Country: "GB", Region: "GB-ENG", ASN: 20712}},
+		{"неизвестная сеть", "8.8.8.8", GeoInfo{}},
+		{"IPv6 в базе IPv4", "2001:db8::1", GeoInfo{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, g.Lookup(netip.MustParseAddr(tt.addr)))
+		})
+	}
+	assert.Equal(t, GeoInfo{}, g.Lookup(netip.Addr{}))
+}
+
+func TestGeoResolverASNDatabase(t *testing.T) {
+	dir := t.TempDir()
+	city := filepath.Join(dir, "city.mmdb")
+	geotest.WriteMMDB(t, city, map[string]geotest.Record{"81.2.69.0/24": {Country: "GB", Region: "ENG"}})
+	asn := filepath.Join(dir, "asn.mmdb")
+	geotest.WriteMMDB(t, asn, map[string]geotest.Record{"81.2.69.0/24": {ASN: 20712}})
+	g, err := NewGeoResolver(&config.Config{GeoIPFile: city, GeoIPASNFile: asn})
+	require.NoError(t, err)
+
+	// страна и регион из одной базы, AS из другой
+	assert.Equal(t, GeoInfo{Country: "GB", Region: "GB-ENG", ASN: 20712}, g.Lookup(netip.MustParseAddr("81.2.69.160")))
+	assert.Equal(t, GeoInfo{}, g.Lookup(netip.MustParseAddr("8.8.8.8")))
+
+	onlyASN, err := NewGeoResolver(&config.Config{GeoIPASNFile: asn})
+	require.NoError(t, err)
+	assert.Equal(t, GeoInfo{ASN: 20712}, onlyASN.Lookup(netip.MustParseAddr("81.2.69.160")))
+
+	// база AS перечитывается так же, как основная
+	geotest.WriteMMDB(t, asn, map[string]geotest.Record{"81.2.69.0/24": {ASN: 5089}})
+	require.NoError(t, os.Chtimes(asn, time.Now(), time.Now().Add(time.Second)))
+	require.NoError(t, g.Reload())
+	assert.Equal(t, 5089, g.Lookup(netip.MustParseAddr("81.2.69.160")).ASN)
+
+	_, err = NewGeoResolver(&config.Config{GeoIPFile: city, GeoIPASNFile: filepath.Join(dir, "missing.mmdb")})
+	assert.Error(t, err)
+}
+
+func TestGeoResolverReload(t *testing.T) {
+	off, err := NewGeoResolver(&config.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, GeoInfo{}, off.Lookup(netip.MustParseAddr("81.2.69.160")), "no database, no lookups")
+
+	db := filepath.Join(t.TempDir(), "geo.mmdb")
+	geotest.WriteMMDB(t, db, map[string]geotest.Record{"81.2.69.0/24": {Country: "GB"}})
+	g, err := NewGeoResolver(&config.Config{GeoIPFile: db})
+	require.NoError(t, err)
+
+	geotest.WriteMMDB(t, db, map[string]geotest.Record{"81.2.69.0/24": {Country: "IE"}})
+	require.NoError(t, os.Chtimes(db, time.Now(), time.Now().Add(time.Second)))
+	require.NoError(t, g.Reload())
+	assert.Equal(t, "IE", g.Lookup(netip.MustParseAddr("81.2.69.160")).Country)
+
+	// испорченный файл не отменяет действующую базу
+	require.NoError(t, os.WriteFile(db, []byte("not a database"), 0644))
+	require.NoError(t, os.Chtimes(db, time.Now(), time.Now().Add(2*time.Second)))
+	assert.Error(t, g.Reload())
+	assert.Equal(t, "IE", g.Lookup(netip.MustParseAddr("81.2.69.160")).Country)
+
+	_, err = NewGeoResolver(&config.Config{GeoIPFile: filepath.Join(t.TempDir(), "missing.mmdb")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+// Package geotest writes small MaxMind-format databases for tests
+package geotest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Record is what a test database knows about one network
+type Record struct {
+	Country string
+	// Region is an ISO 3166-2 subdivision code without the country, like "BE"
+	Region string
+	ASN    uint32
+}
+
+// metadataMarker starts the metadata section of a MaxMind database
+const metadataMarker = "\xab\xcd\xefMaxMind.com"
+
+// Data section types of the MaxMind DB format
+const (
+	typeString = 2
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+)
+
+type node struct {
+	children [2]*node
+	// data holds the offset of a record in the data section plus one;
+	// zero is no data
+	data  [2]int
+	index int
+}
+
+// WriteMMDB writes an IPv4 database with 24-bit records that maps the
+// networks, given in CIDR notation, to records. Networks must not overlap.
+func WriteMMDB(t testing.TB, path string, networks map[string]Record) {
+	t.Helper()
+
+	root := &node{}
+	var data bytes.Buffer
+	for cidr, rec := range networks {
+		prefix, err := netip.ParsePrefix(cidr)
+		require.NoError(t, err)
+		require.True(t, prefix.Addr().Is4(), "only IPv4 networks are supported")
+		require.Positive(t, prefix.Bits())
+
+		offset := data.Len()
+		writeRecord(&data, rec)
+
+		ip := binary.BigEndian.Uint32(prefix.Masked().Addr().AsSlice())
+		n := root
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := ip >> (31 - i) & 1
+			if i == prefix.Bits()-1 {
+				n.data[bit] = offset + 1
+				break
+			}
+			if n.children[bit] == nil {
+				n.children[bit] = &node{}
+			}
+			n = n.children[bit]
+		}
+	}
+
+	var nodes []*node
+	queue := []*node{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		n.index = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	var db bytes.Buffer
+	count := len(nodes)
+	for _, n := range nodes {
+		for bit := range 2 {
+			value := count
+			switch {
+			case n.children[bit] != nil:
+				value = n.children[bit].index
+			case n.data[bit] != 0:
+				value = count + 16 + n.data[bit] - 1
+			}
+			db.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(data.Bytes())
+	db.WriteString(metadataMarker)
+	writeMap(&db, []any{
+		"node_count", uint32(count),
+		"record_size", uint16(24),
+		"ip_version", uint16(4),
+		"database_type", "Test-City-ASN",
+		"languages", [][]any{},
+		"binary_format_major_version", uint16(2),
+		"binary_format_minor_version", uint16(0),
+		"build_epoch", uint64(time.Now().Unix()),
+		"description", []any{},
+	})
+
+	require.NoError(t, os.WriteFile(path, db.Bytes(), 0644))
+}
+
+func writeRecord(buf *bytes.Buffer, rec Record) {
+	var fields []any
+	if rec.Country != "" {
+		fields = append(fields, "country", []any{"iso_code", rec.Country})
+	}
+	if rec.Region != "" {
+		fields = append(fields, "subdivisions", [][]any{{"iso_code", rec.Region}})
+	}
+	if rec.ASN != 0 {
+		fields = append(fields, "autonomous_system_number", rec.ASN)
+	}
+	writeMap(buf, fields)
+}
+
+// writeMap writes alternating keys and values as a map
+func writeMap(buf *bytes.Buffer, pairs []any) {
+	writeControl(buf, typeMap, len(pairs)/2)
+	for _, v := range pairs {
+		writeValue(buf, v)
+	}
+}
+
+func writeValue(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case []any:
+		// a key-value list is a map
+		writeMap(buf, v)
+	case [][]any:
+		writeControl(buf, typeArray, len(v))
+		for _, m := range v {
+			writeMap(buf, m)
+		}
+	default:
+		panic("geotest: unsupported value")
+	}
+}
+
+func writeUint(buf *bytes.Buffer, typ int, v uint64) {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	writeControl(buf, typ, len(b))
+	buf.Write(b)
+}
+
+// writeControl writes the control byte of a value of typ and size, which
+// must be under 285
+func writeControl(buf *bytes.Buffer, typ int, size int) {
+	ctrl := byte(typ << 5)
+	if typ > 7 {
+		ctrl = 0
+	}
+	if size < 29 {
+		ctrl |= byte(size)
+	} else {
+		ctrl |= 29
+	}
+	buf.WriteByte(ctrl)
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	if size >= 29 {
+		buf.WriteByte(byte(size - 29))
+	}
+}
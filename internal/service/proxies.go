@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For is believed
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+}
+
+// NewTrustedProxies parses proxies given as addresses or CIDR networks
+func NewTrustedProxies(proxies []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, s := range proxies {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+	return p, nil
+}
+
+func (p *TrustedProxies) trusted(addr netip.Addr) bool {
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address r came from. Behind trusted proxies it is the
+// last X-Forwarded-For hop that is not a trusted proxy itself; hops added
+// before that are up to the client and are ignored.
+func (p *TrustedProxies) ClientIP(r *http.Request) netip.Addr {
+	addr := parseHostAddr(r.RemoteAddr)
+	if !addr.IsValid() || !p.trusted(addr) {
+		return addr
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHostAddr(strings.TrimSpace(hops[i]))
+		if !hop.IsValid() {
+			break
+		}
+		addr = hop
+		if !p.trusted(hop) {
+			break
+		}
+	}
+	return addr
+}
+
+// parseHostAddr parses an address given with or without a port
+func parseHostAddr(s string) netip.Addr {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap().WithZone("")
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.1 "})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"без прокси", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"недоверенный источник", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"доверенный прокси", "10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"цепочка прокси", "10.1.2.3:5000", []string{"198.51.100.1, 192.0.2.1"}, "198.51.100.1"},
+		{"подделка клиента", "10.1.2.3:5000", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"несколько заголовков", "10.1.2.3:5000", []string{"198.51.100.1", "10.9.9.9"}, "198.51.100.1"},
+		{"мусор в заголовке", "10.1.2.3:5000", []string{"unknown"}, "10.1.2.3"},
+		{"только прокси", "10.1.2.3:5000", []string{"10.4.4.4"}, "10.4.4.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/abc", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.want, proxies.ClientIP(r).String())
+		})
+	}
+
+	_, err = NewTrustedProxies([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/model"
+)
+
+// DefaultURLSchemes schemes a destination URL may have by default
+var DefaultURLSchemes = []string{"http", "https"}
+
+const (
+	defaultURLMaxLength       = 2048
+	defaultURLDomainsInterval = 30 * time.Second
+)
+
+// ErrUnsafeURL destination URL is refused by the policy
+var ErrUnsafeURL = errors.New("url is not allowed")
+
+// URLPolicy decides which destination URLs may be shortened
+type URLPolicy struct {
+	schemes map[string]bool
+	maxLen  int
+	ownHost string
+	file    *watchedFile
+
+	mu      sync.RWMutex
+	blocked []string
+	allowed []string
+}
+
+// NewURLPolicy creates URLPolicy from cfg; zero values fall back to the
+// defaults. The domain list file, if set, must be readable.
+func NewURLPolicy(cfg *config.Config) (*URLPolicy, error) {
+	schemes := cfg.URLSchemes
+	if len(schemes) == 0 {
+		schemes = DefaultURLSchemes
+	}
+	maxLen := cfg.URLMaxLength
+	if maxLen == 0 {
+		maxLen = defaultURLMaxLength
+	}
+	if maxLen < 0 {
+		return nil, fmt.Errorf("url max length %d is negative", maxLen)
+	}
+
+	p := &URLPolicy{
+		schemes: make(map[string]bool, len(schemes)),
+		maxLen:  maxLen,
+	}
+	p.file = newWatchedFile("url domain list", cfg.URLDomainsFile, p.load)
+	for _, s := range schemes {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			p.schemes[s] = true
+		}
+	}
+	if base, err := model.CanonicalURL(cfg.ServerAddr, false); err == nil && cfg.ServerAddr != "" {
+		if u, err := url.Parse(base); err == nil {
+			p.ownHost = hostName(u)
+		}
+	}
+	if cfg.URLDomainsFile != "" {
+		if err := p.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Validate returns ErrUnsafeURL, wrapped with the reason, for a URL that
+// must not be shortened. raw should be canonical, so hosts compare as
+// lower case punycode.
+func (p *URLPolicy) Validate(raw string) error {
+	if len(raw) > p.maxLen {
+		return fmt.Errorf("%w: longer than %d bytes", ErrUnsafeURL, p.maxLen)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsafeURL, err)
+	}
+	if !p.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("%w: scheme %q", ErrUnsafeURL, u.Scheme)
+	}
+	host := hostName(u)
+	if host == "" {
+		return fmt.Errorf("%w: no host", ErrUnsafeURL)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: local host %s", ErrUnsafeURL, host)
+	}
+	if ip := parseHostIP(host); ip != nil && (!ip.IsGlobalUnicast() || ip.IsPrivate()) {
+		return fmt.Errorf("%w: non-public address %s", ErrUnsafeURL, host)
+	}
+	if p.ownHost != "" && host == p.ownHost {
+		return fmt.Errorf("%w: links to this service would loop", ErrUnsafeURL)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if matchDomain(host, p.blocked) {
+		return fmt.Errorf("%w: domain %s is blocked", ErrUnsafeURL, host)
+	}
+	if len(p.allowed) > 0 && !matchDomain(host, p.allowed) {
+		return fmt.Errorf("%w: domain %s is not allowed", ErrUnsafeURL, host)
+	}
+	return nil
+}
+
+// hostName returns the host of u in lower case without the port and the
+// trailing dot of a fully qualified name
+func hostName(u *url.URL) string {
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// parseHostIP returns the address host names, or nil for a domain name.
+// Besides the usual forms it reads IPv4 addresses the way inet_aton and
+// browsers do: with one to four parts, each decimal, octal with a leading
+// 0 or hex with 0x, the last part filling the remaining bytes, so
+// 2130706433, 127.1 and 0x7f.0.0.1 are all 127.0.0.1.
+func parseHostIP(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	var addr uint64
+	for i, part := range parts {
+		n, ok := parseIPv4Part(part)
+		if !ok {
+			return nil
+		}
+		if i < len(parts)-1 {
+			if n > 0xff {
+				return nil
+			}
+			addr |= n << (8 * (3 - i))
+			continue
+		}
+		if n >= 1<<(8*(4-i)) {
+			return nil
+		}
+		addr |= n
+	}
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+// parseIPv4Part parses one part of an IPv4 address as inet_aton does
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case len(part) > 2 && (part[:2] == "0x" || part[:2] == "0X"):
+		base, part = 16, part[2:]
+	case len(part) > 1 && part[0] == '0':
+		base, part = 8, part[1:]
+	}
+	n, err := strconv.ParseUint(part, base, 32)
+	return n, err == nil
+}
+
+// matchDomain reports whether host is one of domains or their subdomain
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload rereads the domain list file if it changed since the last load.
+// The file has one "block <domain>" or "allow <domain>" per line; blank
+// lines and lines starting with # are skipped. Once any domain is allowed,
+// all others are refused.
+func (p *URLPolicy) Reload() error {
+	return p.file.reload()
+}
+
+// load puts the lists of the file at path in force
+func (p *URLPolicy) load(path string) error {
+	blocked, allowed, err := readDomainList(path)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blocked, p.allowed = blocked, allowed
+	return nil
+}
+
+func readDomainList(path string) (blocked []string, allowed []string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action, domain, ok := strings.Cut(line, " ")
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if !ok || domain == "" {
+			return nil, nil, fmt.Errorf("line %d: want \"block <domain>\" or \"allow <domain>\"", n)
+		}
+		switch action {
+		case "block":
+			blocked = append(blocked, domain)
+		case "allow":
+			allowed = append(allowed, domain)
+		default:
+			return nil, nil, fmt.Errorf("line %d: unknown action %q", n, action)
+		}
+	}
+	return blocked, allowed, scanner.Err()
+}
+
+// Watch reloads the domain list file every interval until ctx is done.
+// A file that fails to load keeps the previous lists in force.
+func (p *URLPolicy) Watch(ctx context.Context, interval time.Duration) {
+	p.file.watch(ctx, interval, defaultURLDomainsInterval)
+}
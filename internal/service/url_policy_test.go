@@ -0,0 +1,100 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+)
+
+func TestURLPolicyValidate(t *testing.T) {
+	domains := filepath.Join(t.TempDir(), "domains.txt")
+	require.NoError(t, os.WriteFile(domains, []byte("# тестовый список\nblock evil.example\n\n"), 0644))
+	p, err := NewURLPolicy(&config.Config{ServerAddr: "https://sho.rt/", URLMaxLength: 64, URLDomainsFile: domains})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		url  string
+		ok   bool
+	}{
+		{"обычный адрес", "https://example.com/", true},
+		{"публичный IP", "http://8.8.8.8/", true},
+		{"javascript", "javascript:alert(1)", false},
+		{"data", "data:text/html,<script>", false},
+		{"ftp", "ftp://example.com/", false},
+		{"без хоста", "https:///path", false},
+		{"слишком длинный", "https://example.com/" + strings.Repeat("a", 64), false},
+		{"loopback", "http://127.0.0.1/", false},
+		{"loopback IPv6", "http://[::1]/", false},
+		{"частная сеть", "http://10.1.2.3/", false},
+		{"link-local", "http://169.254.169.254/latest", false},
+		{"localhost", "http://localhost:8080/", false},
+		{"свой сервис", "https://sho.rt/abc", false},
+		{"свой хост на другом порту", "https://sho.rt:8443/abc", false},
+		{"свой хост с точкой и заглавными", "https://SHO.RT./abc", false},
+		{"localhost с точкой", "http://localhost./", false},
+		{"loopback одним числом", "http://2130706433/", false},
+		{"loopback из двух частей", "http://127.1/", false},
+		{"loopback в hex", "http://0x7f.0.0.1/", false},
+		{"loopback в octal", "http://017700000001/", false},
+		{"частная сеть в смешанной записи", "http://10.0x10.1/", false},
+		{"публичный IP одним числом", "http://134744072/", true},
+		{"домен из цифр и букв", "https://1e100.net/", true},
+		{"заблокированный домен", "https://evil.example/", false},
+		{"поддомен заблокированного", "https://www.evil.example/", false},
+		{"похожий домен", "https://notevil.example/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate(tt.url)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrUnsafeURL)
+			}
+		})
+	}
+}
+
+func TestURLPolicyReload(t *testing.T) {
+	domains := filepath.Join(t.TempDir(), "domains.txt")
+	require.NoError(t, os.WriteFile(domains, []byte("block evil.example\n"), 0644))
+	p, err := NewURLPolicy(&config.Config{URLDomainsFile: domains})
+	require.NoError(t, err)
+	assert.NoError(t, p.Validate("https://example.com/"))
+
+	// список разрешённых доменов закрывает все остальные
+	require.NoError(t, os.WriteFile(domains, []byte("allow example.com\n"), 0644))
+	require.NoError(t, os.Chtimes(domains, time.Now(), time.Now().Add(time.Second)))
+	require.NoError(t, p.Reload())
+	assert.NoError(t, p.Validate("https://evil.example.com/"), "subdomains of allowed domains pass")
+	assert.ErrorIs(t, p.Validate("https://go.dev/"), ErrUnsafeURL)
+
+	// файл, подменённый переименованием, читается заново, даже если время
+	// изменения осталось прежним
+	info, err := os.Stat(domains)
+	require.NoError(t, err)
+	prepared := filepath.Join(filepath.Dir(domains), "prepared.txt")
+	require.NoError(t, os.WriteFile(prepared, []byte("block evil.example\n"), 0644))
+	require.NoError(t, os.Chtimes(prepared, time.Now(), info.ModTime()))
+	require.NoError(t, os.Rename(prepared, domains))
+	require.NoError(t, p.Reload())
+	assert.NoError(t, p.Validate("https://go.dev/"))
+	assert.ErrorIs(t, p.Validate("https://evil.example/"), ErrUnsafeURL)
+
+	// испорченный файл не отменяет действующие списки
+	require.NoError(t, os.WriteFile(domains, []byte("deny go.dev\n"), 0644))
+	require.NoError(t, os.Chtimes(domains, time.Now(), time.Now().Add(2*time.Second)))
+	assert.Error(t, p.Reload())
+	assert.ErrorIs(t, p.Validate("https://evil.example/"), ErrUnsafeURL)
+
+	_, err = NewURLPolicy(&config.Config{URLDomainsFile: filepath.Join(t.TempDir(), "missing.txt")})
+	assert.Error(t, err)
+}
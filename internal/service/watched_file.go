@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"go.uber.org/zap"
+)
+
+// watchedFile is a file loaded again whenever it changes: it is replaced
+// by another file, or its modification time or size differ
+type watchedFile struct {
+	name string
+	path string
+	// load reads the file and puts what it holds in force; on error the
+	// previous contents stay in force
+	load func(path string) error
+
+	mu sync.Mutex
+	// loaded describes the file at the last successful load
+	loaded os.FileInfo
+}
+
+// newWatchedFile creates watchedFile for path; name starts its errors
+func newWatchedFile(name, path string, load func(path string) error) *watchedFile {
+	return &watchedFile{name: name, path: path, load: load}
+}
+
+// reload loads the file if it changed since the last successful load.
+// Concurrent reloads take turns, so the newest file always wins.
+func (f *watchedFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.name, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.loaded != nil && os.SameFile(f.loaded, info) &&
+		info.ModTime().Equal(f.loaded.ModTime()) && info.Size() == f.loaded.Size() {
+		return nil
+	}
+	if err := f.load(f.path); err != nil {
+		return fmt.Errorf("%s: %w", f.name, err)
+	}
+	f.loaded = info
+	return nil
+}
+
+// watch reloads the file every interval, or every fallback when interval
+// is not positive, until ctx is done. Failed reloads are logged.
+func (f *watchedFile) watch(ctx context.Context, interval, fallback time.Duration) {
+	if f.path == "" {
+		return
+	}
+	if interval <= 0 {
+		interval = fallback
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.reload(); err != nil {
+				logger.Log.Error(f.name+" reload failed", zap.Error(err))
+			}
+		}
+	}
+}
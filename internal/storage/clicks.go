@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Click is one redirect through a link
+// generate:reset
+type Click struct {
+	Code string
+	At   time.Time
+	// Referrer is the host of the referring page; empty for direct visits
+	Referrer  string
+	UserAgent string
+	// IP is the visitor address with the host part zeroed
+	IP string
+	// Bot marks crawlers, link previews and prefetches, which are kept
+	// apart from the clicks of people
+	Bot bool
+	// Country and Region are ISO 3166 codes of where the visitor address is
+	// registered, ASN the autonomous system it belongs to; empty if unknown
+	Country string
+	Region  string
+	ASN     int
+}
+
+// visitor identifies the visitor of c as well as anonymized data allows
+func (c Click) visitor() string {
+	return c.IP + "|" + c.UserAgent
+}
+
+// asnKey is the key of c in ClickStats.ASNs
+func (c Click) asnKey() string {
+	if c.ASN == 0 {
+		return ""
+	}
+	return strconv.Itoa(c.ASN)
+}
+
+// ClickStats summarizes the clicks of one link. All counts but Bots leave
+// bot clicks out.
+// generate:reset
+type ClickStats struct {
+	Total int
+	// Bots counts the clicks of bots
+	Bots int
+	// Unique estimates distinct visitors over the whole life of the link,
+	// UniqueWeek over the last seven UTC days, today included
+	Unique     int
+	UniqueWeek int
+	// Referrers are sorted by count, most frequent first, and so are
+	// Countries, Regions and ASNs. Unknown ones have an empty key.
+	Referrers []StatCount
+	Countries []StatCount
+	Regions   []StatCount
+	// ASNs are keyed by the decimal number of the autonomous system
+	ASNs []StatCount
+	// Days are keyed by UTC date, oldest first, and so are UniqueDays,
+	// which estimate distinct visitors per day
+	Days       []StatCount
+	UniqueDays []StatCount
+}
+
+// Breakdowns of ClickStats
+const (
+	statReferrer = "referrer"
+	statCountry  = "country"
+	statRegion   = "region"
+	statASN      = "asn"
+	statDay      = "day"
+)
+
+// StatCount is the number of clicks that share Key
+// generate:reset
+type StatCount struct {
+	Key   string
+	Count int
+}
+
+// dayLayout formats the keys of ClickStats.Days
+const dayLayout = "2006-01-02"
+
+// Rollup intervals: clicks are counted in hourly and daily buckets
+const (
+	IntervalHour = "hour"
+	IntervalDay  = "day"
+)
+
+// rollupIntervals are the intervals every click is counted in
+var rollupIntervals = []string{IntervalHour, IntervalDay}
+
+// BucketStart returns the start, in UTC, of the interval bucket that holds t
+func BucketStart(interval string, t time.Time) time.Time {
+	t = t.UTC()
+	if interval == IntervalDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// SeriesPoint is the number of clicks in the bucket that starts at At
+// generate:reset
+type SeriesPoint struct {
+	At     time.Time
+	Clicks int
+	Bots   int
+}
+
+// rollup is the number of clicks of one link in one bucket
+// generate:reset
+type rollup struct {
+	Code     string
+	Interval string
+	Start    time.Time
+	Clicks   int
+	Bots     int
+}
+
+// add counts c in r
+func (r *rollup) add(c Click) {
+	if c.Bot {
+		r.Bots++
+	} else {
+		r.Clicks++
+	}
+}
+
+// rollupClicks counts clicks per link and bucket of every interval. Rollups
+// are ordered by code, interval and start, so concurrent writers update
+// them in the same order.
+func rollupClicks(clicks []Click) []rollup {
+	type key struct {
+		code, interval string
+		start          int64
+	}
+	counts := make(map[key]*rollup)
+	for _, c := range clicks {
+		for _, interval := range rollupIntervals {
+			start := BucketStart(interval, c.At)
+			k := key{c.Code, interval, start.Unix()}
+			if counts[k] == nil {
+				counts[k] = &rollup{Code: c.Code, Interval: interval, Start: start}
+			}
+			counts[k].add(c)
+		}
+	}
+
+	rollups := make([]rollup, 0, len(counts))
+	for _, r := range counts {
+		rollups = append(rollups, *r)
+	}
+	slices.SortFunc(rollups, func(a, b rollup) int {
+		return cmp.Or(
+			cmp.Compare(a.Code, b.Code),
+			cmp.Compare(a.Interval, b.Interval),
+			a.Start.Compare(b.Start),
+		)
+	})
+	return rollups
+}
+
+// ClickStorage defines methods for click analytics. Every saved click is
+// also counted in hourly and daily rollups and its visitor in a daily
+// sketch; daily rollups and sketches outlive the raw clicks.
+type ClickStorage interface {
+	// SaveClicks records clicks; clicks of links that no longer exist
+	// are dropped
+	SaveClicks(ctx context.Context, clicks []Click) error
+	// URLStats summarizes the clicks of the user's link. Totals, days and
+	// unique visitors cover the whole life of the link; the other breakdowns
+	// only the raw clicks that are not downsampled yet. Links of other users
+	// are not found.
+	URLStats(ctx context.Context, userID int, code string) (ClickStats, error)
+	// ClickSeries returns the non-empty interval buckets of the user's link,
+	// or of all the user's links when code is empty, that start within
+	// [from, to), oldest first. A code of another user is not found.
+	ClickSeries(ctx context.Context, userID int, code string, interval string, from, to time.Time) ([]SeriesPoint, error)
+	// DownsampleClicks removes raw clicks made before before and hourly
+	// buckets that end by then, keeping daily buckets and sketches, and
+	// returns how many raw clicks were removed
+	DownsampleClicks(ctx context.Context, before time.Time) (int, error)
+}
+
+// summarizeClicks computes ClickStats for backends that keep clicks in
+// memory, from the retained raw clicks, the daily rollups and the daily
+// sketches of the link
+func summarizeClicks(clicks []Click, daily []rollup, sketches []daySketch) ClickStats {
+	breakdowns := map[string]map[string]int{
+		statReferrer: {},
+		statCountry:  {},
+		statRegion:   {},
+		statASN:      {},
+		statDay:      {},
+	}
+	for _, c := range clicks {
+		if c.Bot {
+			continue
+		}
+		breakdowns[statReferrer][c.Referrer]++
+		breakdowns[statCountry][c.Country]++
+		breakdowns[statRegion][c.Region]++
+		breakdowns[statASN][c.asnKey()]++
+	}
+	total, bots := 0, 0
+	for _, r := range daily {
+		total += r.Clicks
+		bots += r.Bots
+		if r.Clicks > 0 {
+			breakdowns[statDay][r.Start.Format(dayLayout)] = r.Clicks
+		}
+	}
+	stats := newClickStats(total, bots, breakdowns)
+	stats.setUniques(sketches, time.Now())
+	return stats
+}
+
+// newClickStats orders the breakdowns, keyed by kind, the way ClickStats
+// promises
+func newClickStats(total, bots int, breakdowns map[string]map[string]int) ClickStats {
+	return ClickStats{
+		Total:     total,
+		Bots:      bots,
+		Referrers: statCountsByCount(breakdowns[statReferrer]),
+		Countries: statCountsByCount(breakdowns[statCountry]),
+		Regions:   statCountsByCount(breakdowns[statRegion]),
+		ASNs:      statCountsByCount(breakdowns[statASN]),
+		Days:      statCounts(breakdowns[statDay]),
+	}
+}
+
+// statCountsByCount returns counts sorted by count, most frequent first,
+// then by key
+func statCountsByCount(counts map[string]int) []StatCount {
+	result := statCounts(counts)
+	slices.SortStableFunc(result, func(a, b StatCount) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	return result
+}
+
+// statCounts returns counts sorted by key
+func statCounts(counts map[string]int) []StatCount {
+	result := make([]StatCount, 0, len(counts))
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		result = append(result, StatCount{Key: key, Count: counts[key]})
+	}
+	return result
+}
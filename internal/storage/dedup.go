@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Deduplication scopes: which links may not share an original URL
+const (
+	// DedupGlobal allows every URL to be shortened only once
+	DedupGlobal = "global"
+	// DedupPerUser allows every user to shorten a URL once
+	DedupPerUser = "user"
+	// DedupNone allows a URL to be shortened any number of times
+	DedupNone = "none"
+)
+
+// parseDedupScope validates scope; empty means DedupGlobal
+func parseDedupScope(scope string) (string, error) {
+	switch scope {
+	case "":
+		return DedupGlobal, nil
+	case DedupGlobal, DedupPerUser, DedupNone:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown dedup scope %q", scope)
+	}
+}
+
+// dedupKey returns the value two links must not share under scope.
+// ok is false when the link takes part in no deduplication.
+func dedupKey(scope string, userID int, url string) (key string, ok bool) {
+	switch scope {
+	case DedupPerUser:
+		return strconv.Itoa(userID) + ":" + url, true
+	case DedupNone:
+		return "", false
+	default:
+		return url, true
+	}
+}
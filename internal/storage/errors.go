@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+)
+
+// Errors returned by every backend. They are permanent: repeating the same
+// request gives the same result.
+var (
+	// ErrURLAlreadyExists url is already saved in DB
+	ErrURLAlreadyExists = errors.New("url already exists")
+	// ErrCodeAlreadyExists code is already taken
+	ErrCodeAlreadyExists = errors.New("url already taken")
+	// ErrURLNotFound no URL with such code or value
+	ErrURLNotFound = errors.New("url not found")
+	// ErrURLDeleted code is already deleted
+	ErrURLDeleted = errors.New("url has deleted")
+	// ErrURLExpired the link has outlived its expiry
+	ErrURLExpired = errors.New("url has expired")
+	// ErrURLExhausted the link has used up its clicks
+	ErrURLExhausted = errors.New("url has no clicks left")
+	// ErrUserNotFound no user with such ID
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidLeaseSize a lease must cover at least one ID
+	ErrInvalidLeaseSize = errors.New("lease size must be positive")
+	// ErrNotImplemented not implemented
+	ErrNotImplemented = errors.New("not implemented")
+)
+
+// ErrUnavailable matches every TransientError: the storage cannot serve the
+// request right now, but a retry may succeed
+var ErrUnavailable = errors.New("storage unavailable")
+
+// TransientError wraps a failure that may go away on retry:
+// a lost connection, a timeout, a busy or overloaded database
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return "storage unavailable: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrUnavailable) true for any TransientError
+func (e *TransientError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// IsTransient reports whether err may go away on retry
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// transient wraps err in TransientError
+func transient(err error) error {
+	if err == nil || IsTransient(err) {
+		return err
+	}
+	return &TransientError{Err: err}
+}
+
+// isConnectionError reports failures of the connection itself,
+// common to all network and SQL backends
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
@@ -1,16 +1,101 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
 )
 
-// NewStorage выбирает и возвращает реализацию интерфейса Storage
-func NewStorage(cfg *config.Config) (Storage, error) {
-	if cfg.DatabaseDsn != "" {
-		return NewPostgresStorage(cfg), nil
+// ErrUnknownStorage no backend is registered for the DSN scheme
+var ErrUnknownStorage = errors.New("unknown storage scheme")
+
+// Factory creates a backend for dsn. dsn is passed as configured,
+// including the scheme; DSNPath strips it for path-based backends.
+type Factory func(dsn string, cfg *config.Config) (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available under a DSN scheme, e.g. "memory" for
+// memory://. Backends register themselves from init, so a third-party one
+// only needs to be imported. Like sql.Register it panics if factory is nil
+// or the scheme is already taken.
+func Register(scheme string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	scheme = strings.ToLower(scheme)
+	if _, dup := factories[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns the sorted list of registered schemes
+func Schemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
 	}
-	if cfg.DataFilePath != "" {
-		return NewMemoryStorage(cfg, true), nil
+	slices.Sort(schemes)
+	return schemes
+}
+
+// Open creates the backend registered for the scheme of dsn
+func Open(dsn string, cfg *config.Config) (Storage, error) {
+	scheme, _, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q has no scheme", ErrUnknownStorage, dsn)
+	}
+	return openScheme(scheme, dsn, cfg)
+}
+
+func openScheme(scheme string, dsn string, cfg *config.Config) (Storage, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[strings.ToLower(scheme)]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (registered: %s)", ErrUnknownStorage, scheme, strings.Join(Schemes(), ", "))
+	}
+	return factory(dsn, cfg)
+}
+
+// DSNPath returns the part of dsn after the scheme separator:
+// "/data/urls.json" for file:///data/urls.json. A dsn without
+// a scheme is returned unchanged.
+func DSNPath(dsn string) string {
+	if _, rest, ok := strings.Cut(dsn, "://"); ok {
+		return rest
+	}
+	return dsn
+}
+
+// NewStorage opens the backend selected by cfg.StorageDSN. Without it the
+// older settings are honoured in their original priority: DatabaseDsn,
+// SQLitePath, DataFilePath and plain memory as the last resort.
+func NewStorage(cfg *config.Config) (Storage, error) {
+	switch {
+	case cfg.StorageDSN != "":
+		return Open(cfg.StorageDSN, cfg)
+	case cfg.DatabaseDsn != "":
+		return openScheme("postgres", cfg.DatabaseDsn, cfg)
+	case cfg.SQLitePath != "":
+		return openScheme("sqlite", cfg.SQLitePath, cfg)
+	case cfg.DataFilePath != "":
+		return openScheme("file", cfg.DataFilePath, cfg)
+	default:
+		return openScheme("memory", "", cfg)
 	}
-	return NewMemoryStorage(cfg, false), nil
 }
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultFileSyncInterval    = time.Second
+	defaultFileCompactInterval = 5 * time.Minute
+)
+
+// FileStorage keeps data in memory and makes it durable with an append-only
+// write-ahead log. Every change is written to the log before it becomes
+// visible; the log is periodically folded into a snapshot and truncated.
+type FileStorage struct {
+	*MemoryStorage
+
+	// mu is held for reading by every change and for writing while
+	// compacting, so no change falls between the snapshot and the truncation
+	mu           sync.RWMutex
+	log          *walWriter
+	snapshotPath string
+
+	syncInterval    time.Duration
+	compactInterval time.Duration
+	doneCh          chan struct{}
+	wg              sync.WaitGroup
+}
+
+func init() {
+	Register("file", func(dsn string, cfg *config.Config) (Storage, error) {
+		return NewFileStorage(DSNPath(dsn), cfg)
+	})
+}
+
+// NewFileStorage creates FileStorage with the snapshot at path,
+// restoring it and replaying the log left by the previous run
+func NewFileStorage(path string, cfg *config.Config) (*FileStorage, error) {
+	policy := cfg.FileSyncPolicy
+	if policy == "" {
+		policy = SyncInterval
+	}
+
+	mem, err := NewMemoryStorageWithScope(cfg.DedupScope)
+	if err != nil {
+		return nil, err
+	}
+	if err := LoadData(path, mem); err != nil {
+		return nil, fmt.Errorf("load data: %w", err)
+	}
+
+	log, err := openWAL(walPath(path), policy)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileStorage{
+		MemoryStorage:   mem,
+		log:             log,
+		snapshotPath:    path,
+		syncInterval:    durationOr(cfg.FileSyncInterval, defaultFileSyncInterval),
+		compactInterval: durationOr(cfg.FileCompactInterval, defaultFileCompactInterval),
+		doneCh:          make(chan struct{}),
+	}
+
+	store.wg.Add(1)
+	go store.background()
+
+	return store, nil
+}
+
+// durationOr converts seconds to a duration, using def for non-positive values
+func durationOr(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// background syncs the log according to the sync policy and compacts it
+func (f *FileStorage) background() {
+	defer f.wg.Done()
+
+	syncTicker := time.NewTicker(f.syncInterval)
+	defer syncTicker.Stop()
+	compactTicker := time.NewTicker(f.compactInterval)
+	defer compactTicker.Stop()
+
+	for {
+		select {
+		case <-f.doneCh:
+			return
+		case <-syncTicker.C:
+			if f.log.policy != SyncInterval {
+				continue
+			}
+			if err := f.log.sync(); err != nil {
+				logger.Log.Error("wal sync error", zap.Error(err))
+			}
+		case <-compactTicker.C:
+			if err := f.Compact(); err != nil {
+				logger.Log.Error("wal compaction error", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Compact writes a snapshot of the current state and truncates the log
+func (f *FileStorage) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := SaveData(f.snapshotPath, f.MemoryStorage); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return f.log.truncate()
+}
+
+// SaveURL logs and saves a URL
+func (f *FileStorage) SaveURL(ctx context.Context, u URL) error {
+	return f.SaveBatchURL(ctx, []URL{u})
+}
+
+// SaveBatchURL logs and saves list of URL as a single record
+func (f *FileStorage) SaveBatchURL(ctx context.Context, urls []URL) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.saveURLs(urls, func() error {
+		rec := walRecord{Op: walOpCreate, URLs: make([]walURL, 0, len(urls))}
+		for _, u := range urls {
+			rec.URLs = append(rec.URLs, newWALURL(u))
+		}
+		return f.log.append(rec)
+	})
+}
+
+// FollowURL logs a spent click of a link with MaxClicks, so a restart
+// does not give the click back
+func (f *FileStorage) FollowURL(ctx context.Context, code string) (URL, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.followURL(code, func() error {
+		return f.log.append(walRecord{Op: walOpClick, Codes: []string{code}})
+	})
+}
+
+// UpdateURL logs and applies an edit of the user's link as one record
+func (f *FileStorage) UpdateURL(ctx context.Context, userID int, code string, edit URLEdit) (URL, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.updateURL(userID, code, edit, func(edit URLEdit, prev *URLVersion) error {
+		rec := walRecord{
+			Op:           walOpUpdate,
+			UserID:       userID,
+			URLs:         []walURL{{Code: code, URL: edit.URL, Input: edit.Input}},
+			RedirectType: edit.RedirectType,
+		}
+		if prev != nil {
+			rec.Versions = []walVersion{walVersion(*prev)}
+		}
+		return f.log.append(rec)
+	})
+}
+
+// SaveClicks logs and records clicks
+func (f *FileStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.saveClicks(clicks, func() error {
+		return f.log.append(walRecord{Op: walOpVisits, Clicks: newWALClicks(clicks)})
+	})
+}
+
+// DownsampleClicks logs and removes raw clicks and hourly rollups older
+// than before
+func (f *FileStorage) DownsampleClicks(ctx context.Context, before time.Time) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.downsampleClicks(before, func() error {
+		return f.log.append(walRecord{Op: walOpDownsample, At: &before})
+	})
+}
+
+// SaveTrending logs and adds trending counts
+func (f *FileStorage) SaveTrending(ctx context.Context, counts []TrendingCount, expired time.Time) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.saveTrending(counts, expired, func() error {
+		return f.log.append(walRecord{Op: walOpTrending, Trending: newWALTrending(counts), At: &expired})
+	})
+}
+
+// CreateUser logs and creates a new user
+func (f *FileStorage) CreateUser(ctx context.Context) (User, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.createUser(func(u User) error {
+		return f.log.append(walRecord{Op: walOpUser, UserID: u.ID})
+	})
+}
+
+// LeaseIDs logs and reserves size IDs, so a restart never leases them again
+func (f *FileStorage) LeaseIDs(ctx context.Context, size int) (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.leaseIDs(size, func(next int64) error {
+		return f.log.append(walRecord{Op: walOpLease, NextID: next})
+	})
+}
+
+// DeleteUserURLs logs and marks user's URLs as deleted
+func (f *FileStorage) DeleteUserURLs(ctx context.Context, userID int, codes []string) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	at := time.Now()
+	if err := f.log.append(walRecord{Op: walOpDelete, UserID: userID, Codes: codes, At: &at}); err != nil {
+		return err
+	}
+	f.MemoryStorage.markDeleted(userID, codes, at)
+	return nil
+}
+
+// RestoreUserURLs logs and undeletes the user's links deleted after since
+func (f *FileStorage) RestoreUserURLs(ctx context.Context, userID int, codes []string, since time.Time) ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.restoreUserURLs(userID, codes, since, func(restored []string) error {
+		return f.log.append(walRecord{Op: walOpRestore, UserID: userID, Codes: restored})
+	})
+}
+
+// PurgeDeletedURLs logs and removes links deleted before before
+func (f *FileStorage) PurgeDeletedURLs(ctx context.Context, before time.Time) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.MemoryStorage.purgeURLs(func(u URL) bool {
+		return u.deletedBefore(before)
+	}, func(codes []string) error {
+		return f.log.append(walRecord{Op: walOpPurge, Codes: codes})
+	})
+}
+
+// Close stops background work, compacts the log and closes it
+func (f *FileStorage) Close() error {
+	close(f.doneCh)
+	f.wg.Wait()
+
+	if err := f.Compact(); err != nil {
+		logger.Log.Error("wal compaction error", zap.Error(err))
+	}
+	return f.log.close()
+}
@@ -2,66 +2,289 @@ package storage
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"hash/maphash"
+	"iter"
 	"maps"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
 )
 
-// MemoryStorage is an in-memory implementation of the Storage interface
-// generate:reset
+// memoryShardCount is the number of independently locked URL shards
+const memoryShardCount = 16
+
+// maxRawClicks caps the raw clicks kept per link between downsamplings, so
+// a popular link cannot grow memory without bound. Rollups and sketches
+// still count every click; the referrer, country, region and ASN
+// breakdowns of URLStats cover the newest maxRawClicks.
+const maxRawClicks = 10_000
+
+// memoryShard holds a subset of URLs keyed by code, with their previous
+// targets, clicks, click rollups, daily visitor sketches and trending
+// counters
+type memoryShard struct {
+	mu      sync.RWMutex
+	urls    map[string]URL
+	history map[string][]URLVersion
+	clicks  map[string][]Click
+	rollups map[string]map[bucketKey]rollup
+	// sketches are keyed by code, then by the Unix time the day starts at
+	sketches map[string]map[int64]*sketch
+	// trending counters are keyed by code, then by the Unix time the
+	// minute starts at
+	trending map[string]map[int64]int
+}
+
+// bucketKey identifies a rollup bucket of one link
+type bucketKey struct {
+	interval string
+	// start is the Unix time the bucket starts at
+	start int64
+}
+
+// MemoryStorage is an in-memory implementation of the Storage interface.
+// URLs are spread over shards by code, so redirects only contend on a single
+// shard. Writers that add URLs also hold indexMu, which guards the reverse
+// dedup key→code index and keeps the set of codes stable while it is held.
 type MemoryStorage struct {
-	Urls         map[string]URL
-	Users        map[int]User
-	UseFile      bool
-	DataFilePath string
+	seed    maphash.Seed
+	shards  []*memoryShard
+	dedup   string
+	indexMu sync.RWMutex
+	byURL   map[string]string
+
+	usersMu    sync.RWMutex
+	users      map[int]User
+	lastUserID int
+
+	leaseMu sync.Mutex
+	nextID  int64
 }
 
-// NewMemoryStorage creates new MemoryStorage
-func NewMemoryStorage(cfg *config.Config, useFile bool) *MemoryStorage {
+func init() {
+	Register("memory", func(_ string, cfg *config.Config) (Storage, error) {
+		return NewMemoryStorageWithScope(cfg.DedupScope)
+	})
+}
+
+// NewMemoryStorage creates new MemoryStorage that deduplicates URLs globally
+func NewMemoryStorage() *MemoryStorage {
+	return newMemoryStorage(DedupGlobal)
+}
+
+// NewMemoryStorageWithScope creates new MemoryStorage that deduplicates URLs
+// within scope
+func NewMemoryStorageWithScope(scope string) (*MemoryStorage, error) {
+	scope, err := parseDedupScope(scope)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryStorage(scope), nil
+}
+
+func newMemoryStorage(scope string) *MemoryStorage {
 	store := &MemoryStorage{
-		Urls:         make(map[string]URL),
-		Users:        make(map[int]User),
-		UseFile:      useFile,
-		DataFilePath: cfg.DataFilePath,
+		seed:   maphash.MakeSeed(),
+		shards: make([]*memoryShard, memoryShardCount),
+		dedup:  scope,
+		byURL:  make(map[string]string),
+		users:  make(map[int]User),
+		nextID: 1,
 	}
-	if useFile {
-		LoadData(cfg.DataFilePath, store)
+	for i := range store.shards {
+		store.shards[i] = &memoryShard{
+			urls:     make(map[string]URL),
+			history:  make(map[string][]URLVersion),
+			clicks:   make(map[string][]Click),
+			rollups:  make(map[string]map[bucketKey]rollup),
+			sketches: make(map[string]map[int64]*sketch),
+			trending: make(map[string]map[int64]int),
+		}
 	}
 	return store
 }
 
+func (m *MemoryStorage) shard(code string) *memoryShard {
+	return m.shards[maphash.String(m.seed, code)%memoryShardCount]
+}
+
 // SaveURL save a URL by  code in memory
 func (m *MemoryStorage) SaveURL(ctx context.Context, u URL) error {
-	m.Urls[u.Code] = u
+	return m.saveURLs([]URL{u}, nil)
+}
+
+// saveURLs validates urls as a whole and inserts all of them or none.
+// commit, when set, runs after validation and before insertion; if it fails
+// nothing is inserted. It lets wrapping storages persist the change first.
+func (m *MemoryStorage) saveURLs(urls []URL, commit func() error) error {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	now := time.Now()
+	codes := make(map[string]struct{}, len(urls))
+	keys := make(map[string]struct{}, len(urls))
+	for _, u := range urls {
+		key, dedup := dedupKey(m.dedup, u.UserID, u.URL)
+		// dead links are only replayed, and never hold their key
+		dedup = dedup && !u.dead(now)
+		if _, ok := keys[key]; ok && dedup {
+			return ErrURLAlreadyExists
+		}
+		if _, ok := codes[u.Code]; ok {
+			return ErrCodeAlreadyExists
+		}
+		if err := m.checkUnique(u, now); err != nil {
+			return err
+		}
+		codes[u.Code] = struct{}{}
+		if dedup {
+			keys[key] = struct{}{}
+		}
+	}
+	if commit != nil {
+		if err := commit(); err != nil {
+			return err
+		}
+	}
+	for _, u := range urls {
+		m.insert(u, now)
+	}
 	return nil
 }
 
+// checkUnique reports whether u would violate code or URL uniqueness.
+// The caller must hold indexMu.
+func (m *MemoryStorage) checkUnique(u URL, now time.Time) error {
+	if key, ok := dedupKey(m.dedup, u.UserID, u.URL); ok && !u.dead(now) && m.holdsKey(key, now, nil) {
+		return ErrURLAlreadyExists
+	}
+	s := m.shard(u.Code)
+	s.mu.RLock()
+	_, ok := s.urls[u.Code]
+	s.mu.RUnlock()
+	if ok {
+		return ErrCodeAlreadyExists
+	}
+	return nil
+}
+
+// holdsKey reports whether a link that is not dead holds the dedup key.
+// The caller must hold indexMu and, if locked is set, the lock of that shard.
+func (m *MemoryStorage) holdsKey(key string, now time.Time, locked *memoryShard) bool {
+	code, ok := m.byURL[key]
+	if !ok {
+		return false
+	}
+	s := m.shard(code)
+	if s != locked {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	u, ok := s.urls[code]
+	return ok && !u.dead(now)
+}
+
+// insert stores u in its shard and in the reverse index, where a dead link
+// does not replace a live one. The caller must hold indexMu.
+func (m *MemoryStorage) insert(u URL, now time.Time) {
+	s := m.shard(u.Code)
+	s.mu.Lock()
+	s.urls[u.Code] = u
+	s.mu.Unlock()
+	if key, ok := dedupKey(m.dedup, u.UserID, u.URL); ok {
+		if _, held := m.byURL[key]; !held || !u.dead(now) {
+			m.byURL[key] = u.Code
+		}
+	}
+}
+
+// releaseKey drops the dedup key of u unless another link took it over.
+// The caller must hold indexMu.
+func (m *MemoryStorage) releaseKey(u URL) {
+	if key, ok := dedupKey(m.dedup, u.UserID, u.URL); ok && m.byURL[key] == u.Code {
+		delete(m.byURL, key)
+	}
+}
+
 // GetURL get URL by code from memory
 func (m *MemoryStorage) GetURL(ctx context.Context, code string) (URL, error) {
-	u, ok := m.Urls[code]
+	s := m.shard(code)
+	s.mu.RLock()
+	u, ok := s.urls[code]
+	s.mu.RUnlock()
 	if !ok {
-		return URL{}, errors.New("url not found")
+		return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	if err := u.check(time.Now()); err != nil {
+		return URL{}, err
 	}
 	return u, nil
 }
 
-// GetByURL get URL by url from memory
-func (m *MemoryStorage) GetByURL(ctx context.Context, url string) (URL, error) {
-	for _, v := range m.Urls {
-		if v.URL == url {
-			return v, nil
+// FollowURL get URL by code and spend one of its clicks
+func (m *MemoryStorage) FollowURL(ctx context.Context, code string) (URL, error) {
+	return m.followURL(code, nil)
+}
+
+// followURL spends a click under the shard lock, so concurrent redirects
+// cannot both take the last one. Unlimited links are only read. commit,
+// when set, runs before the click is counted; if it fails nothing changes.
+func (m *MemoryStorage) followURL(code string, commit func() error) (URL, error) {
+	u, err := m.GetURL(context.TODO(), code)
+	if err != nil || u.MaxClicks == 0 {
+		return u, err
+	}
+
+	s := m.shard(code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the link may have changed since it was read
+	u = s.urls[code]
+	if err := u.check(time.Now()); err != nil {
+		return URL{}, err
+	}
+	if commit != nil {
+		if err := commit(); err != nil {
+			return URL{}, err
 		}
 	}
-	return URL{}, errors.New("url not found")
+	u.Clicks++
+	s.urls[code] = u
+	return u, nil
 }
 
-// Close releases resources
-func (m *MemoryStorage) Close() error {
-	if m.UseFile {
-		SaveData(m.DataFilePath, m)
+// restoreClick replays a click spent before a restart
+func (m *MemoryStorage) restoreClick(code string) {
+	s := m.shard(code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.urls[code]; ok {
+		u.Clicks++
+		s.urls[code] = u
 	}
+}
+
+// GetByURL get the link that keeps the user from shortening url again
+func (m *MemoryStorage) GetByURL(ctx context.Context, userID int, url string) (URL, error) {
+	key, ok := dedupKey(m.dedup, userID, url)
+	if !ok {
+		return URL{}, fmt.Errorf("url with url %s: %w", url, ErrURLNotFound)
+	}
+	m.indexMu.RLock()
+	code, ok := m.byURL[key]
+	m.indexMu.RUnlock()
+	if !ok {
+		return URL{}, fmt.Errorf("url with url %s: %w", url, ErrURLNotFound)
+	}
+	return m.GetURL(ctx, code)
+}
+
+// Close do nothing
+func (m *MemoryStorage) Close() error {
 	return nil
 }
 
@@ -70,44 +293,760 @@ func (m *MemoryStorage) Ping(ctx context.Context) error {
 	return nil
 }
 
-// AllURLs returns all URLs
-func (m *MemoryStorage) AllURLs(ctx context.Context) ([]URL, error) {
-	return slices.Collect(maps.Values(m.Urls)), nil
+// AllURLs returns an iterator over all URLs, including deleted ones.
+// Each shard is copied under its lock, so yield may call back into the storage.
+func (m *MemoryStorage) AllURLs(ctx context.Context) iter.Seq2[URL, error] {
+	return func(yield func(URL, error) bool) {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			urls := slices.Collect(maps.Values(s.urls))
+			s.mu.RUnlock()
+			for _, u := range urls {
+				if !yield(u, nil) {
+					return
+				}
+			}
+		}
+	}
 }
 
-// SaveBatchURL save list of URL
+// SaveBatchURL saves list of URL atomically: if any of them conflicts,
+// none are saved
 func (m *MemoryStorage) SaveBatchURL(ctx context.Context, urls []URL) error {
-	for _, url := range urls {
-		m.SaveURL(ctx, url)
-	}
-	return nil
+	return m.saveURLs(urls, nil)
 }
 
 // CreateUser creates a new user and returns it
 func (m *MemoryStorage) CreateUser(ctx context.Context) (User, error) {
-	newID := len(m.Users) + 1
-	newUser := User{ID: newID}
-	m.Users[newID] = newUser
+	return m.createUser(nil)
+}
+
+// createUser allocates the next user ID. commit, when set, runs before the
+// user is stored; if it fails the ID is released again.
+func (m *MemoryStorage) createUser(commit func(User) error) (User, error) {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	newUser := User{ID: m.lastUserID + 1}
+	if commit != nil {
+		if err := commit(newUser); err != nil {
+			return User{}, err
+		}
+	}
+	m.lastUserID = newUser.ID
+	m.users[newUser.ID] = newUser
 	return newUser, nil
 }
 
+// GetUser returns the user with id
+func (m *MemoryStorage) GetUser(ctx context.Context, id int) (User, error) {
+	m.usersMu.RLock()
+	defer m.usersMu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return User{}, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+	return user, nil
+}
+
+// restoreUser adds a previously persisted user, keeping IDs monotonic
+func (m *MemoryStorage) restoreUser(user User) {
+	m.usersMu.Lock()
+	defer m.usersMu.Unlock()
+
+	m.users[user.ID] = user
+	m.lastUserID = max(m.lastUserID, user.ID)
+}
+
+// LeaseIDs reserves size IDs and returns the first one
+func (m *MemoryStorage) LeaseIDs(ctx context.Context, size int) (int64, error) {
+	return m.leaseIDs(size, nil)
+}
+
+// leaseIDs reserves size IDs. commit, when set, gets the first ID that stays
+// free and runs before the lease is granted; if it fails nothing is reserved.
+func (m *MemoryStorage) leaseIDs(size int, commit func(next int64) error) (int64, error) {
+	if size <= 0 {
+		return 0, ErrInvalidLeaseSize
+	}
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+
+	start := m.nextID
+	if commit != nil {
+		if err := commit(start + int64(size)); err != nil {
+			return 0, err
+		}
+	}
+	m.nextID = start + int64(size)
+	return start, nil
+}
+
+// leasedUpTo returns the first ID not leased yet
+func (m *MemoryStorage) leasedUpTo() int64 {
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+	return m.nextID
+}
+
+// restoreLease marks all IDs below next as leased
+func (m *MemoryStorage) restoreLease(next int64) {
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+	m.nextID = max(m.nextID, next)
+}
+
 // GetURLsByUserID returns all URLs associated with a specific user ID
 func (m *MemoryStorage) GetURLsByUserID(ctx context.Context, userID int) ([]URL, error) {
 	var result []URL
-	for _, url := range m.Urls {
-		if url.UserID == userID {
-			result = append(result, url)
+	for _, s := range m.shards {
+		s.mu.RLock()
+		for _, url := range s.urls {
+			if url.UserID == userID && !url.isDeleted {
+				result = append(result, url)
+			}
 		}
+		s.mu.RUnlock()
 	}
 	return result, nil
 }
 
-// GetAllUsers returns all users
-func (m *MemoryStorage) GetAllUsers(ctx context.Context) ([]User, error) {
-	return slices.Collect(maps.Values(m.Users)), nil
+// GetAllUsers returns an iterator over all users
+func (m *MemoryStorage) GetAllUsers(ctx context.Context) iter.Seq2[User, error] {
+	return func(yield func(User, error) bool) {
+		m.usersMu.RLock()
+		users := slices.Collect(maps.Values(m.users))
+		m.usersMu.RUnlock()
+		for _, u := range users {
+			if !yield(u, nil) {
+				return
+			}
+		}
+	}
 }
 
-// DeleteUserURLs delete user's urls
+// DeleteUserURLs marks user's URLs as deleted.
+// Security: ensures only URLs belonging to the given userID are affected.
 func (m *MemoryStorage) DeleteUserURLs(ctx context.Context, userID int, codes []string) error {
-	return ErrNotImplemented
+	m.markDeleted(userID, codes, time.Now())
+	return nil
+}
+
+// markDeleted marks user's URLs as deleted at the moment at.
+// Links that are already deleted keep their deletion time.
+func (m *MemoryStorage) markDeleted(userID int, codes []string, at time.Time) {
+	for _, code := range codes {
+		s := m.shard(code)
+		s.mu.Lock()
+		if u, ok := s.urls[code]; ok && u.UserID == userID && !u.isDeleted {
+			u.isDeleted = true
+			u.deletedAt = at
+			s.urls[code] = u
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RestoreUserURLs undeletes the user's links deleted after since
+func (m *MemoryStorage) RestoreUserURLs(ctx context.Context, userID int, codes []string, since time.Time) ([]string, error) {
+	return m.restoreUserURLs(userID, codes, since, nil)
+}
+
+// restoreUserURLs undeletes the user's links deleted after since. indexMu
+// keeps a purge from removing them in between. commit, when set, gets the
+// codes about to be restored; if it fails nothing is restored.
+func (m *MemoryStorage) restoreUserURLs(userID int, codes []string, since time.Time, commit func(restored []string) error) ([]string, error) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	var restored []string
+	for _, code := range codes {
+		s := m.shard(code)
+		s.mu.RLock()
+		u, ok := s.urls[code]
+		s.mu.RUnlock()
+		if ok && u.UserID == userID && u.isDeleted && u.deletedAt.After(since) && !slices.Contains(restored, code) {
+			restored = append(restored, code)
+		}
+	}
+	if len(restored) == 0 {
+		return nil, nil
+	}
+	if commit != nil {
+		if err := commit(restored); err != nil {
+			return nil, err
+		}
+	}
+	for _, code := range restored {
+		s := m.shard(code)
+		s.mu.Lock()
+		u := s.urls[code]
+		u.isDeleted = false
+		u.deletedAt = time.Time{}
+		s.urls[code] = u
+		s.mu.Unlock()
+	}
+	return restored, nil
+}
+
+// PurgeDeletedURLs permanently removes links deleted before before
+func (m *MemoryStorage) PurgeDeletedURLs(ctx context.Context, before time.Time) (int, error) {
+	return m.purgeURLs(func(u URL) bool {
+		return u.deletedBefore(before)
+	}, nil)
+}
+
+// purgeURLs removes the links matching match together with their history
+// and reverse index entries, one shard at a time. It holds indexMu and the
+// lock of the shard being purged, so nothing can restore or change a link
+// while it is being removed, while redirects to the other shards go on.
+// commit, when set, gets the codes about to be removed from a shard; if it
+// fails nothing more is.
+func (m *MemoryStorage) purgeURLs(match func(URL) bool, commit func(codes []string) error) (int, error) {
+	total := 0
+	for _, s := range m.shards {
+		n, err := m.purgeShard(s, match, commit)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (m *MemoryStorage) purgeShard(s *memoryShard, match func(URL) bool, commit func(codes []string) error) (int, error) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged []URL
+	for _, u := range s.urls {
+		if match(u) {
+			purged = append(purged, u)
+		}
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+	if commit != nil {
+		codes := make([]string, 0, len(purged))
+		for _, u := range purged {
+			codes = append(codes, u.Code)
+		}
+		if err := commit(codes); err != nil {
+			return 0, err
+		}
+	}
+	for _, u := range purged {
+		delete(s.urls, u.Code)
+		delete(s.history, u.Code)
+		delete(s.clicks, u.Code)
+		delete(s.rollups, u.Code)
+		delete(s.sketches, u.Code)
+		delete(s.trending, u.Code)
+		m.releaseKey(u)
+	}
+	return len(purged), nil
+}
+
+// removeURLs replays a purge made before a restart
+func (m *MemoryStorage) removeURLs(codes []string) {
+	m.purgeURLs(func(u URL) bool {
+		return slices.Contains(codes, u.Code)
+	}, nil)
+}
+
+// UpdateURL applies edit to the user's link and keeps a replaced target
+func (m *MemoryStorage) UpdateURL(ctx context.Context, userID int, code string, edit URLEdit) (URL, error) {
+	return m.updateURL(userID, code, edit, nil)
+}
+
+// updateURL applies edit under indexMu and the shard lock, so the reverse
+// index, the history and the redirect status change together. commit, when
+// set, gets the edit left once unchanged parts are dropped and the replaced
+// version, nil when the target stays, and runs before anything changes; if
+// it fails nothing does.
+func (m *MemoryStorage) updateURL(userID int, code string, edit URLEdit, commit func(edit URLEdit, prev *URLVersion) error) (URL, error) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	s := m.shard(code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.urls[code]
+	if !ok || u.UserID != userID {
+		return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	if u.isDeleted {
+		return URL{}, ErrURLDeleted
+	}
+	if edit.URL == u.URL && edit.Input == u.Input {
+		edit.URL = ""
+	}
+	if edit.RedirectType != nil && *edit.RedirectType == u.RedirectType {
+		edit.RedirectType = nil
+	}
+	if edit.URL == "" && edit.RedirectType == nil {
+		return u, nil
+	}
+
+	var prev *URLVersion
+	if edit.URL != "" && edit.URL != u.URL {
+		if key, ok := dedupKey(m.dedup, u.UserID, edit.URL); ok && m.holdsKey(key, time.Now(), s) {
+			return URL{}, ErrURLAlreadyExists
+		}
+		prev = &URLVersion{Version: len(s.history[code]) + 1, URL: u.URL, Input: u.Input, ReplacedAt: time.Now()}
+	}
+	if commit != nil {
+		if err := commit(edit, prev); err != nil {
+			return URL{}, err
+		}
+	}
+	return m.applyEdit(s, u, edit, prev), nil
+}
+
+// applyEdit changes u as edit says and records prev when the target is
+// replaced. The caller must hold indexMu and the lock of shard s.
+func (m *MemoryStorage) applyEdit(s *memoryShard, u URL, edit URLEdit, prev *URLVersion) URL {
+	if prev != nil {
+		m.releaseKey(u)
+		if key, ok := dedupKey(m.dedup, u.UserID, edit.URL); ok {
+			m.byURL[key] = u.Code
+		}
+		s.history[u.Code] = append(s.history[u.Code], *prev)
+	}
+	if edit.URL != "" {
+		u.URL, u.Input = edit.URL, edit.Input
+	}
+	if edit.RedirectType != nil {
+		u.RedirectType = *edit.RedirectType
+	}
+	s.urls[u.Code] = u
+	return u
+}
+
+// restoreUpdate replays an edit made before a restart; prev is nil when
+// the target stayed
+func (m *MemoryStorage) restoreUpdate(code string, edit URLEdit, prev *URLVersion) {
+	m.indexMu.Lock()
+	defer m.indexMu.Unlock()
+
+	s := m.shard(code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.urls[code]; ok {
+		m.applyEdit(s, u, edit, prev)
+	}
+}
+
+// URLHistory returns every target of the user's link, oldest first
+func (m *MemoryStorage) URLHistory(ctx context.Context, userID int, code string) ([]URLVersion, error) {
+	s := m.shard(code)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.urls[code]
+	if !ok || u.UserID != userID {
+		return nil, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	history := s.history[code]
+	versions := make([]URLVersion, 0, len(history)+1)
+	versions = append(versions, history...)
+	return append(versions, URLVersion{Version: len(history) + 1, URL: u.URL, Input: u.Input}), nil
+}
+
+// allHistory returns the previous targets of every link that has any
+func (m *MemoryStorage) allHistory() iter.Seq2[string, []URLVersion] {
+	return func(yield func(string, []URLVersion) bool) {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			history := maps.Clone(s.history)
+			s.mu.RUnlock()
+			for code, versions := range history {
+				if !yield(code, versions) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// restoreHistory replaces the previous targets of the link with code
+func (m *MemoryStorage) restoreHistory(code string, versions []URLVersion) {
+	s := m.shard(code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[code] = versions
+}
+
+// SaveClicks records clicks of existing links
+func (m *MemoryStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	return m.saveClicks(clicks, nil)
+}
+
+// saveClicks records clicks and counts them in rollups and sketches once
+// commit, when set, succeeds
+func (m *MemoryStorage) saveClicks(clicks []Click, commit func() error) error {
+	if commit != nil {
+		if err := commit(); err != nil {
+			return err
+		}
+	}
+	for _, c := range clicks {
+		s := m.shard(c.Code)
+		s.mu.Lock()
+		if _, ok := s.urls[c.Code]; ok {
+			raw := append(s.clicks[c.Code], c)
+			// reslicing leaves the old clicks to snapshots still reading
+			// them; the next append that grows raw copies only the kept ones
+			s.clicks[c.Code] = raw[max(len(raw)-maxRawClicks, 0):]
+			buckets := s.rollups[c.Code]
+			if buckets == nil {
+				buckets = make(map[bucketKey]rollup)
+				s.rollups[c.Code] = buckets
+			}
+			for _, interval := range rollupIntervals {
+				start := BucketStart(interval, c.At)
+				k := bucketKey{interval, start.Unix()}
+				r, ok := buckets[k]
+				if !ok {
+					r = rollup{Code: c.Code, Interval: interval, Start: start}
+				}
+				r.add(c)
+				buckets[k] = r
+			}
+			if !c.Bot {
+				s.sketchDay(c.Code, BucketStart(IntervalDay, c.At)).add(c.visitor())
+			}
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// sketchDay returns the visitor sketch of the link with code for day,
+// creating an empty one if needed. The caller holds the shard lock.
+func (s *memoryShard) sketchDay(code string, day time.Time) *sketch {
+	days := s.sketches[code]
+	if days == nil {
+		days = make(map[int64]*sketch)
+		s.sketches[code] = days
+	}
+	sk := days[day.Unix()]
+	if sk == nil {
+		sk = &sketch{}
+		days[day.Unix()] = sk
+	}
+	return sk
+}
+
+// URLStats summarizes the clicks of the user's link
+func (m *MemoryStorage) URLStats(ctx context.Context, userID int, code string) (ClickStats, error) {
+	s := m.shard(code)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.urls[code]
+	if !ok || u.UserID != userID {
+		return ClickStats{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	var daily []rollup
+	for b, r := range s.rollups[code] {
+		if b.interval == IntervalDay {
+			daily = append(daily, r)
+		}
+	}
+	sketches := make([]daySketch, 0, len(s.sketches[code]))
+	for day, sk := range s.sketches[code] {
+		sketches = append(sketches, daySketch{Code: code, Day: time.Unix(day, 0).UTC(), Sketch: sk})
+	}
+	return summarizeClicks(s.clicks[code], daily, sketches), nil
+}
+
+// ClickSeries sums the interval buckets of the user's links. A single
+// link is read from its shard alone.
+func (m *MemoryStorage) ClickSeries(ctx context.Context, userID int, code string, interval string, from, to time.Time) ([]SeriesPoint, error) {
+	sums := make(map[int64]SeriesPoint)
+	add := func(buckets map[bucketKey]rollup) {
+		for b, r := range buckets {
+			if b.interval != interval || b.start < from.Unix() || b.start >= to.Unix() {
+				continue
+			}
+			p := sums[b.start]
+			p.At = r.Start
+			p.Clicks += r.Clicks
+			p.Bots += r.Bots
+			sums[b.start] = p
+		}
+	}
+
+	if code != "" {
+		s := m.shard(code)
+		s.mu.RLock()
+		u, ok := s.urls[code]
+		if ok && u.UserID == userID {
+			add(s.rollups[code])
+		}
+		s.mu.RUnlock()
+		if !ok || u.UserID != userID {
+			return nil, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+		}
+	} else {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			for c, u := range s.urls {
+				if u.UserID == userID {
+					add(s.rollups[c])
+				}
+			}
+			s.mu.RUnlock()
+		}
+	}
+
+	points := make([]SeriesPoint, 0, len(sums))
+	for _, start := range slices.Sorted(maps.Keys(sums)) {
+		points = append(points, sums[start])
+	}
+	return points, nil
+}
+
+// DownsampleClicks removes raw clicks and hourly buckets older than before
+func (m *MemoryStorage) DownsampleClicks(ctx context.Context, before time.Time) (int, error) {
+	return m.downsampleClicks(before, nil)
+}
+
+// downsampleClicks removes raw clicks and hourly buckets older than before
+// once commit, when set, succeeds
+func (m *MemoryStorage) downsampleClicks(before time.Time, commit func() error) (int, error) {
+	if commit != nil {
+		if err := commit(); err != nil {
+			return 0, err
+		}
+	}
+	hourlyBefore := before.Add(-time.Hour).Unix()
+	removed := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for code, clicks := range s.clicks {
+			// a new slice, as snapshots may still read the old one
+			var kept []Click
+			for _, c := range clicks {
+				if !c.At.Before(before) {
+					kept = append(kept, c)
+				}
+			}
+			removed += len(clicks) - len(kept)
+			if len(kept) == 0 {
+				delete(s.clicks, code)
+			} else if len(kept) < len(clicks) {
+				s.clicks[code] = kept
+			}
+		}
+		for _, buckets := range s.rollups {
+			maps.DeleteFunc(buckets, func(b bucketKey, _ rollup) bool {
+				return b.interval == IntervalHour && b.start <= hourlyBefore
+			})
+		}
+		s.mu.Unlock()
+	}
+	return removed, nil
+}
+
+// allClicks returns the clicks of every link that has any
+func (m *MemoryStorage) allClicks() iter.Seq2[string, []Click] {
+	return func(yield func(string, []Click) bool) {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			clicks := maps.Clone(s.clicks)
+			s.mu.RUnlock()
+			for code, list := range clicks {
+				if !yield(code, list) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// allRollups returns the click rollups of every link that has any
+func (m *MemoryStorage) allRollups() iter.Seq2[string, []rollup] {
+	return func(yield func(string, []rollup) bool) {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			byCode := make(map[string][]rollup, len(s.rollups))
+			for code, buckets := range s.rollups {
+				byCode[code] = slices.Collect(maps.Values(buckets))
+			}
+			s.mu.RUnlock()
+			for code, rollups := range byCode {
+				if !yield(code, rollups) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// restoreRollups replaces the click rollups of the link with code
+func (m *MemoryStorage) restoreRollups(code string, rollups []rollup) {
+	s := m.shard(code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.urls[code]; !ok {
+		return
+	}
+	buckets := make(map[bucketKey]rollup, len(rollups))
+	for _, r := range rollups {
+		r.Code, r.Start = code, r.Start.UTC()
+		buckets[bucketKey{r.Interval, r.Start.Unix()}] = r
+	}
+	s.rollups[code] = buckets
+}
+
+// allSketches returns copies of the daily visitor sketches of every link
+// that has any
+func (m *MemoryStorage) allSketches() iter.Seq2[string, []daySketch] {
+	return func(yield func(string, []daySketch) bool) {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			byCode := make(map[string][]daySketch, len(s.sketches))
+			for code, days := range s.sketches {
+				for day, sk := range days {
+					byCode[code] = append(byCode[code], daySketch{Code: code, Day: time.Unix(day, 0).UTC(), Sketch: sk.clone()})
+				}
+			}
+			s.mu.RUnlock()
+			for code, sketches := range byCode {
+				if !yield(code, sketches) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// restoreSketches merges daily visitor sketches into the link with code.
+// Merging a visitor twice counts it once, so sketches may overlap the
+// replayed clicks.
+func (m *MemoryStorage) restoreSketches(code string, sketches []daySketch) {
+	s := m.shard(code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.urls[code]; !ok {
+		return
+	}
+	for _, d := range sketches {
+		s.sketchDay(code, BucketStart(IntervalDay, d.Day)).merge(d.Sketch)
+	}
+}
+
+// SaveTrending adds counts to the trending counters of existing links
+func (m *MemoryStorage) SaveTrending(ctx context.Context, counts []TrendingCount, expired time.Time) error {
+	return m.saveTrending(counts, expired, nil)
+}
+
+// saveTrending adds counts and drops expired counters once commit, when
+// set, succeeds
+func (m *MemoryStorage) saveTrending(counts []TrendingCount, expired time.Time, commit func() error) error {
+	if commit != nil {
+		if err := commit(); err != nil {
+			return err
+		}
+	}
+	for _, c := range counts {
+		s := m.shard(c.Code)
+		s.mu.Lock()
+		if _, ok := s.urls[c.Code]; ok {
+			minutes := s.trending[c.Code]
+			if minutes == nil {
+				minutes = make(map[int64]int)
+				s.trending[c.Code] = minutes
+			}
+			minutes[c.Minute.Unix()] += c.Clicks
+		}
+		s.mu.Unlock()
+	}
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for code, minutes := range s.trending {
+			maps.DeleteFunc(minutes, func(minute int64, _ int) bool {
+				return minute < expired.Unix()
+			})
+			if len(minutes) == 0 {
+				delete(s.trending, code)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// TopLinks sums the trending counters of the user's links since since
+func (m *MemoryStorage) TopLinks(ctx context.Context, userID int, since time.Time, limit int) ([]LinkCount, error) {
+	var counts []LinkCount
+	for _, s := range m.shards {
+		s.mu.RLock()
+		for code, minutes := range s.trending {
+			u := s.urls[code]
+			if u.isDeleted || (userID != 0 && u.UserID != userID) {
+				continue
+			}
+			total := 0
+			for minute, n := range minutes {
+				if minute >= since.Unix() {
+					total += n
+				}
+			}
+			if total > 0 {
+				counts = append(counts, LinkCount{Code: code, URL: u.URL, UserID: u.UserID, Clicks: total})
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return sortLinkCounts(counts, limit), nil
+}
+
+// LinkCounts sums the trending counters of the links with codes since since
+func (m *MemoryStorage) LinkCounts(ctx context.Context, codes []string, since time.Time) ([]LinkCount, error) {
+	var counts []LinkCount
+	for _, code := range codes {
+		s := m.shard(code)
+		s.mu.RLock()
+		if u, ok := s.urls[code]; ok && !u.isDeleted {
+			c := LinkCount{Code: code, URL: u.URL, UserID: u.UserID}
+			for minute, n := range s.trending[code] {
+				if minute >= since.Unix() {
+					c.Clicks += n
+				}
+			}
+			counts = append(counts, c)
+		}
+		s.mu.RUnlock()
+	}
+	return counts, nil
+}
+
+// allTrending returns the trending counters of every link that has any
+func (m *MemoryStorage) allTrending() iter.Seq2[string, []TrendingCount] {
+	return func(yield func(string, []TrendingCount) bool) {
+		for _, s := range m.shards {
+			s.mu.RLock()
+			byCode := make(map[string][]TrendingCount, len(s.trending))
+			for code, minutes := range s.trending {
+				for minute, n := range minutes {
+					byCode[code] = append(byCode[code], TrendingCount{Code: code, Minute: time.Unix(minute, 0).UTC(), Clicks: n})
+				}
+			}
+			s.mu.RUnlock()
+			for code, counts := range byCode {
+				if !yield(code, counts) {
+					return
+				}
+			}
+		}
+	}
 }
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRawClicksCap(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	require.NoError(t, store.SaveURL(ctx, URL{Code: "hot001", URL: "https://hot.example.com", UserID: 1}))
+
+	clicks := make([]Click, maxRawClicks+10)
+	for i := range clicks {
+		clicks[i] = Click{Code: "hot001", At: time.Now(), Referrer: "old.example.com"}
+	}
+	for i := len(clicks) - maxRawClicks; i < len(clicks); i++ {
+		clicks[i].Referrer = "new.example.com"
+	}
+	require.NoError(t, store.SaveClicks(ctx, clicks))
+
+	stats, err := store.URLStats(ctx, 1, "hot001")
+	require.NoError(t, err)
+	assert.Equal(t, len(clicks), stats.Total, "rollups count every click")
+	assert.Equal(t, []StatCount{{Key: "new.example.com", Count: maxRawClicks}}, stats.Referrers, "only the newest raw clicks are kept")
+}
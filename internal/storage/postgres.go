@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"iter"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib" // driver
 
@@ -18,85 +20,148 @@ import (
 // PostgresStorage is DB implementation of the Storage interface
 // generate:reset
 type PostgresStorage struct {
-	DB *sql.DB
+	DB    *sql.DB
+	dedup string
 }
 
-// NewPostgresStorage creates new PostgresStorage
-func NewPostgresStorage(cfg *config.Config) *PostgresStorage {
-	db, err := sql.Open("pgx", cfg.DatabaseDsn)
+func init() {
+	factory := func(dsn string, cfg *config.Config) (Storage, error) {
+		return NewPostgresStorage(dsn, cfg)
+	}
+	Register("postgres", factory)
+	Register("postgresql", factory)
+}
+
+var postgresRekey = rekeyStatements{
+	getScope: "SELECT value FROM storage_settings WHERE name = 'dedup_scope' FOR UPDATE",
+	setScope: "UPDATE storage_settings SET value = $1 WHERE name = 'dedup_scope'",
+	setKey:   "UPDATE urls SET dedup_key = $1 WHERE code = $2",
+}
+
+// NewPostgresStorage connects to the database at dsn and migrates it.
+// dsn is either a postgres:// URL or a key=value connection string.
+func NewPostgresStorage(dsn string, cfg *config.Config) (*PostgresStorage, error) {
+	dedup, err := parseDedupScope(cfg.DedupScope)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
 	}
 	if err := runMigrations(db, cfg.MigrationsPath); err != nil {
-		panic(fmt.Errorf("failed to run migrations: %w", err))
+		db.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	if err := rekeyDedup(context.Background(), db, dedup, postgresRekey); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to recompute dedup keys: %w", postgresError(err))
 	}
 	store := &PostgresStorage{
-		DB: db,
+		DB:    db,
+		dedup: dedup,
 	}
-	return store
+	return store, nil
 }
 
 // SaveURL save a URL by code in DB
 func (store *PostgresStorage) SaveURL(ctx context.Context, u URL) error {
-	userID := sql.NullInt64{}
-	if u.UserID != 0 {
-		userID.Int64 = int64(u.UserID)
-		userID.Valid = true
-	}
-	_, err := store.DB.ExecContext(ctx, "INSERT INTO urls (code, url, user_id) VALUES ($1, $2, $3)", u.Code, u.URL, userID)
-	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == pgerrcode.UniqueViolation {
-				switch pgErr.ConstraintName {
-				case "idx_urls_code":
-					return ErrCodeAlreadyExists
-				case "idx_urls_url":
-					return ErrURLAlreadyExists
-				default:
-					return err
-				}
-			}
+	return store.SaveBatchURL(ctx, []URL{u})
+}
+
+// postgresError converts driver errors into storage errors: unique and
+// foreign key violations become sentinel errors, lost connections and
+// retryable server states become TransientError
+func postgresError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "idx_urls_code":
+			return ErrCodeAlreadyExists
+		case pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "idx_urls_dedup_key":
+			return ErrURLAlreadyExists
+		case pgErr.Code == pgerrcode.ForeignKeyViolation && pgErr.ConstraintName == "fk_urls_user":
+			return ErrUserNotFound
+		case pgerrcode.IsConnectionException(pgErr.Code),
+			pgerrcode.IsInsufficientResources(pgErr.Code),
+			pgerrcode.IsOperatorIntervention(pgErr.Code),
+			pgerrcode.IsTransactionRollback(pgErr.Code):
+			return transient(err)
 		}
 		return err
 	}
-	return nil
+	var connErr *pgconn.ConnectError
+	if errors.As(err, &connErr) || isConnectionError(err) {
+		return transient(err)
+	}
+	return err
 }
 
 // GetURL get URL by code from DB
 func (store *PostgresStorage) GetURL(ctx context.Context, code string) (URL, error) {
-	row := store.DB.QueryRowContext(ctx, "SELECT url, is_deleted FROM urls WHERE code = $1", code)
-	var url string
-	var isDeleted bool
-	if err := row.Scan(&url, &isDeleted); err != nil {
+	row := store.DB.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE code = $1", code)
+	url, err := scanURL(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return URL{}, fmt.Errorf("url with code %s not found", code)
+			return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
 		}
+		return URL{}, postgresError(err)
+	}
+	if err := url.check(time.Now()); err != nil {
 		return URL{}, err
 	}
-	if isDeleted {
-		return URL{}, ErrURLDeleted
+	return url, nil
+}
+
+// FollowURL get URL by code from DB and spend one of its clicks.
+// The click is spent by an UPDATE that only matches a followable link, so
+// concurrent redirects cannot both take the last one, nor spend one on a
+// link deleted or expired in between. A link the update misses is looked
+// up again only to tell why. Unlimited links are only read.
+func (store *PostgresStorage) FollowURL(ctx context.Context, code string) (URL, error) {
+	url, err := store.GetURL(ctx, code)
+	if err != nil || url.MaxClicks == 0 {
+		return url, err
 	}
 
-	return URL{Code: code, URL: url, isDeleted: isDeleted}, nil
+	query := `
+        UPDATE urls SET clicks = clicks + 1
+        WHERE code = $1 AND clicks < max_clicks AND is_deleted = FALSE AND (expires_at IS NULL OR expires_at > $2)
+        RETURNING ` + urlColumns
+	url, err = scanURL(store.DB.QueryRowContext(ctx, query, code, time.Now()))
+	if err == nil {
+		return url, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return URL{}, postgresError(err)
+	}
+	if _, err := store.GetURL(ctx, code); err != nil {
+		return URL{}, err
+	}
+	return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLExhausted)
 }
 
-// GetByURL get URL by url from DB
-func (store *PostgresStorage) GetByURL(ctx context.Context, url string) (URL, error) {
-	row := store.DB.QueryRowContext(ctx, "SELECT code, is_deleted FROM urls WHERE url = $1", url)
-	var code string
-	var isDeleted bool
-	if err := row.Scan(&code, &isDeleted); err != nil {
+// GetByURL get the link that keeps the user from shortening url again
+func (store *PostgresStorage) GetByURL(ctx context.Context, userID int, url string) (URL, error) {
+	key, ok := dedupKey(store.dedup, userID, url)
+	if !ok {
+		return URL{}, fmt.Errorf("url with url %s: %w", url, ErrURLNotFound)
+	}
+	row := store.DB.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE dedup_key = $1", key)
+	u, err := scanURL(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return URL{}, fmt.Errorf("url with url %s not found", url)
+			return URL{}, fmt.Errorf("url with url %s: %w", url, ErrURLNotFound)
 		}
-		return URL{}, err
+		return URL{}, postgresError(err)
 	}
-	if isDeleted {
-		return URL{}, ErrURLDeleted
+	if err := u.check(time.Now()); err != nil {
+		return URL{}, err
 	}
-
-	return URL{Code: code, URL: url, isDeleted: isDeleted}, nil
+	return u, nil
 }
 
 // Close releases resources
@@ -107,39 +172,59 @@ func (store *PostgresStorage) Close() error {
 
 // Ping DB
 func (store *PostgresStorage) Ping(ctx context.Context) error {
-	if err := store.DB.PingContext(ctx); err != nil {
-		return err
-	}
-	return nil
+	return postgresError(store.DB.PingContext(ctx))
 }
 
-// AllURLs returns all URLs
-func (store *PostgresStorage) AllURLs(ctx context.Context) ([]URL, error) {
-	// TODO
-	return []URL{}, ErrNotImplemented
+// AllURLs streams all URLs through a server-side cursor
+func (store *PostgresStorage) AllURLs(ctx context.Context) iter.Seq2[URL, error] {
+	query := "SELECT " + urlColumns + " FROM urls ORDER BY id"
+	return streamCursor(ctx, store.DB, query, func(rows *sql.Rows) (URL, error) {
+		return scanURL(rows)
+	})
 }
 
-// SaveBatchURL save list of URL
+// SaveBatchURL save list of URL in a single transaction
 func (store *PostgresStorage) SaveBatchURL(ctx context.Context, urls []URL) error {
-	tx, err := store.DB.Begin()
+	tx, err := store.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return postgresError(err)
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx, "INSERT INTO urls (code, url) VALUES ($1, $2)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO urls (code, url, input_url, user_id, expires_at, max_clicks, redirect_type, dedup_key) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)")
 	if err != nil {
-		return err
+		return postgresError(err)
 	}
 	defer stmt.Close()
 
 	for _, url := range urls {
-		_, err := stmt.ExecContext(ctx, url.Code, url.URL)
+		if err := store.releaseDeadKey(ctx, tx, url.UserID, url.URL); err != nil {
+			return postgresError(err)
+		}
+		_, err := stmt.ExecContext(ctx, url.Code, url.URL, nullString(url.Input), nullUserID(url.UserID), nullTime(url.ExpiresAt), nullMaxClicks(url.MaxClicks), nullRedirectType(url.RedirectType), nullDedupKey(store.dedup, url.UserID, url.URL))
 		if err != nil {
-			return err
+			return postgresError(err)
 		}
 	}
-	return tx.Commit()
+	return postgresError(tx.Commit())
+}
+
+// releaseDeadKey takes the dedup key of url away from the link holding it
+// when that link is expired or used up
+func (store *PostgresStorage) releaseDeadKey(ctx context.Context, tx *sql.Tx, userID int, url string) error {
+	key, ok := dedupKey(store.dedup, userID, url)
+	if !ok {
+		return nil
+	}
+	holder, err := scanURL(tx.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE dedup_key = $1", key))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !holder.dead(time.Now()) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE urls SET dedup_key = NULL WHERE code = $1", holder.Code)
+	return err
 }
 
 // CreateUser creates a new user and returns it
@@ -147,37 +232,88 @@ func (store *PostgresStorage) CreateUser(ctx context.Context) (User, error) {
 	var id int
 	err := store.DB.QueryRowContext(ctx, "INSERT INTO users DEFAULT VALUES RETURNING id").Scan(&id)
 	if err != nil {
-		return User{}, err
+		return User{}, postgresError(err)
 	}
 	return User{ID: int(id)}, nil
 }
 
+// GetUser returns the user with id
+func (store *PostgresStorage) GetUser(ctx context.Context, id int) (User, error) {
+	var user User
+	err := store.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE id = $1", id).Scan(&user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+		}
+		return User{}, postgresError(err)
+	}
+	return user, nil
+}
+
 // GetURLsByUserID returns all URLs associated with a specific user ID
 func (store *PostgresStorage) GetURLsByUserID(ctx context.Context, userID int) ([]URL, error) {
-	rows, err := store.DB.QueryContext(ctx, "SELECT code, url FROM urls WHERE user_id = $1 AND is_deleted=FALSE", userID)
+	rows, err := store.DB.QueryContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE user_id = $1 AND is_deleted=FALSE", userID)
 	if err != nil {
-		return nil, err
+		return nil, postgresError(err)
 	}
 	defer rows.Close()
 
 	var urls []URL
 	for rows.Next() {
-		var url URL
-		if err := rows.Scan(&url.Code, &url.URL); err != nil {
-			return nil, err
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, postgresError(err)
 		}
-		url.UserID = userID
 		urls = append(urls, url)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, postgresError(err)
 	}
 	return urls, nil
 }
 
-// GetAllUsers returns all users
-func (store *PostgresStorage) GetAllUsers(ctx context.Context) ([]User, error) {
-	return nil, ErrNotImplemented
+// GetAllUsers streams all users through a server-side cursor
+func (store *PostgresStorage) GetAllUsers(ctx context.Context) iter.Seq2[User, error] {
+	query := "SELECT id FROM users ORDER BY id"
+	return streamCursor(ctx, store.DB, query, func(rows *sql.Rows) (User, error) {
+		var user User
+		err := rows.Scan(&user.ID)
+		return user, err
+	})
+}
+
+// codeIDLeaseLock is the advisory lock key that serializes ID leases
+const codeIDLeaseLock = 0x636f6465 // "code"
+
+// LeaseIDs reserves size IDs from code_id_seq. nextval takes the first ID
+// and setval moves the sequence past the block; the advisory lock keeps
+// other instances from calling nextval in between.
+func (store *PostgresStorage) LeaseIDs(ctx context.Context, size int) (int64, error) {
+	if size <= 0 {
+		return 0, ErrInvalidLeaseSize
+	}
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, postgresError(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", codeIDLeaseLock); err != nil {
+		return 0, postgresError(err)
+	}
+	var start int64
+	if err := tx.QueryRowContext(ctx, "SELECT nextval('code_id_seq')").Scan(&start); err != nil {
+		return 0, postgresError(err)
+	}
+	if size > 1 {
+		if _, err := tx.ExecContext(ctx, "SELECT setval('code_id_seq', $1)", start+int64(size)-1); err != nil {
+			return 0, postgresError(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, postgresError(err)
+	}
+	return start, nil
 }
 
 // DeleteUserURLs marks user's URLs as deleted.
@@ -185,9 +321,389 @@ func (store *PostgresStorage) GetAllUsers(ctx context.Context) ([]User, error) {
 func (store *PostgresStorage) DeleteUserURLs(ctx context.Context, userID int, codes []string) error {
 	query := `
         UPDATE urls
-        SET is_deleted = TRUE
-        WHERE user_id = $1 AND code = ANY($2::text[]);
+        SET is_deleted = TRUE, deleted_at = $3
+        WHERE user_id = $1 AND code = ANY($2::text[]) AND is_deleted = FALSE;
     `
-	_, err := store.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	_, err := store.DB.ExecContext(ctx, query, userID, pq.Array(codes), time.Now())
+	return postgresError(err)
+}
+
+// RestoreUserURLs undeletes the user's links deleted after since
+func (store *PostgresStorage) RestoreUserURLs(ctx context.Context, userID int, codes []string, since time.Time) ([]string, error) {
+	query := `
+        UPDATE urls
+        SET is_deleted = FALSE, deleted_at = NULL
+        WHERE user_id = $1 AND code = ANY($2::text[]) AND is_deleted = TRUE AND deleted_at > $3
+        RETURNING code;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, userID, pq.Array(codes), since)
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	defer rows.Close()
+
+	restored, err := scanCodes(rows)
+	return restored, postgresError(err)
+}
+
+// PurgeDeletedURLs permanently removes links deleted before before.
+// Their history goes with them by ON DELETE CASCADE.
+func (store *PostgresStorage) PurgeDeletedURLs(ctx context.Context, before time.Time) (int, error) {
+	res, err := store.DB.ExecContext(ctx, "DELETE FROM urls WHERE is_deleted = TRUE AND deleted_at < $1", before)
+	if err != nil {
+		return 0, postgresError(err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), postgresError(err)
+}
+
+// UpdateURL applies edit to the user's link in one transaction. It moves a
+// replaced target to url_history, so history and link never disagree, and
+// a refused target leaves the redirect status as it was.
+func (store *PostgresStorage) UpdateURL(ctx context.Context, userID int, code string, edit URLEdit) (URL, error) {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return URL{}, postgresError(err)
+	}
+	defer tx.Rollback()
+
+	current, err := scanURL(tx.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE code = $1 AND user_id = $2 FOR UPDATE", code, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+		}
+		return URL{}, postgresError(err)
+	}
+	if current.isDeleted {
+		return URL{}, ErrURLDeleted
+	}
+	changeURL := edit.URL != "" && edit.URL != current.URL
+	// the same target spelled another way keeps its version
+	changeInput := edit.URL == current.URL && edit.Input != current.Input
+	changeType := edit.RedirectType != nil && *edit.RedirectType != current.RedirectType
+	if !changeURL && !changeInput && !changeType {
+		return current, nil
+	}
+	updated := current
+	if changeURL {
+		if updated, err = store.replaceTarget(ctx, tx, current, edit); err != nil {
+			return URL{}, err
+		}
+	}
+	if changeInput {
+		query := "UPDATE urls SET input_url = $1 WHERE code = $2 RETURNING " + urlColumns
+		if updated, err = scanURL(tx.QueryRowContext(ctx, query, nullString(edit.Input), code)); err != nil {
+			return URL{}, postgresError(err)
+		}
+	}
+	if changeType {
+		query := "UPDATE urls SET redirect_type = $1 WHERE code = $2 RETURNING " + urlColumns
+		if updated, err = scanURL(tx.QueryRowContext(ctx, query, nullRedirectType(*edit.RedirectType), code)); err != nil {
+			return URL{}, postgresError(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return URL{}, postgresError(err)
+	}
+	return updated, nil
+}
+
+// replaceTarget points the link current at the target of edit within tx
+// and moves the previous target to url_history
+func (store *PostgresStorage) replaceTarget(ctx context.Context, tx *sql.Tx, current URL, edit URLEdit) (URL, error) {
+	code := current.Code
+	query := `
+        INSERT INTO url_history (code, version, url, input_url, replaced_at)
+        SELECT $1, COALESCE(MAX(version), 0) + 1, $2::text, $3::text, $4::timestamptz FROM url_history WHERE code = $1;
+    `
+	if _, err := tx.ExecContext(ctx, query, code, current.URL, nullString(current.Input), time.Now()); err != nil {
+		return URL{}, postgresError(err)
+	}
+	if err := store.releaseDeadKey(ctx, tx, current.UserID, edit.URL); err != nil {
+		return URL{}, postgresError(err)
+	}
+	updated, err := scanURL(tx.QueryRowContext(ctx, "UPDATE urls SET url = $1, input_url = $2, dedup_key = $3 WHERE code = $4 RETURNING "+urlColumns, edit.URL, nullString(edit.Input), nullDedupKey(store.dedup, current.UserID, edit.URL), code))
+	return updated, postgresError(err)
+}
+
+// URLHistory returns every target of the user's link, oldest first.
+// A single statement reads history and link, so they match.
+func (store *PostgresStorage) URLHistory(ctx context.Context, userID int, code string) ([]URLVersion, error) {
+	query := `
+        SELECT h.version, h.url, h.input_url, h.replaced_at
+        FROM url_history h JOIN urls u ON u.code = h.code
+        WHERE h.code = $1 AND u.user_id = $2
+        UNION ALL
+        SELECT NULL, url, input_url, NULL FROM urls WHERE code = $1 AND user_id = $2
+        ORDER BY 1 NULLS LAST;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, code, userID)
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	defer rows.Close()
+
+	versions, err := scanVersions(rows)
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	return versions, nil
+}
+
+// SaveClicks records clicks of existing links in one transaction
+func (store *PostgresStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return postgresError(err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO clicks (code, ts, referrer, user_agent, ip, is_bot, country, region, asn)
+        SELECT $1::text, $2::timestamptz, $3::text, $4::text, $5::text, $6::boolean,
+            $7::text, $8::text, $9::integer
+        WHERE EXISTS (SELECT 1 FROM urls WHERE code = $1::text);
+    `
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return postgresError(err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		if _, err := stmt.ExecContext(ctx, c.Code, c.At, c.Referrer, c.UserAgent, c.IP, c.Bot, c.Country, c.Region, c.ASN); err != nil {
+			return postgresError(err)
+		}
+	}
+
+	rollupQuery := `
+        INSERT INTO click_rollups (code, bucket_interval, bucket_start, clicks, bots)
+        SELECT $1::text, $2::text, $3::timestamptz, $4::integer, $5::integer
+        WHERE EXISTS (SELECT 1 FROM urls WHERE code = $1::text)
+        ON CONFLICT (code, bucket_interval, bucket_start)
+        DO UPDATE SET clicks = click_rollups.clicks + EXCLUDED.clicks, bots = click_rollups.bots + EXCLUDED.bots;
+    `
+	rollupStmt, err := tx.PrepareContext(ctx, rollupQuery)
+	if err != nil {
+		return postgresError(err)
+	}
+	defer rollupStmt.Close()
+
+	for _, r := range rollupClicks(clicks) {
+		if _, err := rollupStmt.ExecContext(ctx, r.Code, r.Interval, r.Start, r.Clicks, r.Bots); err != nil {
+			return postgresError(err)
+		}
+	}
+
+	if err := store.saveSketches(ctx, tx, sketchClicks(clicks)); err != nil {
+		return err
+	}
+	return postgresError(tx.Commit())
+}
+
+// saveSketches merges daily visitor sketches into the stored ones. A day
+// seen for the first time is inserted; an existing one is locked, merged
+// and written back, so concurrent writers do not lose each other's visitors.
+func (store *PostgresStorage) saveSketches(ctx context.Context, tx *sql.Tx, sketches []daySketch) error {
+	insertQuery := `
+        INSERT INTO click_sketches (code, day, sketch)
+        SELECT $1::text, $2::timestamptz, $3::bytea
+        WHERE EXISTS (SELECT 1 FROM urls WHERE code = $1::text)
+        ON CONFLICT (code, day) DO NOTHING;
+    `
+	for _, s := range sketches {
+		res, err := tx.ExecContext(ctx, insertQuery, s.Code, s.Day, s.Sketch.marshal())
+		if err != nil {
+			return postgresError(err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			continue
+		}
+
+		var stored []byte
+		err = tx.QueryRowContext(ctx, "SELECT sketch FROM click_sketches WHERE code = $1 AND day = $2 FOR UPDATE", s.Code, s.Day).Scan(&stored)
+		if errors.Is(err, sql.ErrNoRows) {
+			// the link is gone
+			continue
+		}
+		if err != nil {
+			return postgresError(err)
+		}
+		merged, err := mergeStoredSketch(stored, s.Sketch)
+		if err != nil {
+			return fmt.Errorf("sketch of %s: %w", s.Code, err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE click_sketches SET sketch = $3 WHERE code = $1 AND day = $2", s.Code, s.Day, merged); err != nil {
+			return postgresError(err)
+		}
+	}
+	return nil
+}
+
+// checkOwner returns ErrURLNotFound unless the link with code is the user's
+func (store *PostgresStorage) checkOwner(ctx context.Context, userID int, code string) error {
+	var exists bool
+	err := store.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM urls WHERE code = $1 AND user_id = $2)", code, userID).Scan(&exists)
+	if err != nil {
+		return postgresError(err)
+	}
+	if !exists {
+		return fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	return nil
+}
+
+// URLStats summarizes the clicks of the user's link
+func (store *PostgresStorage) URLStats(ctx context.Context, userID int, code string) (ClickStats, error) {
+	if err := store.checkOwner(ctx, userID, code); err != nil {
+		return ClickStats{}, err
+	}
+
+	rows, err := store.DB.QueryContext(ctx, clickStatsQuery("to_char(bucket_start AT TIME ZONE 'UTC', 'YYYY-MM-DD')", "$1"), code)
+	if err != nil {
+		return ClickStats{}, postgresError(err)
+	}
+	defer rows.Close()
+
+	stats, err := scanClickStats(rows)
+	if err != nil {
+		return ClickStats{}, postgresError(err)
+	}
+
+	rows, err = store.DB.QueryContext(ctx, "SELECT day, sketch FROM click_sketches WHERE code = $1", code)
+	if err != nil {
+		return ClickStats{}, postgresError(err)
+	}
+	defer rows.Close()
+
+	sketches, err := scanSketches(rows, code)
+	if err != nil {
+		return ClickStats{}, postgresError(err)
+	}
+	stats.setUniques(sketches, time.Now())
+	return stats, nil
+}
+
+// ClickSeries sums the interval buckets of the user's links
+func (store *PostgresStorage) ClickSeries(ctx context.Context, userID int, code string, interval string, from, to time.Time) ([]SeriesPoint, error) {
+	if code != "" {
+		if err := store.checkOwner(ctx, userID, code); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+        SELECT r.bucket_start, SUM(r.clicks), SUM(r.bots)
+        FROM click_rollups r JOIN urls u ON u.code = r.code
+        WHERE u.user_id = $1 AND r.bucket_interval = $2
+            AND r.bucket_start >= $3 AND r.bucket_start < $4
+            AND ($5::text = '' OR r.code = $5::text)
+        GROUP BY r.bucket_start
+        ORDER BY r.bucket_start;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, userID, interval, from, to, code)
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	defer rows.Close()
+
+	points, err := scanSeries(rows)
+	return points, postgresError(err)
+}
+
+// DownsampleClicks removes raw clicks and hourly rollups older than before
+func (store *PostgresStorage) DownsampleClicks(ctx context.Context, before time.Time) (int, error) {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, postgresError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM clicks WHERE ts < $1", before)
+	if err != nil {
+		return 0, postgresError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, postgresError(err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM click_rollups WHERE bucket_interval = $1 AND bucket_start <= $2", IntervalHour, before.Add(-time.Hour))
+	if err != nil {
+		return 0, postgresError(err)
+	}
+	return int(n), postgresError(tx.Commit())
+}
+
+// SaveTrending adds counts to the trending counters of existing links in
+// one transaction
+func (store *PostgresStorage) SaveTrending(ctx context.Context, counts []TrendingCount, expired time.Time) error {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return postgresError(err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO click_trending (code, minute, clicks)
+        SELECT $1::text, $2::timestamptz, $3::integer
+        WHERE EXISTS (SELECT 1 FROM urls WHERE code = $1::text)
+        ON CONFLICT (code, minute)
+        DO UPDATE SET clicks = click_trending.clicks + EXCLUDED.clicks;
+    `
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return postgresError(err)
+	}
+	defer stmt.Close()
+
+	for _, c := range counts {
+		if _, err := stmt.ExecContext(ctx, c.Code, c.Minute, c.Clicks); err != nil {
+			return postgresError(err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM click_trending WHERE minute < $1", expired); err != nil {
+		return postgresError(err)
+	}
+	return postgresError(tx.Commit())
+}
+
+// TopLinks sums the trending counters of the user's links since since
+func (store *PostgresStorage) TopLinks(ctx context.Context, userID int, since time.Time, limit int) ([]LinkCount, error) {
+	query := `
+        SELECT t.code, u.url, u.user_id, SUM(t.clicks) AS total
+        FROM click_trending t JOIN urls u ON u.code = t.code
+        WHERE t.minute >= $1 AND NOT u.is_deleted AND ($2::integer = 0 OR u.user_id = $2::integer)
+        GROUP BY t.code, u.url, u.user_id
+        ORDER BY total DESC, t.code
+        LIMIT $3;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, since, userID, limit)
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	defer rows.Close()
+
+	counts, err := scanLinkCounts(rows)
+	return counts, postgresError(err)
+}
+
+// LinkCounts sums the trending counters of the links with codes since since
+func (store *PostgresStorage) LinkCounts(ctx context.Context, codes []string, since time.Time) ([]LinkCount, error) {
+	query := `
+        SELECT u.code, u.url, u.user_id, COALESCE(SUM(t.clicks), 0)
+        FROM urls u LEFT JOIN click_trending t ON t.code = u.code AND t.minute >= $1
+        WHERE u.code = ANY($2::text[]) AND NOT u.is_deleted
+        GROUP BY u.code, u.url, u.user_id;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, since, pq.Array(codes))
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	defer rows.Close()
+
+	counts, err := scanLinkCounts(rows)
+	return counts, postgresError(err)
 }
@@ -1,14 +1,19 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"iter"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file" //
 )
 
+// cursorFetchSize is the number of rows fetched from a server-side cursor per round trip
+const cursorFetchSize = 1000
+
 func runMigrations(db *sql.DB, migrationsPath string) error {
 	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
@@ -30,3 +35,64 @@ func runMigrations(db *sql.DB, migrationsPath string) error {
 
 	return nil
 }
+
+// streamCursor declares a server-side cursor for query inside a read-only
+// transaction and yields rows in chunks of cursorFetchSize, so only one chunk
+// is held in memory at a time. Iteration stops at the first error.
+func streamCursor[T any](ctx context.Context, db *sql.DB, query string, scan func(*sql.Rows) (T, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			yield(zero, postgresError(err))
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+query); err != nil {
+			yield(zero, fmt.Errorf("declare cursor: %w", postgresError(err)))
+			return
+		}
+
+		fetch := fmt.Sprintf("FETCH FORWARD %d FROM export_cursor", cursorFetchSize)
+		for {
+			n, ok := yieldChunk(ctx, tx, fetch, scan, yield)
+			if !ok || n < cursorFetchSize {
+				return
+			}
+		}
+	}
+}
+
+// yieldChunk fetches one chunk from the cursor and passes its rows to yield.
+// It returns the number of rows fetched and whether iteration should continue.
+func yieldChunk[T any](ctx context.Context, tx *sql.Tx, fetch string, scan func(*sql.Rows) (T, error), yield func(T, error) bool) (int, bool) {
+	var zero T
+
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		yield(zero, fmt.Errorf("fetch cursor: %w", postgresError(err)))
+		return 0, false
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+		item, err := scan(rows)
+		if err != nil {
+			yield(zero, err)
+			return n, false
+		}
+		if !yield(item, nil) {
+			return n, false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		yield(zero, postgresError(err))
+		return n, false
+	}
+
+	return n, true
+}
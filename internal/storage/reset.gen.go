@@ -1,6 +1,93 @@
 // Code generated by reset generator. DO NOT EDIT.
 package storage
 
+func (c *Click) Reset() {
+	if c == nil {
+		return
+	}
+
+	c.Code = ""
+
+	c.Referrer = ""
+
+	c.UserAgent = ""
+
+	c.IP = ""
+
+	c.Bot = false
+
+	c.Country = ""
+
+	c.Region = ""
+
+	c.ASN = 0
+
+}
+
+func (c *ClickStats) Reset() {
+	if c == nil {
+		return
+	}
+
+	c.Total = 0
+
+	c.Bots = 0
+
+	c.Unique = 0
+
+	c.UniqueWeek = 0
+
+	c.Referrers = c.Referrers[:0]
+
+	c.Countries = c.Countries[:0]
+
+	c.Regions = c.Regions[:0]
+
+	c.ASNs = c.ASNs[:0]
+
+	c.Days = c.Days[:0]
+
+	c.UniqueDays = c.UniqueDays[:0]
+
+}
+
+func (s *StatCount) Reset() {
+	if s == nil {
+		return
+	}
+
+	s.Key = ""
+
+	s.Count = 0
+
+}
+
+func (s *SeriesPoint) Reset() {
+	if s == nil {
+		return
+	}
+
+	s.Clicks = 0
+
+	s.Bots = 0
+
+}
+
+func (r *rollup) Reset() {
+	if r == nil {
+		return
+	}
+
+	r.Code = ""
+
+	r.Interval = ""
+
+	r.Clicks = 0
+
+	r.Bots = 0
+
+}
+
 func (s *savedURLItem) Reset() {
 	if s == nil {
 		return
@@ -14,6 +101,8 @@ func (s *savedURLItem) Reset() {
 
 	s.UserID = 0
 
+	s.IsDeleted = false
+
 }
 
 func (s *savedUserItem) Reset() {
@@ -27,26 +116,22 @@ func (s *savedUserItem) Reset() {
 
 }
 
-func (m *MemoryStorage) Reset() {
-	if m == nil {
+func (p *PostgresStorage) Reset() {
+	if p == nil {
 		return
 	}
 
-	clear(m.Urls)
-
-	clear(m.Users)
-
-	m.UseFile = false
-
-	m.DataFilePath = ""
+	p.dedup = ""
 
 }
 
-func (p *PostgresStorage) Reset() {
-	if p == nil {
+func (s *SQLiteStorage) Reset() {
+	if s == nil {
 		return
 	}
 
+	s.dedup = ""
+
 }
 
 func (u *URL) Reset() {
@@ -58,8 +143,16 @@ func (u *URL) Reset() {
 
 	u.URL = ""
 
+	u.Input = ""
+
 	u.UserID = 0
 
+	u.MaxClicks = 0
+
+	u.Clicks = 0
+
+	u.RedirectType = 0
+
 	u.isDeleted = false
 
 }
@@ -72,3 +165,163 @@ func (u *User) Reset() {
 	u.ID = 0
 
 }
+
+func (u *URLVersion) Reset() {
+	if u == nil {
+		return
+	}
+
+	u.Version = 0
+
+	u.URL = ""
+
+	u.Input = ""
+
+}
+
+func (t *TrendingCount) Reset() {
+	if t == nil {
+		return
+	}
+
+	t.Code = ""
+
+	t.Clicks = 0
+
+}
+
+func (l *LinkCount) Reset() {
+	if l == nil {
+		return
+	}
+
+	l.Code = ""
+
+	l.URL = ""
+
+	l.UserID = 0
+
+	l.Clicks = 0
+
+}
+
+func (w *walRecord) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.Op = ""
+
+	w.URLs = w.URLs[:0]
+
+	w.UserID = 0
+
+	w.Codes = w.Codes[:0]
+
+	w.NextID = 0
+
+	w.Versions = w.Versions[:0]
+
+	w.Clicks = w.Clicks[:0]
+
+	w.Rollups = w.Rollups[:0]
+
+	w.Sketches = w.Sketches[:0]
+
+	w.Trending = w.Trending[:0]
+
+}
+
+func (w *walURL) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.Code = ""
+
+	w.URL = ""
+
+	w.Input = ""
+
+	w.UserID = 0
+
+	w.MaxClicks = 0
+
+	w.Clicks = 0
+
+	w.Deleted = false
+
+	w.RedirectType = 0
+
+}
+
+func (w *walVersion) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.Version = 0
+
+	w.URL = ""
+
+	w.Input = ""
+
+}
+
+func (w *walClick) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.Code = ""
+
+	w.Referrer = ""
+
+	w.UserAgent = ""
+
+	w.IP = ""
+
+	w.Bot = false
+
+	w.Country = ""
+
+	w.Region = ""
+
+	w.ASN = 0
+
+}
+
+func (w *walRollup) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.Code = ""
+
+	w.Interval = ""
+
+	w.Clicks = 0
+
+	w.Bots = 0
+
+}
+
+func (w *walSketch) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.Sketch = w.Sketch[:0]
+
+}
+
+func (w *walTrend) Reset() {
+	if w == nil {
+		return
+	}
+
+	w.Code = ""
+
+	w.Clicks = 0
+
+}
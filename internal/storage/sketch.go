@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// sketchPrecision is the number of hash bits that pick a register; the
+// standard error of estimates is 1.04/sqrt(2^sketchPrecision), about 1.6%
+const sketchPrecision = 12
+
+const sketchRegisters = 1 << sketchPrecision
+
+// sketchMaxRank is the highest rank add sets: the guard bit stops the
+// count of leading zeros of the remaining hash bits
+const sketchMaxRank = 64 - sketchPrecision + 1
+
+// sketchSparseMax is how many registers a sketch keeps in a map before it
+// switches to a dense array, which is smaller from then on
+const sketchSparseMax = sketchRegisters / 16
+
+// Encodings of a stored sketch, in its first byte
+const (
+	sketchDense  = 1
+	sketchSparse = 2
+)
+
+var errBadSketch = errors.New("malformed visitor sketch")
+
+// sketch is a HyperLogLog estimate of distinct visitors. Sketches merge
+// without losing accuracy, so a day's sketch serves for the day and for
+// any range of days that includes it.
+type sketch struct {
+	// sparse holds the non-zero registers of small sketches; registers
+	// replaces it once the sketch grows
+	sparse    map[uint16]uint8
+	registers []uint8
+}
+
+// hashVisitor hashes a visitor key. Stored sketches depend on it, so it
+// must never change.
+func hashVisitor(visitor string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(visitor))
+	// the splitmix64 finalizer spreads FNV's weak high bits
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// add counts visitor in s
+func (s *sketch) add(visitor string) {
+	h := hashVisitor(visitor)
+	idx := uint16(h >> (64 - sketchPrecision))
+	// the guard bit caps the rank when the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(h<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	s.set(idx, rank)
+}
+
+// set raises register idx to rank
+func (s *sketch) set(idx uint16, rank uint8) {
+	if s.registers != nil {
+		s.registers[idx] = max(s.registers[idx], rank)
+		return
+	}
+	if rank <= s.sparse[idx] {
+		return
+	}
+	if s.sparse == nil {
+		s.sparse = make(map[uint16]uint8)
+	}
+	s.sparse[idx] = rank
+	if len(s.sparse) > sketchSparseMax {
+		s.registers = make([]uint8, sketchRegisters)
+		for i, r := range s.sparse {
+			s.registers[i] = r
+		}
+		s.sparse = nil
+	}
+}
+
+// each calls fn for every non-zero register of s
+func (s *sketch) each(fn func(idx uint16, rank uint8)) {
+	if s.registers == nil {
+		for i, r := range s.sparse {
+			fn(i, r)
+		}
+		return
+	}
+	for i, r := range s.registers {
+		if r != 0 {
+			fn(uint16(i), r)
+		}
+	}
+}
+
+// merge adds the visitors counted in o to s
+func (s *sketch) merge(o *sketch) {
+	o.each(s.set)
+}
+
+// clone returns a copy of s that does not share memory with it
+func (s *sketch) clone() *sketch {
+	c := &sketch{}
+	c.merge(s)
+	return c
+}
+
+// estimate returns the approximate number of distinct visitors in s
+func (s *sketch) estimate() int {
+	zeros := sketchRegisters
+	sum := 0.0
+	s.each(func(_ uint16, rank uint8) {
+		zeros--
+		sum += math.Ldexp(1, -int(rank))
+	})
+	if zeros == sketchRegisters {
+		return 0
+	}
+	sum += float64(zeros)
+
+	const m = float64(sketchRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small sets
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// marshal encodes s for storage: small sketches as (register, rank) pairs,
+// others as the array of all registers
+func (s *sketch) marshal() []byte {
+	if s.registers != nil {
+		return append([]byte{sketchDense}, s.registers...)
+	}
+	idxs := make([]uint16, 0, len(s.sparse))
+	for i := range s.sparse {
+		idxs = append(idxs, i)
+	}
+	slices.Sort(idxs)
+	b := make([]byte, 1, 1+3*len(idxs))
+	b[0] = sketchSparse
+	for _, i := range idxs {
+		b = binary.BigEndian.AppendUint16(b, i)
+		b = append(b, s.sparse[i])
+	}
+	return b
+}
+
+// unmarshalSketch decodes a sketch encoded by marshal. Ranks add could
+// not have set are refused, as they would skew every estimate merged with
+// the sketch.
+func unmarshalSketch(b []byte) (*sketch, error) {
+	s := &sketch{}
+	switch {
+	case len(b) == 1+sketchRegisters && b[0] == sketchDense:
+		for i, r := range b[1:] {
+			if r > sketchMaxRank {
+				return nil, errBadSketch
+			}
+			if r != 0 {
+				s.set(uint16(i), r)
+			}
+		}
+	case len(b) > 0 && (len(b)-1)%3 == 0 && b[0] == sketchSparse:
+		for p := b[1:]; len(p) > 0; p = p[3:] {
+			i := binary.BigEndian.Uint16(p)
+			if i >= sketchRegisters || p[2] == 0 || p[2] > sketchMaxRank {
+				return nil, errBadSketch
+			}
+			s.set(i, p[2])
+		}
+	default:
+		return nil, errBadSketch
+	}
+	return s, nil
+}
+
+// daySketch is the sketch of the visitors of one link on one UTC day
+type daySketch struct {
+	Code   string
+	Day    time.Time
+	Sketch *sketch
+}
+
+// sketchClicks counts the visitors of clicks, bots left out, per link and
+// UTC day. Sketches are ordered by code and day, so concurrent writers
+// update them in the same order.
+func sketchClicks(clicks []Click) []daySketch {
+	type key struct {
+		code string
+		day  int64
+	}
+	days := make(map[key]*daySketch)
+	for _, c := range clicks {
+		if c.Bot {
+			continue
+		}
+		day := BucketStart(IntervalDay, c.At)
+		k := key{c.Code, day.Unix()}
+		if days[k] == nil {
+			days[k] = &daySketch{Code: c.Code, Day: day, Sketch: &sketch{}}
+		}
+		days[k].Sketch.add(c.visitor())
+	}
+
+	sketches := make([]daySketch, 0, len(days))
+	for _, d := range days {
+		sketches = append(sketches, *d)
+	}
+	slices.SortFunc(sketches, func(a, b daySketch) int {
+		return cmp.Or(cmp.Compare(a.Code, b.Code), a.Day.Compare(b.Day))
+	})
+	return sketches
+}
+
+// mergeStoredSketch merges s into a stored sketch and encodes the result
+func mergeStoredSketch(stored []byte, s *sketch) ([]byte, error) {
+	merged, err := unmarshalSketch(stored)
+	if err != nil {
+		return nil, err
+	}
+	merged.merge(s)
+	return merged.marshal(), nil
+}
+
+// uniqueWeekDays is how many UTC days, today included, ClickStats.UniqueWeek
+// covers
+const uniqueWeekDays = 7
+
+// setUniques fills the unique visitor estimates of stats from the daily
+// sketches of the link
+func (stats *ClickStats) setUniques(days []daySketch, now time.Time) {
+	slices.SortFunc(days, func(a, b daySketch) int { return a.Day.Compare(b.Day) })
+	weekStart := BucketStart(IntervalDay, now).AddDate(0, 0, 1-uniqueWeekDays)
+	all, week := &sketch{}, &sketch{}
+	stats.UniqueDays = make([]StatCount, 0, len(days))
+	for _, d := range days {
+		all.merge(d.Sketch)
+		if !d.Day.Before(weekStart) {
+			week.merge(d.Sketch)
+		}
+		stats.UniqueDays = append(stats.UniqueDays, StatCount{Key: d.Day.UTC().Format(dayLayout), Count: d.Sketch.estimate()})
+	}
+	stats.Unique = all.estimate()
+	stats.UniqueWeek = week.estimate()
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketchEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 100000} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			s := &sketch{}
+			for i := range n {
+				s.add(fmt.Sprintf("10.0.%d.0|visitor %d", i%256, i))
+				// повторный визит не меняет оценку
+				s.add(fmt.Sprintf("10.0.%d.0|visitor %d", i%256, i))
+			}
+			if n <= 10 {
+				assert.Equal(t, n, s.estimate())
+				return
+			}
+			assert.InEpsilon(t, n, s.estimate(), 0.05)
+		})
+	}
+}
+
+func TestSketchMergeAndMarshal(t *testing.T) {
+	a, b := &sketch{}, &sketch{}
+	for i := range 3000 {
+		a.add(fmt.Sprint("a", i))
+		b.add(fmt.Sprint("a", i+1500))
+	}
+	a.merge(b)
+	assert.InEpsilon(t, 4500, a.estimate(), 0.05)
+
+	for _, s := range []*sketch{{}, b, a} {
+		got, err := unmarshalSketch(s.marshal())
+		require.NoError(t, err)
+		assert.Equal(t, s.estimate(), got.estimate())
+	}
+	small := &sketch{}
+	small.add("one")
+	assert.Len(t, small.marshal(), 4, "small sketches are stored sparse")
+
+	for _, b := range [][]byte{nil, {sketchDense, 1}, {sketchSparse, 0xff, 0xff, 1}, {9}} {
+		_, err := unmarshalSketch(b)
+		assert.ErrorIs(t, err, errBadSketch)
+	}
+}
+
+func TestUnmarshalSketchRanks(t *testing.T) {
+	dense := func(rank uint8) []byte {
+		b := make([]byte, 1+sketchRegisters)
+		b[0], b[1+7] = sketchDense, rank
+		return b
+	}
+	tests := []struct {
+		name string
+		b    []byte
+		ok   bool
+	}{
+		{"dense, highest rank", dense(sketchMaxRank), true},
+		{"dense, rank too high", dense(sketchMaxRank + 1), false},
+		{"dense, rank 255", dense(0xff), false},
+		{"sparse, highest rank", []byte{sketchSparse, 0, 7, sketchMaxRank}, true},
+		{"sparse, rank too high", []byte{sketchSparse, 0, 7, sketchMaxRank + 1}, false},
+		{"sparse, zero rank", []byte{sketchSparse, 0, 7, 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := unmarshalSketch(tt.b)
+			if !tt.ok {
+				assert.ErrorIs(t, err, errBadSketch)
+				return
+			}
+			require.NoError(t, err)
+			assert.Positive(t, s.estimate())
+		})
+	}
+
+	// the guard bit keeps every rank add sets within the limit
+	s := &sketch{}
+	for i := range 100_000 {
+		s.add(fmt.Sprint("v", i))
+	}
+	_, err := unmarshalSketch(s.marshal())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"go.uber.org/zap"
+)
+
+// urlColumns are the columns scanURL expects, in order
+const urlColumns = "code, url, input_url, user_id, is_deleted, expires_at, max_clicks, clicks, redirect_type"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanURL scans urlColumns
+func scanURL(row rowScanner) (URL, error) {
+	var url URL
+	var input sql.NullString
+	var userID sql.NullInt64
+	var expiresAt sql.NullTime
+	var maxClicks sql.NullInt64
+	var redirectType sql.NullInt64
+	if err := row.Scan(&url.Code, &url.URL, &input, &userID, &url.isDeleted, &expiresAt, &maxClicks, &url.Clicks, &redirectType); err != nil {
+		return URL{}, err
+	}
+	url.Input = input.String
+	url.UserID = int(userID.Int64)
+	url.MaxClicks = int(maxClicks.Int64)
+	url.RedirectType = int(redirectType.Int64)
+	if expiresAt.Valid {
+		url.ExpiresAt = expiresAt.Time
+	}
+	return url, nil
+}
+
+// nullUserID stores the anonymous user 0 as NULL
+func nullUserID(userID int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+}
+
+// nullString stores the empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullMaxClicks stores the unlimited 0 as NULL
+func nullMaxClicks(maxClicks int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(maxClicks), Valid: maxClicks != 0}
+}
+
+// nullRedirectType stores the server default 0 as NULL
+func nullRedirectType(redirectType int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(redirectType), Valid: redirectType != 0}
+}
+
+// nullDedupKey stores the key of a link outside deduplication as NULL,
+// which the unique index never compares
+func nullDedupKey(scope string, userID int, url string) sql.NullString {
+	key, ok := dedupKey(scope, userID, url)
+	return sql.NullString{String: key, Valid: ok}
+}
+
+// nullTime stores the zero time, meaning "never", as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// scanVersions reads the rows of a history query: previous targets with
+// their input ordered by version, followed by the current target with NULL
+// version and replaced_at
+func scanVersions(rows *sql.Rows) ([]URLVersion, error) {
+	var versions []URLVersion
+	for rows.Next() {
+		var v URLVersion
+		var version sql.NullInt64
+		var input sql.NullString
+		var replacedAt sql.NullTime
+		if err := rows.Scan(&version, &v.URL, &input, &replacedAt); err != nil {
+			return nil, err
+		}
+		v.Input = input.String
+		v.Version = int(version.Int64)
+		if !version.Valid {
+			v.Version = len(versions) + 1
+		}
+		if replacedAt.Valid {
+			v.ReplacedAt = replacedAt.Time
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// scanCodes reads a single column of codes
+func scanCodes(rows *sql.Rows) ([]string, error) {
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+// clickStatsQuery builds the query scanClickStats reads: one row per total,
+// bot total and breakdown entry, as (kind, key, count), for
+// the code in the first parameter. Totals and days come from the daily
+// rollups; day is the dialect's expression for the UTC date of bucket_start.
+func clickStatsQuery(day string, param string) string {
+	human := `FROM clicks WHERE code = ` + param + ` AND NOT is_bot`
+	daily := `FROM click_rollups WHERE code = ` + param + ` AND bucket_interval = 'day'`
+	return `
+        SELECT 'total', '', COALESCE(SUM(clicks), 0) ` + daily + `
+        UNION ALL
+        SELECT 'bots', '', COALESCE(SUM(bots), 0) ` + daily + `
+        UNION ALL
+        SELECT 'referrer', referrer, COUNT(*) ` + human + ` GROUP BY referrer
+        UNION ALL
+        SELECT 'country', country, COUNT(*) ` + human + ` GROUP BY country
+        UNION ALL
+        SELECT 'region', region, COUNT(*) ` + human + ` GROUP BY region
+        UNION ALL
+        SELECT 'asn', CASE WHEN asn = 0 THEN '' ELSE CAST(asn AS TEXT) END, COUNT(*) ` + human + ` GROUP BY asn
+        UNION ALL
+        SELECT 'day', ` + day + `, clicks ` + daily + ` AND clicks > 0;
+    `
+}
+
+// scanClickStats reads the rows of clickStatsQuery
+func scanClickStats(rows *sql.Rows) (ClickStats, error) {
+	var total, bots int
+	breakdowns := make(map[string]map[string]int)
+	for rows.Next() {
+		var kind, key string
+		var count int
+		if err := rows.Scan(&kind, &key, &count); err != nil {
+			return ClickStats{}, err
+		}
+		switch kind {
+		case "total":
+			total = count
+		case "bots":
+			bots = count
+		default:
+			if breakdowns[kind] == nil {
+				breakdowns[kind] = make(map[string]int)
+			}
+			breakdowns[kind][key] = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ClickStats{}, err
+	}
+	return newClickStats(total, bots, breakdowns), nil
+}
+
+// scanSketches reads (day, sketch) rows of the link with code
+func scanSketches(rows *sql.Rows, code string) ([]daySketch, error) {
+	var sketches []daySketch
+	for rows.Next() {
+		var day time.Time
+		var b []byte
+		if err := rows.Scan(&day, &b); err != nil {
+			return nil, err
+		}
+		s, err := unmarshalSketch(b)
+		if err != nil {
+			return nil, fmt.Errorf("sketch of %s on %s: %w", code, day.UTC().Format(dayLayout), err)
+		}
+		sketches = append(sketches, daySketch{Code: code, Day: day.UTC(), Sketch: s})
+	}
+	return sketches, rows.Err()
+}
+
+// scanSeries reads (bucket start, clicks, bots) rows
+func scanSeries(rows *sql.Rows) ([]SeriesPoint, error) {
+	var points []SeriesPoint
+	for rows.Next() {
+		var p SeriesPoint
+		if err := rows.Scan(&p.At, &p.Clicks, &p.Bots); err != nil {
+			return nil, err
+		}
+		p.At = p.At.UTC()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// scanLinkCounts reads (code, url, user id, clicks) rows
+func scanLinkCounts(rows *sql.Rows) ([]LinkCount, error) {
+	var counts []LinkCount
+	for rows.Next() {
+		var c LinkCount
+		var userID sql.NullInt64
+		if err := rows.Scan(&c.Code, &c.URL, &userID, &c.Clicks); err != nil {
+			return nil, err
+		}
+		c.UserID = int(userID.Int64)
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// rekeyStatements are the statements rekeyDedup runs in the SQL dialect of
+// a store
+type rekeyStatements struct {
+	// getScope reads the scope the stored keys were computed for and keeps
+	// other instances from rekeying at the same time
+	getScope string
+	// setScope stores the scope (1 parameter)
+	setScope string
+	// setKey sets the key of the link (key, code)
+	setKey string
+}
+
+// rekeyDedup recomputes the dedup keys of all links when they were computed
+// for another scope than scope. Links get their keys oldest first, so when
+// the new scope makes links collide the oldest one keeps the key. Expired
+// and used up links get none, as after a create that took their URL over.
+func rekeyDedup(ctx context.Context, db *sql.DB, scope string, stmts rekeyStatements) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored string
+	if err := tx.QueryRowContext(ctx, stmts.getScope).Scan(&stored); err != nil {
+		return fmt.Errorf("read dedup scope: %w", err)
+	}
+	if stored == scope {
+		return nil
+	}
+
+	type keyed struct{ code, key string }
+	var keys []keyed
+	taken := make(map[string]bool)
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, "SELECT "+urlColumns+" FROM urls ORDER BY id")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		key, ok := dedupKey(scope, u.UserID, u.URL)
+		if !ok || u.dead(now) || taken[key] {
+			continue
+		}
+		taken[key] = true
+		keys = append(keys, keyed{code: u.Code, key: key})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE urls SET dedup_key = NULL"); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, stmts.setKey)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, k := range keys {
+		if _, err := stmt.ExecContext(ctx, k.key, k.code); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, stmts.setScope, scope); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logger.Log.Info("dedup keys recomputed", zap.String("from", stored), zap.String("to", scope), zap.Int("keys", len(keys)))
+	return nil
+}
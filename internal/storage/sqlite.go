@@ -0,0 +1,790 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+)
+
+// sqliteMigrationsDir is the subdirectory of MigrationsPath with the SQLite schema
+const sqliteMigrationsDir = "sqlite"
+
+// SQLiteStorage is SQLite implementation of the Storage interface
+// generate:reset
+type SQLiteStorage struct {
+	DB    *sql.DB
+	dedup string
+}
+
+func init() {
+	Register("sqlite", func(dsn string, cfg *config.Config) (Storage, error) {
+		return NewSQLiteStorage(DSNPath(dsn), cfg)
+	})
+}
+
+// sqliteRekey needs no row lock: the transaction holds the database lock
+var sqliteRekey = rekeyStatements{
+	getScope: "SELECT value FROM storage_settings WHERE name = 'dedup_scope'",
+	setScope: "UPDATE storage_settings SET value = ? WHERE name = 'dedup_scope'",
+	setKey:   "UPDATE urls SET dedup_key = ? WHERE code = ?",
+}
+
+// NewSQLiteStorage opens the database file at path and migrates it
+func NewSQLiteStorage(path string, cfg *config.Config) (*SQLiteStorage, error) {
+	dedup, err := parseDedupScope(cfg.DedupScope)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := sqliteDSN(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationsPath := filepath.Join(cfg.MigrationsPath, sqliteMigrationsDir)
+	if err := runSQLiteMigrations(db, migrationsPath); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	if err := rekeyDedup(context.Background(), db, dedup, sqliteRekey); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to recompute dedup keys: %w", sqliteError(err))
+	}
+
+	return &SQLiteStorage{DB: db, dedup: dedup}, nil
+}
+
+// sqliteDSN enables WAL journaling so readers do not block the writer,
+// waits for locks instead of failing with SQLITE_BUSY and turns on
+// foreign keys, which SQLite leaves off by default. Parameters already in
+// path are kept and their pragmas run after ours. Transactions always take
+// the write lock when they begin: read-then-write transactions such as
+// UpdateURL rely on it, with a deferred lock concurrent ones would fail.
+func sqliteDSN(path string) (string, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(path, "file:"), "?")
+	own, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid sqlite dsn parameters: %w", err)
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "foreign_keys(1)")
+	for key, values := range own {
+		if key == "_pragma" {
+			params[key] = append(params[key], values...)
+		} else {
+			params[key] = values
+		}
+	}
+	params.Set("_txlock", "immediate")
+	return "file:" + path + "?" + params.Encode(), nil
+}
+
+func runSQLiteMigrations(db *sql.DB, migrationsPath string) error {
+	driver, err := migratesqlite.WithInstance(db, &migratesqlite.Config{})
+	if err != nil {
+		return fmt.Errorf("could not create migrate driver: %w", err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance(
+		"file://"+migrationsPath,
+		"sqlite",
+		driver,
+	)
+	if err != nil {
+		return fmt.Errorf("could not create migrate instance: %w", err)
+	}
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	return nil
+}
+
+// sqliteError converts driver errors into storage errors: unique and
+// foreign key violations become sentinel errors, a locked database and
+// timeouts become TransientError
+func sqliteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		if isConnectionError(err) {
+			return transient(err)
+		}
+		return err
+	}
+	switch code := sqliteErr.Code(); {
+	case code == sqlite3.SQLITE_CONSTRAINT_UNIQUE && strings.Contains(sqliteErr.Error(), "urls.code"):
+		return ErrCodeAlreadyExists
+	case code == sqlite3.SQLITE_CONSTRAINT_UNIQUE && strings.Contains(sqliteErr.Error(), "urls.dedup_key"):
+		return ErrURLAlreadyExists
+	case code == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return ErrUserNotFound
+	case code&0xff == sqlite3.SQLITE_BUSY, code&0xff == sqlite3.SQLITE_LOCKED:
+		return transient(err)
+	}
+	return err
+}
+
+// SaveURL save a URL by code in DB
+func (store *SQLiteStorage) SaveURL(ctx context.Context, u URL) error {
+	return store.SaveBatchURL(ctx, []URL{u})
+}
+
+// GetURL get URL by code from DB
+func (store *SQLiteStorage) GetURL(ctx context.Context, code string) (URL, error) {
+	row := store.DB.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE code = ?", code)
+	url, err := scanURL(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+		}
+		return URL{}, sqliteError(err)
+	}
+	if err := url.check(time.Now()); err != nil {
+		return URL{}, err
+	}
+	return url, nil
+}
+
+// FollowURL get URL by code from DB and spend one of its clicks.
+// Limited links are checked and spent in one transaction, which SQLite
+// starts with the write lock held (_txlock=immediate), so concurrent
+// redirects cannot both take the last click, nor spend one on a link that
+// was deleted or expired in between. Unlimited links are only read.
+func (store *SQLiteStorage) FollowURL(ctx context.Context, code string) (URL, error) {
+	url, err := store.GetURL(ctx, code)
+	if err != nil || url.MaxClicks == 0 {
+		return url, err
+	}
+
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return URL{}, sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	url, err = scanURL(tx.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE code = ?", code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+		}
+		return URL{}, sqliteError(err)
+	}
+	if err := url.check(time.Now()); err != nil {
+		return URL{}, err
+	}
+	query := "UPDATE urls SET clicks = clicks + 1 WHERE code = ? AND is_deleted = FALSE AND clicks < max_clicks RETURNING " + urlColumns
+	url, err = scanURL(tx.QueryRowContext(ctx, query, code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLExhausted)
+		}
+		return URL{}, sqliteError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return URL{}, sqliteError(err)
+	}
+	return url, nil
+}
+
+// GetByURL get the link that keeps the user from shortening url again
+func (store *SQLiteStorage) GetByURL(ctx context.Context, userID int, url string) (URL, error) {
+	key, ok := dedupKey(store.dedup, userID, url)
+	if !ok {
+		return URL{}, fmt.Errorf("url with url %s: %w", url, ErrURLNotFound)
+	}
+	row := store.DB.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE dedup_key = ?", key)
+	u, err := scanURL(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, fmt.Errorf("url with url %s: %w", url, ErrURLNotFound)
+		}
+		return URL{}, sqliteError(err)
+	}
+	if err := u.check(time.Now()); err != nil {
+		return URL{}, err
+	}
+	return u, nil
+}
+
+// Close releases resources
+func (store *SQLiteStorage) Close() error {
+	return store.DB.Close()
+}
+
+// Ping DB
+func (store *SQLiteStorage) Ping(ctx context.Context) error {
+	return sqliteError(store.DB.PingContext(ctx))
+}
+
+// AllURLs streams all URLs. SQLite steps through the result lazily,
+// so rows are read from disk as the iterator advances.
+func (store *SQLiteStorage) AllURLs(ctx context.Context) iter.Seq2[URL, error] {
+	query := "SELECT " + urlColumns + " FROM urls ORDER BY id"
+	return streamRows(ctx, store.DB, query, func(rows *sql.Rows) (URL, error) {
+		return scanURL(rows)
+	})
+}
+
+// SaveBatchURL save list of URL in a single transaction
+func (store *SQLiteStorage) SaveBatchURL(ctx context.Context, urls []URL) error {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO urls (code, url, input_url, user_id, expires_at, max_clicks, redirect_type, dedup_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer stmt.Close()
+
+	for _, url := range urls {
+		if err := store.releaseDeadKey(ctx, tx, url.UserID, url.URL); err != nil {
+			return sqliteError(err)
+		}
+		if _, err := stmt.ExecContext(ctx, url.Code, url.URL, nullString(url.Input), nullUserID(url.UserID), nullTime(url.ExpiresAt), nullMaxClicks(url.MaxClicks), nullRedirectType(url.RedirectType), nullDedupKey(store.dedup, url.UserID, url.URL)); err != nil {
+			return sqliteError(err)
+		}
+	}
+	return sqliteError(tx.Commit())
+}
+
+// releaseDeadKey takes the dedup key of url away from the link holding it
+// when that link is expired or used up
+func (store *SQLiteStorage) releaseDeadKey(ctx context.Context, tx *sql.Tx, userID int, url string) error {
+	key, ok := dedupKey(store.dedup, userID, url)
+	if !ok {
+		return nil
+	}
+	holder, err := scanURL(tx.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE dedup_key = ?", key))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !holder.dead(time.Now()) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE urls SET dedup_key = NULL WHERE code = ?", holder.Code)
+	return err
+}
+
+// CreateUser creates a new user and returns it
+func (store *SQLiteStorage) CreateUser(ctx context.Context) (User, error) {
+	var id int
+	err := store.DB.QueryRowContext(ctx, "INSERT INTO users DEFAULT VALUES RETURNING id").Scan(&id)
+	if err != nil {
+		return User{}, sqliteError(err)
+	}
+	return User{ID: id}, nil
+}
+
+// GetUser returns the user with id
+func (store *SQLiteStorage) GetUser(ctx context.Context, id int) (User, error) {
+	var user User
+	err := store.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ?", id).Scan(&user.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+		}
+		return User{}, sqliteError(err)
+	}
+	return user, nil
+}
+
+// GetURLsByUserID returns all URLs associated with a specific user ID
+func (store *SQLiteStorage) GetURLsByUserID(ctx context.Context, userID int) ([]URL, error) {
+	rows, err := store.DB.QueryContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE user_id = ? AND is_deleted = FALSE", userID)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var urls []URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		urls = append(urls, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return urls, nil
+}
+
+// GetAllUsers streams all users
+func (store *SQLiteStorage) GetAllUsers(ctx context.Context) iter.Seq2[User, error] {
+	return streamRows(ctx, store.DB, "SELECT id FROM users ORDER BY id", func(rows *sql.Rows) (User, error) {
+		var user User
+		err := rows.Scan(&user.ID)
+		return user, err
+	})
+}
+
+// LeaseIDs reserves size IDs from the code_id_lease counter
+func (store *SQLiteStorage) LeaseIDs(ctx context.Context, size int) (int64, error) {
+	if size <= 0 {
+		return 0, ErrInvalidLeaseSize
+	}
+	var start int64
+	query := "UPDATE code_id_lease SET next_id = next_id + ? WHERE id = 1 RETURNING next_id - ?"
+	if err := store.DB.QueryRowContext(ctx, query, size, size).Scan(&start); err != nil {
+		return 0, sqliteError(err)
+	}
+	return start, nil
+}
+
+// DeleteUserURLs marks user's URLs as deleted.
+// Security: ensures only URLs belonging to the given userID are affected.
+func (store *SQLiteStorage) DeleteUserURLs(ctx context.Context, userID int, codes []string) error {
+	codesJSON, err := json.Marshal(codes)
+	if err != nil {
+		return err
+	}
+	query := `
+        UPDATE urls
+        SET is_deleted = TRUE, deleted_at = ?
+        WHERE user_id = ? AND code IN (SELECT value FROM json_each(?)) AND is_deleted = FALSE;
+    `
+	_, err = store.DB.ExecContext(ctx, query, time.Now().UTC(), userID, string(codesJSON))
+	return sqliteError(err)
+}
+
+// RestoreUserURLs undeletes the user's links deleted after since
+func (store *SQLiteStorage) RestoreUserURLs(ctx context.Context, userID int, codes []string, since time.Time) ([]string, error) {
+	codesJSON, err := json.Marshal(codes)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+        UPDATE urls
+        SET is_deleted = FALSE, deleted_at = NULL
+        WHERE user_id = ? AND code IN (SELECT value FROM json_each(?)) AND is_deleted = TRUE AND deleted_at > ?
+        RETURNING code;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, userID, string(codesJSON), since.UTC())
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	restored, err := scanCodes(rows)
+	return restored, sqliteError(err)
+}
+
+// PurgeDeletedURLs permanently removes links deleted before before.
+// Their history goes with them by ON DELETE CASCADE. Deletion times are
+// kept in UTC, so they compare correctly as text.
+func (store *SQLiteStorage) PurgeDeletedURLs(ctx context.Context, before time.Time) (int, error) {
+	res, err := store.DB.ExecContext(ctx, "DELETE FROM urls WHERE is_deleted = TRUE AND deleted_at < ?", before.UTC())
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), sqliteError(err)
+}
+
+// UpdateURL applies edit to the user's link in one transaction. It moves a
+// replaced target to url_history, so history and link never disagree, and
+// a refused target leaves the redirect status as it was. It holds the
+// write lock from the start (see sqliteDSN), so concurrent edits take
+// their turns instead of numbering the same version.
+func (store *SQLiteStorage) UpdateURL(ctx context.Context, userID int, code string, edit URLEdit) (URL, error) {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return URL{}, sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	current, err := scanURL(tx.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM urls WHERE code = ? AND user_id = ?", code, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return URL{}, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+		}
+		return URL{}, sqliteError(err)
+	}
+	if current.isDeleted {
+		return URL{}, ErrURLDeleted
+	}
+	changeURL := edit.URL != "" && edit.URL != current.URL
+	// the same target spelled another way keeps its version
+	changeInput := edit.URL == current.URL && edit.Input != current.Input
+	changeType := edit.RedirectType != nil && *edit.RedirectType != current.RedirectType
+	if !changeURL && !changeInput && !changeType {
+		return current, nil
+	}
+	updated := current
+	if changeURL {
+		if updated, err = store.replaceTarget(ctx, tx, current, edit); err != nil {
+			return URL{}, err
+		}
+	}
+	if changeInput {
+		query := "UPDATE urls SET input_url = ? WHERE code = ? RETURNING " + urlColumns
+		if updated, err = scanURL(tx.QueryRowContext(ctx, query, nullString(edit.Input), code)); err != nil {
+			return URL{}, sqliteError(err)
+		}
+	}
+	if changeType {
+		query := "UPDATE urls SET redirect_type = ? WHERE code = ? RETURNING " + urlColumns
+		if updated, err = scanURL(tx.QueryRowContext(ctx, query, nullRedirectType(*edit.RedirectType), code)); err != nil {
+			return URL{}, sqliteError(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return URL{}, sqliteError(err)
+	}
+	return updated, nil
+}
+
+// replaceTarget points the link current at the target of edit within tx
+// and moves the previous target to url_history
+func (store *SQLiteStorage) replaceTarget(ctx context.Context, tx *sql.Tx, current URL, edit URLEdit) (URL, error) {
+	code := current.Code
+	query := `
+        INSERT INTO url_history (code, version, url, input_url, replaced_at)
+        SELECT ?1, COALESCE(MAX(version), 0) + 1, ?2, ?3, ?4 FROM url_history WHERE code = ?1;
+    `
+	if _, err := tx.ExecContext(ctx, query, code, current.URL, nullString(current.Input), time.Now()); err != nil {
+		return URL{}, sqliteError(err)
+	}
+	if err := store.releaseDeadKey(ctx, tx, current.UserID, edit.URL); err != nil {
+		return URL{}, sqliteError(err)
+	}
+	updated, err := scanURL(tx.QueryRowContext(ctx, "UPDATE urls SET url = ?, input_url = ?, dedup_key = ? WHERE code = ? RETURNING "+urlColumns, edit.URL, nullString(edit.Input), nullDedupKey(store.dedup, current.UserID, edit.URL), code))
+	return updated, sqliteError(err)
+}
+
+// URLHistory returns every target of the user's link, oldest first.
+// A single statement reads history and link, so they match.
+func (store *SQLiteStorage) URLHistory(ctx context.Context, userID int, code string) ([]URLVersion, error) {
+	query := `
+        SELECT h.version, h.url, h.input_url, h.replaced_at
+        FROM url_history h JOIN urls u ON u.code = h.code
+        WHERE h.code = ?1 AND u.user_id = ?2
+        UNION ALL
+        SELECT NULL, url, input_url, NULL FROM urls WHERE code = ?1 AND user_id = ?2
+        ORDER BY 1 NULLS LAST;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, code, userID)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	versions, err := scanVersions(rows)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	return versions, nil
+}
+
+// sqliteTimeLayout is a time format SQLite date functions understand
+const sqliteTimeLayout = "2006-01-02 15:04:05.000"
+
+// sqliteTime formats t in UTC for columns that SQLite date functions read
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+// SaveClicks records clicks of existing links in one transaction
+func (store *SQLiteStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO clicks (code, ts, referrer, user_agent, ip, is_bot, country, region, asn)
+        SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9 WHERE EXISTS (SELECT 1 FROM urls WHERE code = ?1);
+    `
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer stmt.Close()
+
+	for _, c := range clicks {
+		if _, err := stmt.ExecContext(ctx, c.Code, sqliteTime(c.At), c.Referrer, c.UserAgent, c.IP, c.Bot, c.Country, c.Region, c.ASN); err != nil {
+			return sqliteError(err)
+		}
+	}
+
+	rollupQuery := `
+        INSERT INTO click_rollups (code, bucket_interval, bucket_start, clicks, bots)
+        SELECT ?1, ?2, ?3, ?4, ?5 WHERE EXISTS (SELECT 1 FROM urls WHERE code = ?1)
+        ON CONFLICT (code, bucket_interval, bucket_start)
+        DO UPDATE SET clicks = click_rollups.clicks + excluded.clicks, bots = click_rollups.bots + excluded.bots;
+    `
+	rollupStmt, err := tx.PrepareContext(ctx, rollupQuery)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer rollupStmt.Close()
+
+	for _, r := range rollupClicks(clicks) {
+		if _, err := rollupStmt.ExecContext(ctx, r.Code, r.Interval, sqliteTime(r.Start), r.Clicks, r.Bots); err != nil {
+			return sqliteError(err)
+		}
+	}
+
+	if err := store.saveSketches(ctx, tx, sketchClicks(clicks)); err != nil {
+		return err
+	}
+	return sqliteError(tx.Commit())
+}
+
+// saveSketches merges daily visitor sketches into the stored ones; the
+// write transaction keeps other writers out in between
+func (store *SQLiteStorage) saveSketches(ctx context.Context, tx *sql.Tx, sketches []daySketch) error {
+	for _, s := range sketches {
+		day := sqliteTime(s.Day)
+		var stored []byte
+		err := tx.QueryRowContext(ctx, "SELECT sketch FROM click_sketches WHERE code = ? AND day = ?", s.Code, day).Scan(&stored)
+		encoded := s.Sketch.marshal()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return sqliteError(err)
+		default:
+			if encoded, err = mergeStoredSketch(stored, s.Sketch); err != nil {
+				return fmt.Errorf("sketch of %s: %w", s.Code, err)
+			}
+		}
+
+		query := `
+            INSERT INTO click_sketches (code, day, sketch)
+            SELECT ?1, ?2, ?3 WHERE EXISTS (SELECT 1 FROM urls WHERE code = ?1)
+            ON CONFLICT (code, day) DO UPDATE SET sketch = excluded.sketch;
+        `
+		if _, err := tx.ExecContext(ctx, query, s.Code, day, encoded); err != nil {
+			return sqliteError(err)
+		}
+	}
+	return nil
+}
+
+// checkOwner returns ErrURLNotFound unless the link with code is the user's
+func (store *SQLiteStorage) checkOwner(ctx context.Context, userID int, code string) error {
+	var exists bool
+	err := store.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM urls WHERE code = ? AND user_id = ?)", code, userID).Scan(&exists)
+	if err != nil {
+		return sqliteError(err)
+	}
+	if !exists {
+		return fmt.Errorf("url with code %s: %w", code, ErrURLNotFound)
+	}
+	return nil
+}
+
+// URLStats summarizes the clicks of the user's link
+func (store *SQLiteStorage) URLStats(ctx context.Context, userID int, code string) (ClickStats, error) {
+	if err := store.checkOwner(ctx, userID, code); err != nil {
+		return ClickStats{}, err
+	}
+
+	rows, err := store.DB.QueryContext(ctx, clickStatsQuery("date(bucket_start)", "?1"), code)
+	if err != nil {
+		return ClickStats{}, sqliteError(err)
+	}
+	defer rows.Close()
+
+	stats, err := scanClickStats(rows)
+	if err != nil {
+		return ClickStats{}, sqliteError(err)
+	}
+
+	rows, err = store.DB.QueryContext(ctx, "SELECT day, sketch FROM click_sketches WHERE code = ?", code)
+	if err != nil {
+		return ClickStats{}, sqliteError(err)
+	}
+	defer rows.Close()
+
+	sketches, err := scanSketches(rows, code)
+	if err != nil {
+		return ClickStats{}, sqliteError(err)
+	}
+	stats.setUniques(sketches, time.Now())
+	return stats, nil
+}
+
+// ClickSeries sums the interval buckets of the user's links
+func (store *SQLiteStorage) ClickSeries(ctx context.Context, userID int, code string, interval string, from, to time.Time) ([]SeriesPoint, error) {
+	if code != "" {
+		if err := store.checkOwner(ctx, userID, code); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+        SELECT r.bucket_start, SUM(r.clicks), SUM(r.bots)
+        FROM click_rollups r JOIN urls u ON u.code = r.code
+        WHERE u.user_id = ?1 AND r.bucket_interval = ?2
+            AND r.bucket_start >= ?3 AND r.bucket_start < ?4
+            AND (?5 = '' OR r.code = ?5)
+        GROUP BY r.bucket_start
+        ORDER BY r.bucket_start;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, userID, interval, sqliteTime(from), sqliteTime(to), code)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	points, err := scanSeries(rows)
+	return points, sqliteError(err)
+}
+
+// DownsampleClicks removes raw clicks and hourly rollups older than before
+func (store *SQLiteStorage) DownsampleClicks(ctx context.Context, before time.Time) (int, error) {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM clicks WHERE ts < ?", sqliteTime(before))
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM click_rollups WHERE bucket_interval = ? AND bucket_start <= ?", IntervalHour, sqliteTime(before.Add(-time.Hour)))
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	return int(n), sqliteError(tx.Commit())
+}
+
+// SaveTrending adds counts to the trending counters of existing links in
+// one transaction
+func (store *SQLiteStorage) SaveTrending(ctx context.Context, counts []TrendingCount, expired time.Time) error {
+	tx, err := store.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO click_trending (code, minute, clicks)
+        SELECT ?1, ?2, ?3 WHERE EXISTS (SELECT 1 FROM urls WHERE code = ?1)
+        ON CONFLICT (code, minute)
+        DO UPDATE SET clicks = click_trending.clicks + excluded.clicks;
+    `
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer stmt.Close()
+
+	for _, c := range counts {
+		if _, err := stmt.ExecContext(ctx, c.Code, sqliteTime(c.Minute), c.Clicks); err != nil {
+			return sqliteError(err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM click_trending WHERE minute < ?", sqliteTime(expired)); err != nil {
+		return sqliteError(err)
+	}
+	return sqliteError(tx.Commit())
+}
+
+// TopLinks sums the trending counters of the user's links since since
+func (store *SQLiteStorage) TopLinks(ctx context.Context, userID int, since time.Time, limit int) ([]LinkCount, error) {
+	query := `
+        SELECT t.code, u.url, u.user_id, SUM(t.clicks) AS total
+        FROM click_trending t JOIN urls u ON u.code = t.code
+        WHERE t.minute >= ?1 AND NOT u.is_deleted AND (?2 = 0 OR u.user_id = ?2)
+        GROUP BY t.code, u.url, u.user_id
+        ORDER BY total DESC, t.code
+        LIMIT ?3;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, sqliteTime(since), userID, limit)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	counts, err := scanLinkCounts(rows)
+	return counts, sqliteError(err)
+}
+
+// LinkCounts sums the trending counters of the links with codes since since
+func (store *SQLiteStorage) LinkCounts(ctx context.Context, codes []string, since time.Time) ([]LinkCount, error) {
+	codesJSON, err := json.Marshal(codes)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+        SELECT u.code, u.url, u.user_id, COALESCE(SUM(t.clicks), 0)
+        FROM urls u LEFT JOIN click_trending t ON t.code = u.code AND t.minute >= ?1
+        WHERE u.code IN (SELECT value FROM json_each(?2)) AND NOT u.is_deleted
+        GROUP BY u.code, u.url, u.user_id;
+    `
+	rows, err := store.DB.QueryContext(ctx, query, sqliteTime(since), string(codesJSON))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	counts, err := scanLinkCounts(rows)
+	return counts, sqliteError(err)
+}
+
+// streamRows yields the rows of query one by one. Iteration stops at the first error.
+func streamRows[T any](ctx context.Context, db *sql.DB, query string, scan func(*sql.Rows) (T, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			yield(zero, sqliteError(err))
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			item, err := scan(rows)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(zero, sqliteError(err))
+		}
+	}
+}
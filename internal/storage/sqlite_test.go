@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		file    string
+		pragmas []string
+		txlock  string
+		extra   url.Values
+	}{
+		{
+			name:    "plain path",
+			path:    "/data/urls.db",
+			file:    "/data/urls.db",
+			pragmas: []string{"busy_timeout(5000)", "journal_mode(WAL)", "foreign_keys(1)"},
+			txlock:  "immediate",
+		},
+		{
+			name:    "own parameters",
+			path:    "/data/urls.db?_pragma=cache_size(-4000)&_time_format=sqlite",
+			file:    "/data/urls.db",
+			pragmas: []string{"busy_timeout(5000)", "journal_mode(WAL)", "foreign_keys(1)", "cache_size(-4000)"},
+			txlock:  "immediate",
+			extra:   url.Values{"_time_format": {"sqlite"}},
+		},
+		{
+			name:    "deferred txlock and file prefix",
+			path:    "file:urls.db?_txlock=deferred",
+			file:    "urls.db",
+			pragmas: []string{"busy_timeout(5000)", "journal_mode(WAL)", "foreign_keys(1)"},
+			txlock:  "immediate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn, err := sqliteDSN(tt.path)
+			require.NoError(t, err)
+			require.Equal(t, 1, strings.Count(dsn, "?"), dsn)
+
+			file, query, _ := strings.Cut(dsn, "?")
+			assert.Equal(t, "file:"+tt.file, file)
+			params, err := url.ParseQuery(query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.pragmas, params["_pragma"])
+			assert.Equal(t, tt.txlock, params.Get("_txlock"))
+			for key := range tt.extra {
+				assert.Equal(t, tt.extra.Get(key), params.Get(key))
+			}
+		})
+	}
+
+	_, err := sqliteDSN("/data/urls.db?_pragma=%zz")
+	assert.Error(t, err)
+}
@@ -0,0 +1,555 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/config"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage/storagetest"
+)
+
+const testMigrationsPath = "../../migrations"
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemoryStorage()
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "storage.json"), &config.Config{})
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	cfg := &config.Config{MigrationsPath: testMigrationsPath}
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "storage.db"), cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestDedupScopes(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		storagetest.RunDedupScopes(t, func(t *testing.T, scope string) storage.Storage {
+			store, err := storage.NewMemoryStorageWithScope(scope)
+			require.NoError(t, err)
+			return store
+		})
+	})
+	t.Run("file", func(t *testing.T) {
+		storagetest.RunDedupScopes(t, func(t *testing.T, scope string) storage.Storage {
+			store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "storage.json"), &config.Config{DedupScope: scope})
+			require.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store
+		})
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		storagetest.RunDedupScopes(t, func(t *testing.T, scope string) storage.Storage {
+			cfg := &config.Config{MigrationsPath: testMigrationsPath, DedupScope: scope}
+			store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "storage.db"), cfg)
+			require.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store
+		})
+	})
+
+	_, err := storage.NewMemoryStorageWithScope("tenant")
+	assert.Error(t, err)
+}
+
+// TestPostgresStorage runs against the database from TEST_DATABASE_DSN.
+// The database is wiped before every subtest.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	cfg := &config.Config{MigrationsPath: testMigrationsPath}
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := storage.NewPostgresStorage(dsn, cfg)
+		require.NoError(t, err)
+		_, err = store.DB.Exec("TRUNCATE urls, users RESTART IDENTITY CASCADE")
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestFileStorageRecovery(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	cfg := &config.Config{FileSyncPolicy: storage.SyncAlways}
+
+	store, err := storage.NewFileStorage(path, cfg)
+	require.NoError(t, err)
+	user, err := store.CreateUser(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "keep01", URL: "https://kept.example.com", Input: "https://Kept.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "purge1", URL: "https://purged.example.com", UserID: user.ID}))
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"purge1"}))
+	cutoff := time.Now()
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "del001", URL: "https://deleted.example.com", UserID: user.ID}))
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"del001"}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "once01", URL: "https://once.example.com", MaxClicks: 1}))
+	_, err = store.FollowURL(ctx, "once01")
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "once02", URL: "https://once.example.com"}))
+	_, err = store.UpdateURL(ctx, user.ID, "keep01", storage.URLEdit{URL: "https://moved.example.com", Input: "https://Moved.example.com"})
+	require.NoError(t, err)
+	permanent := 301
+	_, err = store.UpdateURL(ctx, user.ID, "keep01", storage.URLEdit{RedirectType: &permanent})
+	require.NoError(t, err)
+	_, err = store.UpdateURL(ctx, user.ID, "keep01", storage.URLEdit{URL: "https://moved.example.com", Input: "https://MOVED.example.com"})
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "back01", URL: "https://back.example.com", UserID: user.ID}))
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"back01"}))
+	_, err = store.RestoreUserURLs(ctx, user.ID, []string{"back01"}, time.Time{})
+	require.NoError(t, err)
+	purged, err := store.PurgeDeletedURLs(ctx, cutoff)
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{Code: "keep01", At: time.Now().Add(-48 * time.Hour), Referrer: "old.example.com", UserAgent: "curl"},
+		{Code: "keep01", At: time.Now(), Referrer: "news.example.com", Country: "FR", Region: "FR-IDF", ASN: 3215},
+		{Code: "keep01", At: time.Now(), UserAgent: "Slackbot", Bot: true},
+	}))
+	downsampled, err := store.DownsampleClicks(ctx, time.Now().Add(-24*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, downsampled)
+	trend := []storage.TrendingCount{{Code: "keep01", Minute: time.Now().UTC().Truncate(time.Minute), Clicks: 3}}
+	require.NoError(t, store.SaveTrending(ctx, trend, time.Now().Add(-24*time.Hour)))
+	leased, err := store.LeaseIDs(ctx, 100)
+	require.NoError(t, err)
+
+	// simulate a crash in the middle of a write: no Close, a torn last line
+	wal, err := os.OpenFile(path+".wal", os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = wal.WriteString(`{"op":"create","urls":[{"code":"torn`)
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	restored, err := storage.NewFileStorage(path, cfg)
+	require.NoError(t, err)
+
+	got, err := restored.GetURL(ctx, "keep01")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, got.UserID)
+	assert.Equal(t, "https://moved.example.com", got.URL, "edits must survive a restart")
+	assert.Equal(t, "https://MOVED.example.com", got.DisplayURL(), "edited input must survive a restart")
+	assert.Equal(t, 301, got.RedirectType, "redirect types must survive a restart")
+	_, err = restored.GetURL(ctx, "del001")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+	_, err = restored.FollowURL(ctx, "once01")
+	assert.ErrorIs(t, err, storage.ErrURLExhausted, "spent clicks must survive a restart")
+	got, err = restored.GetByURL(ctx, 0, "https://once.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "once02", got.Code, "links replacing dead ones must survive a restart")
+	_, err = restored.GetURL(ctx, "back01")
+	assert.NoError(t, err, "restores must survive a restart")
+	_, err = restored.GetURL(ctx, "purge1")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "purges must survive a restart")
+	stats, err := restored.URLStats(ctx, user.ID, "keep01")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Total, "clicks must survive a restart")
+	assert.Len(t, stats.Referrers, 1, "downsampling must survive a restart")
+	assert.Equal(t, 1, stats.Bots, "bot clicks must survive a restart")
+	assert.Equal(t, 2, stats.Unique, "visitor sketches must survive a restart")
+	top, err := restored.TopLinks(ctx, user.ID, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	if assert.Len(t, top, 1, "trending counts must survive a restart") {
+		assert.Equal(t, 3, top[0].Clicks)
+	}
+	n, err := restored.PurgeDeletedURLs(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Zero(t, n, "replayed deletes keep their original time")
+
+	next, err := restored.CreateUser(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, next.ID, user.ID, "restored users must not be handed out again")
+
+	start, err := restored.LeaseIDs(ctx, 1)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, start, leased+100, "leased IDs must not be handed out again")
+
+	require.NoError(t, restored.SaveURL(ctx, storage.URL{Code: "after1", URL: "https://after.example.com"}))
+	_, err = restored.GetURL(ctx, "after1")
+	assert.NoError(t, err, "the log must stay writable after the torn tail is cut")
+
+	// Close folds the log into the snapshot, which must keep everything
+	require.NoError(t, restored.Close())
+	compacted, err := storage.NewFileStorage(path, cfg)
+	require.NoError(t, err)
+	defer compacted.Close()
+
+	_, err = compacted.GetURL(ctx, "after1")
+	assert.NoError(t, err)
+	got, err = compacted.GetURL(ctx, "keep01")
+	require.NoError(t, err)
+	assert.Equal(t, 301, got.RedirectType, "redirect types must survive compaction")
+	assert.Equal(t, "https://MOVED.example.com", got.DisplayURL(), "edited input must survive compaction")
+	_, err = compacted.GetURL(ctx, "once01")
+	assert.ErrorIs(t, err, storage.ErrURLExhausted)
+	got, err = compacted.GetByURL(ctx, 0, "https://once.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "once02", got.Code, "links replacing dead ones must survive compaction")
+	history, err := compacted.URLHistory(ctx, user.ID, "keep01")
+	require.NoError(t, err)
+	if assert.Len(t, history, 2, "history must survive compaction") {
+		assert.Equal(t, "https://kept.example.com", history[0].URL)
+		assert.Equal(t, "https://Kept.example.com", history[0].Input, "replaced versions keep their input")
+		assert.False(t, history[0].ReplacedAt.IsZero())
+	}
+	stats, err = compacted.URLStats(ctx, user.ID, "keep01")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Total, "rollups must survive compaction")
+	assert.Len(t, stats.Referrers, 1, "downsampled clicks must not come back")
+	assert.Equal(t, 1, stats.Bots, "bot rollups must survive compaction")
+	assert.Equal(t, []storage.StatCount{{Key: "FR-IDF", Count: 1}}, stats.Regions, "geo data must survive compaction")
+	assert.Equal(t, 2, stats.Unique, "visitor sketches must survive compaction")
+	assert.Len(t, stats.UniqueDays, 2)
+	top, err = compacted.TopLinks(ctx, user.ID, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	if assert.Len(t, top, 1, "trending counts must survive compaction") {
+		assert.Equal(t, 3, top[0].Clicks)
+	}
+	again, err := compacted.LeaseIDs(ctx, 1)
+	require.NoError(t, err)
+	assert.Greater(t, again, start)
+}
+
+func TestLegacySnapshot(t *testing.T) {
+	ctx := context.Background()
+	// earlier versions kept users next to the snapshot under a prefixed relative name
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.WriteFile("storage.json", []byte(`[
+  {"uuid":"1","short_url":"legacy","original_url":"https://legacy.example.com","user_id":7},
+  {"uuid":"2","short_url":"gone01","original_url":"https://gone.example.com","user_id":7,"is_deleted":true}
+]`), 0644))
+	require.NoError(t, os.WriteFile("user_storage.json", []byte(`[{"uuid":"1","id":7}]`), 0644))
+
+	store, err := storage.NewFileStorage("storage.json", &config.Config{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	got, err := store.GetURL(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "https://legacy.example.com", got.URL)
+	assert.Equal(t, 7, got.UserID)
+	_, err = store.GetURL(ctx, "gone01")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+	purged, err := store.PurgeDeletedURLs(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, purged, "legacy deletions start their retention on load")
+	restored, err := store.RestoreUserURLs(ctx, 7, []string{"gone01"}, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gone01"}, restored, "legacy deletions can be restored")
+	_, err = store.GetUser(ctx, 7)
+	assert.NoError(t, err)
+	next, err := store.CreateUser(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, next.ID, 7, "legacy users must not be handed out again")
+}
+
+// failingExport fails in the middle of the export
+type failingExport struct {
+	storage.Storage
+}
+
+func (s failingExport) AllURLs(ctx context.Context) iter.Seq2[storage.URL, error] {
+	return func(yield func(storage.URL, error) bool) {
+		for u, err := range s.Storage.AllURLs(ctx) {
+			if !yield(u, err) {
+				return
+			}
+			break
+		}
+		yield(storage.URL{}, errors.New("connection reset"))
+	}
+}
+
+func TestSaveDataKeepsSnapshotOnError(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	store := storage.NewMemoryStorage()
+	for _, code := range []string{"first1", "second"} {
+		require.NoError(t, store.SaveURL(ctx, storage.URL{Code: code, URL: "https://" + code + ".example.com"}))
+	}
+	require.NoError(t, storage.SaveData(path, store))
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	assert.Error(t, storage.SaveData(path, failingExport{store}))
+	kept, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, saved, kept, "a failed export must not touch the previous snapshot")
+	leftovers, err := filepath.Glob(path + ".*.tmp")
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+
+	restored := storage.NewMemoryStorage()
+	require.NoError(t, storage.LoadData(path, restored))
+	for _, code := range []string{"first1", "second"} {
+		_, err := restored.GetURL(ctx, code)
+		assert.NoError(t, err, code)
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	records := `{"op":"user","user_id":1}` + "\n" + `{"op":"create","urls":[{"code":"kept01","url":"https://kept.example.com","user_id":1}]}` + "\n"
+	for _, tail := range []string{`{"op":"create","urls":[{"co`, "{\"op\":\"crea\n\n"} {
+		require.NoError(t, os.WriteFile(path+".wal", []byte(records+tail), 0644))
+
+		store, err := storage.NewFileStorage(path, &config.Config{})
+		require.NoError(t, err, "a torn last record is cut off")
+		_, err = store.GetURL(ctx, "kept01")
+		assert.NoError(t, err, "records before the torn one are kept")
+		wal, err := os.ReadFile(path + ".wal")
+		require.NoError(t, err)
+		assert.Equal(t, records, string(wal), "the torn tail is cut off")
+		require.NoError(t, store.Close())
+		require.NoError(t, os.Remove(path))
+	}
+}
+
+func TestWALLegacyRedirectRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	// logs written before edits became one record set the status apart
+	wal := `{"op":"user","user_id":1}` + "\n" +
+		`{"op":"create","urls":[{"code":"perm01","url":"https://permanent.example.com","user_id":1}]}` + "\n" +
+		`{"op":"redirect","user_id":1,"urls":[{"code":"perm01","url":"","redirect_type":308}]}` + "\n"
+	require.NoError(t, os.WriteFile(path+".wal", []byte(wal), 0644))
+
+	store, err := storage.NewFileStorage(path, &config.Config{})
+	require.NoError(t, err)
+	defer store.Close()
+	got, err := store.GetURL(ctx, "perm01")
+	require.NoError(t, err)
+	assert.Equal(t, 308, got.RedirectType)
+	history, err := store.URLHistory(ctx, 1, "perm01")
+	require.NoError(t, err)
+	assert.Len(t, history, 1, "a status change keeps the target")
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	wal := `{"op":"user","user_id":1}` + "\n" + `{"op":garbage}` + "\n" + `{"op":"user","user_id":2}` + "\n"
+	require.NoError(t, os.WriteFile(path+".wal", []byte(wal), 0644))
+
+	_, err := storage.NewFileStorage(path, &config.Config{})
+	assert.ErrorContains(t, err, "corrupt record", "writes after a bad record must not be dropped silently")
+	kept, err := os.ReadFile(path + ".wal")
+	require.NoError(t, err)
+	assert.Equal(t, wal, string(kept), "a corrupt log is left for inspection")
+}
+
+func TestSQLiteDSNParameters(t *testing.T) {
+	cfg := &config.Config{MigrationsPath: testMigrationsPath}
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "storage.db") + "?_pragma=cache_size(-4000)"
+	store, err := storage.Open(dsn, cfg)
+	require.NoError(t, err)
+	defer store.Close()
+	db := store.(*storage.SQLiteStorage).DB
+
+	var cacheSize int
+	require.NoError(t, db.QueryRow("PRAGMA cache_size").Scan(&cacheSize))
+	assert.Equal(t, -4000, cacheSize)
+	// the default pragmas are still applied
+	var journal string
+	require.NoError(t, db.QueryRow("PRAGMA journal_mode").Scan(&journal))
+	assert.Equal(t, "wal", journal)
+	var foreignKeys int
+	require.NoError(t, db.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys))
+	assert.Equal(t, 1, foreignKeys)
+}
+
+func TestSQLiteReopen(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{MigrationsPath: testMigrationsPath}
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	store, err := storage.NewSQLiteStorage(path, cfg)
+	require.NoError(t, err)
+	user, err := store.CreateUser(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "keep01", URL: "https://kept.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "del001", URL: "https://deleted.example.com", UserID: user.ID}))
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"del001"}))
+	require.NoError(t, store.Close())
+
+	// migrations are applied again on every start and must be a no-op
+	store, err = storage.NewSQLiteStorage(path, cfg)
+	require.NoError(t, err)
+	defer store.Close()
+
+	got, err := store.GetURL(ctx, "keep01")
+	require.NoError(t, err)
+	assert.Equal(t, "https://kept.example.com", got.URL)
+	assert.Equal(t, user.ID, got.UserID)
+	_, err = store.GetURL(ctx, "del001")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+
+	next, err := store.CreateUser(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, next.ID, user.ID)
+}
+
+// recordingFactory remembers the dsn it was opened with
+type recordingFactory struct {
+	dsn string
+}
+
+// registryFactory is registered once, the registry has no way to undo it
+var (
+	registryFactory = &recordingFactory{}
+	registerOnce    sync.Once
+)
+
+func (f *recordingFactory) open(dsn string, cfg *config.Config) (storage.Storage, error) {
+	f.dsn = dsn
+	return storage.NewMemoryStorage(), nil
+}
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{MigrationsPath: testMigrationsPath}
+
+	t.Run("builtin schemes", func(t *testing.T) {
+		for dsn, want := range map[string]any{
+			"memory://": &storage.MemoryStorage{},
+			"file://" + filepath.Join(dir, "urls.json"):  &storage.FileStorage{},
+			"sqlite://" + filepath.Join(dir, "urls.db"):  &storage.SQLiteStorage{},
+			"SQLite://" + filepath.Join(dir, "upper.db"): &storage.SQLiteStorage{},
+		} {
+			store, err := storage.Open(dsn, cfg)
+			require.NoError(t, err, dsn)
+			assert.IsType(t, want, store, dsn)
+			require.NoError(t, store.Close())
+		}
+		assert.Subset(t, storage.Schemes(), []string{"file", "memory", "postgres", "sqlite"})
+	})
+
+	t.Run("unknown scheme", func(t *testing.T) {
+		_, err := storage.Open("redis://localhost:6379", cfg)
+		assert.ErrorIs(t, err, storage.ErrUnknownStorage)
+		_, err = storage.Open("/data/urls.json", cfg)
+		assert.ErrorIs(t, err, storage.ErrUnknownStorage)
+	})
+
+	t.Run("third-party backend", func(t *testing.T) {
+		factory := registryFactory
+		registerOnce.Do(func() { storage.Register("registrytest", factory.open) })
+
+		store, err := storage.NewStorage(&config.Config{StorageDSN: "registrytest://bucket/urls?region=eu", SQLitePath: filepath.Join(dir, "ignored.db")})
+		require.NoError(t, err)
+		defer store.Close()
+		assert.Equal(t, "registrytest://bucket/urls?region=eu", factory.dsn, "the factory gets the dsn as configured")
+		assert.Equal(t, "bucket/urls?region=eu", storage.DSNPath(factory.dsn))
+
+		assert.Panics(t, func() { storage.Register("RegistryTest", factory.open) })
+		assert.Panics(t, func() { storage.Register("registrytest-nil", nil) })
+	})
+
+	t.Run("legacy settings", func(t *testing.T) {
+		store, err := storage.NewStorage(&config.Config{MigrationsPath: testMigrationsPath, SQLitePath: filepath.Join(dir, "legacy.db"), DataFilePath: filepath.Join(dir, "legacy.json")})
+		require.NoError(t, err)
+		assert.IsType(t, &storage.SQLiteStorage{}, store, "SQLitePath wins over DataFilePath")
+		require.NoError(t, store.Close())
+
+		store, err = storage.NewStorage(&config.Config{DataFilePath: filepath.Join(dir, "legacy.json")})
+		require.NoError(t, err)
+		assert.IsType(t, &storage.FileStorage{}, store)
+		require.NoError(t, store.Close())
+
+		store, err = storage.NewStorage(&config.Config{})
+		require.NoError(t, err)
+		assert.IsType(t, &storage.MemoryStorage{}, store)
+		require.NoError(t, store.Close())
+	})
+}
+
+func TestSQLiteConcurrentUpdateWithDeferredDSN(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{MigrationsPath: testMigrationsPath}
+	// a deferred lock asked for in the dsn must not break concurrent edits
+	store, err := storage.Open("sqlite://"+filepath.Join(t.TempDir(), "storage.db")+"?_txlock=deferred", cfg)
+	require.NoError(t, err)
+	defer store.Close()
+
+	user, err := store.CreateUser(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "race01", URL: "https://v0.example.com", UserID: user.ID}))
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.UpdateURL(ctx, user.ID, "race01", storage.URLEdit{URL: "https://v" + strconv.Itoa(i+1) + ".example.com"})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSQLiteDedupScopeChange(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	open := func(scope string) *storage.SQLiteStorage {
+		t.Helper()
+		store, err := storage.NewSQLiteStorage(path, &config.Config{MigrationsPath: testMigrationsPath, DedupScope: scope})
+		require.NoError(t, err)
+		return store
+	}
+
+	store := open(storage.DedupPerUser)
+	alice, err := store.CreateUser(ctx)
+	require.NoError(t, err)
+	bob, err := store.CreateUser(ctx)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "alice1", URL: "https://shared.example.com", UserID: alice.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "bob001", URL: "https://shared.example.com", UserID: bob.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "spent1", URL: "https://spent.example.com", UserID: alice.ID, MaxClicks: 1}))
+	_, err = store.FollowURL(ctx, "spent1")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// the global scope allows one link per URL: the oldest one keeps it
+	store = open(storage.DedupGlobal)
+	got, err := store.GetByURL(ctx, bob.ID, "https://shared.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "alice1", got.Code)
+	err = store.SaveURL(ctx, storage.URL{Code: "carol1", URL: "https://shared.example.com"})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "spent2", URL: "https://spent.example.com"}), "dead links get no key")
+	require.NoError(t, store.Close())
+
+	// and going back gives every user their own key again
+	store = open(storage.DedupPerUser)
+	defer store.Close()
+	for user, code := range map[int]string{alice.ID: "alice1", bob.ID: "bob001"} {
+		got, err := store.GetByURL(ctx, user, "https://shared.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, code, got.Code)
+	}
+}
@@ -0,0 +1,1065 @@
+/*
+Package storagetest provides the behavioral contract every storage.Storage
+backend must satisfy. A backend test calls Run with a factory that returns
+a fresh, empty store:
+
+	func TestMyStorage(t *testing.T) {
+		storagetest.Run(t, func(t *testing.T) storage.Storage {
+			return newEmptyStore(t)
+		})
+	}
+*/
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/storage"
+)
+
+// Factory returns a fresh, empty store. It is called once per subtest and
+// should register its own cleanup with t.Cleanup.
+type Factory func(t *testing.T) storage.Storage
+
+// Run runs the whole contract against stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store storage.Storage)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"NotFound", testNotFound},
+		{"DuplicateCode", testDuplicateCode},
+		{"DuplicateURL", testDuplicateURL},
+		{"Batch", testBatch},
+		{"BatchAtomicity", testBatchAtomicity},
+		{"Expiry", testExpiry},
+		{"MaxClicks", testMaxClicks},
+		{"DeadLinkDedup", testDeadLinkDedup},
+		{"ConcurrentFollow", testConcurrentFollow},
+		{"SoftDelete", testSoftDelete},
+		{"DeleteOwnership", testDeleteOwnership},
+		{"Restore", testRestore},
+		{"Purge", testPurge},
+		{"URLsByUser", testURLsByUser},
+		{"UpdateURL", testUpdateURL},
+		{"ConcurrentUpdate", testConcurrentUpdate},
+		{"RedirectType", testRedirectType},
+		{"Users", testUsers},
+		{"AllURLs", testAllURLs},
+		{"ConcurrentSave", testConcurrentSave},
+		{"ConcurrentUsers", testConcurrentUsers},
+		{"LeaseIDs", testLeaseIDs},
+		{"Clicks", testClicks},
+		{"Rollups", testRollups},
+		{"Uniques", testUniques},
+		{"Trending", testTrending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, store storage.Storage) storage.User {
+	t.Helper()
+	user, err := store.CreateUser(context.Background())
+	require.NoError(t, err)
+	return user
+}
+
+func testSaveAndGet(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "abc123", URL: "https://example.com", UserID: user.ID}))
+
+	got, err := store.GetURL(ctx, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", got.Code)
+	assert.Equal(t, "https://example.com", got.URL)
+	assert.Equal(t, user.ID, got.UserID)
+
+	got, err = store.GetByURL(ctx, user.ID, "https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", got.Code)
+	assert.Equal(t, user.ID, got.UserID)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "anon01", URL: "https://example.org"}))
+	got, err = store.GetURL(ctx, "anon01")
+	require.NoError(t, err)
+	assert.Zero(t, got.UserID)
+	assert.Empty(t, got.Input)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "input1", URL: "https://example.net/", Input: "HTTPS://Example.net"}))
+	got, err = store.GetURL(ctx, "input1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.net/", got.URL)
+	assert.Equal(t, "HTTPS://Example.net", got.DisplayURL(), "the submitted URL must be kept for display")
+}
+
+func testNotFound(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	_, err := store.GetURL(ctx, "nope00")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	_, err = store.GetByURL(ctx, 0, "https://missing.example.com")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func testDuplicateCode(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "dup001", URL: "https://a.example.com"}))
+	err := store.SaveURL(ctx, storage.URL{Code: "dup001", URL: "https://b.example.com"})
+	assert.ErrorIs(t, err, storage.ErrCodeAlreadyExists)
+
+	got, err := store.GetURL(ctx, "dup001")
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.example.com", got.URL)
+}
+
+func testDuplicateURL(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "first1", URL: "https://dup.example.com"}))
+	err := store.SaveURL(ctx, storage.URL{Code: "secnd1", URL: "https://dup.example.com"})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+
+	got, err := store.GetByURL(ctx, 0, "https://dup.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "first1", got.Code)
+
+	_, err = store.GetURL(ctx, "secnd1")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func testBatch(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	batch := []storage.URL{
+		{Code: "batch1", URL: "https://one.example.com", UserID: user.ID},
+		{Code: "batch2", URL: "https://two.example.com", UserID: user.ID},
+	}
+	require.NoError(t, store.SaveBatchURL(ctx, batch))
+
+	for _, want := range batch {
+		got, err := store.GetURL(ctx, want.Code)
+		require.NoError(t, err)
+		assert.Equal(t, want.URL, got.URL)
+		assert.Equal(t, user.ID, got.UserID, "batch must keep the owner")
+	}
+
+	urls, err := store.GetURLsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, len(batch))
+}
+
+func testBatchAtomicity(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "exist1", URL: "https://exists.example.com"}))
+
+	err := store.SaveBatchURL(ctx, []storage.URL{
+		{Code: "atom01", URL: "https://new.example.com"},
+		{Code: "atom02", URL: "https://exists.example.com"},
+	})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+	_, err = store.GetURL(ctx, "atom01")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "failed batch must not be partially saved")
+
+	err = store.SaveBatchURL(ctx, []storage.URL{
+		{Code: "atom03", URL: "https://other.example.com"},
+		{Code: "exist1", URL: "https://another.example.com"},
+	})
+	assert.ErrorIs(t, err, storage.ErrCodeAlreadyExists)
+	_, err = store.GetURL(ctx, "atom03")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "failed batch must not be partially saved")
+
+	err = store.SaveBatchURL(ctx, []storage.URL{
+		{Code: "atom04", URL: "https://twice.example.com"},
+		{Code: "atom05", URL: "https://twice.example.com"},
+	})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+	_, err = store.GetURL(ctx, "atom04")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "failed batch must not be partially saved")
+}
+
+func testExpiry(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+	// databases keep microseconds at best
+	future := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "live01", URL: "https://live.example.com", UserID: user.ID, ExpiresAt: future}))
+	require.NoError(t, store.SaveBatchURL(ctx, []storage.URL{
+		{Code: "gone01", URL: "https://gone.example.com", UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute)},
+	}))
+
+	got, err := store.GetURL(ctx, "live01")
+	require.NoError(t, err)
+	assert.True(t, future.Equal(got.ExpiresAt), "expiry must round-trip: want %v, got %v", future, got.ExpiresAt)
+
+	_, err = store.GetURL(ctx, "gone01")
+	assert.ErrorIs(t, err, storage.ErrURLExpired)
+	_, err = store.GetByURL(ctx, user.ID, "https://gone.example.com")
+	assert.ErrorIs(t, err, storage.ErrURLExpired)
+
+	urls, err := store.GetURLsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2, "expired links stay listed")
+	for _, u := range urls {
+		assert.False(t, u.ExpiresAt.IsZero(), "listed links keep their expiry")
+	}
+
+	got, err = store.GetByURL(ctx, user.ID, "https://live.example.com")
+	require.NoError(t, err)
+	assert.False(t, got.Expired(time.Now()))
+}
+
+func testMaxClicks(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "free01", URL: "https://free.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "once01", URL: "https://once.example.com", UserID: user.ID, MaxClicks: 1}))
+
+	for range 3 {
+		_, err := store.FollowURL(ctx, "free01")
+		require.NoError(t, err, "links without max clicks are unlimited")
+	}
+
+	got, err := store.FollowURL(ctx, "once01")
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.MaxClicks)
+	assert.Equal(t, 1, got.Clicks)
+	assert.True(t, got.Exhausted(), "the last click exhausts the link")
+
+	_, err = store.FollowURL(ctx, "once01")
+	assert.ErrorIs(t, err, storage.ErrURLExhausted)
+	_, err = store.GetURL(ctx, "once01")
+	assert.ErrorIs(t, err, storage.ErrURLExhausted)
+
+	urls, err := store.GetURLsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2, "exhausted links stay listed")
+
+	_, err = store.FollowURL(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "gone02", URL: "https://gone.example.com", UserID: user.ID, MaxClicks: 5}))
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"gone02"}))
+	_, err = store.FollowURL(ctx, "gone02")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "late01", URL: "https://late.example.com", UserID: user.ID, MaxClicks: 5, ExpiresAt: time.Now().Add(-time.Second)}))
+	_, err = store.FollowURL(ctx, "late01")
+	assert.ErrorIs(t, err, storage.ErrURLExpired)
+
+	urls, err = store.GetURLsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	for _, u := range urls {
+		if u.Code == "gone02" || u.Code == "late01" {
+			assert.Zero(t, u.Clicks, "%s: clicks of links that cannot be followed are not spent", u.Code)
+		}
+	}
+}
+
+func testDeadLinkDedup(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "spent1", URL: "https://spent.example.com", UserID: user.ID, MaxClicks: 1}))
+	_, err := store.FollowURL(ctx, "spent1")
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "late01", URL: "https://late.example.com", UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute)}))
+
+	// a dead link gives its URL up to the next link
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "spent2", URL: "https://spent.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveBatchURL(ctx, []storage.URL{{Code: "late02", URL: "https://late.example.com", UserID: user.ID}}))
+	for url, code := range map[string]string{"https://spent.example.com": "spent2", "https://late.example.com": "late02"} {
+		got, err := store.GetByURL(ctx, user.ID, url)
+		require.NoError(t, err)
+		assert.Equal(t, code, got.Code)
+	}
+	_, err = store.GetURL(ctx, "spent1")
+	assert.ErrorIs(t, err, storage.ErrURLExhausted, "the dead link keeps its code")
+
+	// the live link holds the URL again
+	err = store.SaveURL(ctx, storage.URL{Code: "spent3", URL: "https://spent.example.com", UserID: user.ID})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+
+	// edits may take the URL of a dead link as well
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "gone01", URL: "https://gone.example.com", UserID: user.ID, MaxClicks: 1}))
+	_, err = store.FollowURL(ctx, "gone01")
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "edit01", URL: "https://edit.example.com", UserID: user.ID}))
+	_, err = store.UpdateURL(ctx, user.ID, "edit01", storage.URLEdit{URL: "https://gone.example.com"})
+	require.NoError(t, err)
+	got, err := store.GetByURL(ctx, user.ID, "https://gone.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "edit01", got.Code)
+}
+
+func testRedirectType(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	other := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "perm01", URL: "https://permanent.example.com", UserID: owner.ID, RedirectType: 308}))
+	require.NoError(t, store.SaveBatchURL(ctx, []storage.URL{{Code: "dflt01", URL: "https://default.example.com", UserID: owner.ID}}))
+	got, err := store.GetURL(ctx, "perm01")
+	require.NoError(t, err)
+	assert.Equal(t, 308, got.RedirectType)
+	got, err = store.GetURL(ctx, "dflt01")
+	require.NoError(t, err)
+	assert.Zero(t, got.RedirectType, "links without a type use the server default")
+
+	redirectType := func(status int) storage.URLEdit {
+		return storage.URLEdit{RedirectType: &status}
+	}
+	_, err = store.UpdateURL(ctx, other.ID, "perm01", redirectType(302))
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "only the owner may change a link")
+	_, err = store.UpdateURL(ctx, owner.ID, "missing", redirectType(302))
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	got, err = store.UpdateURL(ctx, owner.ID, "dflt01", redirectType(301))
+	require.NoError(t, err)
+	assert.Equal(t, 301, got.RedirectType)
+	assert.Equal(t, "https://default.example.com", got.URL)
+	_, err = store.UpdateURL(ctx, owner.ID, "perm01", redirectType(0))
+	require.NoError(t, err)
+
+	// the target and the status change together or not at all
+	edit := redirectType(302)
+	edit.URL = "https://default.example.com"
+	_, err = store.UpdateURL(ctx, owner.ID, "perm01", edit)
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+	got, err = store.GetURL(ctx, "perm01")
+	require.NoError(t, err)
+	assert.Zero(t, got.RedirectType, "a refused target keeps the status")
+	edit.URL = "https://moved.example.com"
+	got, err = store.UpdateURL(ctx, owner.ID, "perm01", edit)
+	require.NoError(t, err)
+	assert.Equal(t, "https://moved.example.com", got.URL)
+	assert.Equal(t, 302, got.RedirectType)
+	history, err := store.URLHistory(ctx, owner.ID, "perm01")
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+	_, err = store.UpdateURL(ctx, owner.ID, "perm01", redirectType(0))
+	require.NoError(t, err)
+	history, err = store.URLHistory(ctx, owner.ID, "perm01")
+	require.NoError(t, err)
+	assert.Len(t, history, 2, "a status change keeps the target")
+	for code, want := range map[string]int{"dflt01": 301, "perm01": 0} {
+		got, err = store.FollowURL(ctx, code)
+		require.NoError(t, err)
+		assert.Equal(t, want, got.RedirectType, code)
+	}
+
+	require.NoError(t, store.DeleteUserURLs(ctx, owner.ID, []string{"dflt01"}))
+	_, err = store.UpdateURL(ctx, owner.ID, "dflt01", redirectType(302))
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+}
+
+func testConcurrentFollow(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	const maxClicks, workers = 5, 20
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "limit1", URL: "https://limited.example.com", MaxClicks: maxClicks}))
+
+	var wg sync.WaitGroup
+	var followed atomic.Int32
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.FollowURL(ctx, "limit1")
+			if err == nil {
+				followed.Add(1)
+			} else {
+				assert.ErrorIs(t, err, storage.ErrURLExhausted)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, maxClicks, followed.Load(), "no click may be spent twice")
+}
+
+func testConcurrentUpdate(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	const workers = 10
+	owner := createUser(t, store)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "race01", URL: "https://v0.example.com", UserID: owner.ID}))
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.UpdateURL(ctx, owner.ID, "race01", storage.URLEdit{URL: fmt.Sprintf("https://v%d.example.com", i+1)})
+			assert.NoError(t, err, "concurrent edits must all succeed")
+		}()
+	}
+	wg.Wait()
+
+	history, err := store.URLHistory(ctx, owner.ID, "race01")
+	require.NoError(t, err)
+	require.Len(t, history, workers+1, "every edit keeps the target it replaced")
+	for i, v := range history[:workers] {
+		assert.Equal(t, i+1, v.Version)
+	}
+}
+
+func testSoftDelete(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "del001", URL: "https://deleted.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "keep01", URL: "https://kept.example.com", UserID: user.ID}))
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"del001", "missing"}))
+
+	_, err := store.GetURL(ctx, "del001")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+	_, err = store.GetByURL(ctx, user.ID, "https://deleted.example.com")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+
+	urls, err := store.GetURLsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, urls, 1, "deleted links must not be listed")
+	assert.Equal(t, "keep01", urls[0].Code)
+
+	// a deleted link keeps its code and URL taken
+	err = store.SaveURL(ctx, storage.URL{Code: "del001", URL: "https://fresh.example.com"})
+	assert.ErrorIs(t, err, storage.ErrCodeAlreadyExists)
+	err = store.SaveURL(ctx, storage.URL{Code: "fresh1", URL: "https://deleted.example.com"})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+
+	// deleting twice is harmless
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"del001"}))
+}
+
+func testDeleteOwnership(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	other := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "own001", URL: "https://owned.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "anon02", URL: "https://anon.example.com"}))
+	require.NoError(t, store.DeleteUserURLs(ctx, other.ID, []string{"own001", "anon02"}))
+
+	_, err := store.GetURL(ctx, "own001")
+	assert.NoError(t, err, "only the owner may delete a link")
+	_, err = store.GetURL(ctx, "anon02")
+	assert.NoError(t, err, "anonymous links cannot be deleted")
+}
+
+func testUpdateURL(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	other := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "edit01", URL: "https://v1.example.com", Input: "https://V1.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "taken1", URL: "https://taken.example.com", UserID: owner.ID}))
+
+	_, err := store.UpdateURL(ctx, other.ID, "edit01", storage.URLEdit{URL: "https://hijack.example.com"})
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "only the owner may edit a link")
+	_, err = store.URLHistory(ctx, other.ID, "edit01")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "only the owner may see the history")
+	_, err = store.UpdateURL(ctx, owner.ID, "missing", storage.URLEdit{URL: "https://missing.example.com"})
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	_, err = store.UpdateURL(ctx, owner.ID, "edit01", storage.URLEdit{URL: "https://taken.example.com"})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+
+	before := time.Now().Add(-time.Second)
+	got, err := store.UpdateURL(ctx, owner.ID, "edit01", storage.URLEdit{URL: "https://v2.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://v2.example.com", got.URL)
+	assert.Equal(t, "https://v2.example.com", got.DisplayURL(), "an edit replaces the submitted URL")
+	got, err = store.UpdateURL(ctx, owner.ID, "edit01", storage.URLEdit{URL: "https://v3.example.com", Input: "HTTPS://V3.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "HTTPS://V3.example.com", got.DisplayURL(), "an edit keeps the submitted URL")
+	got, err = store.UpdateURL(ctx, owner.ID, "edit01", storage.URLEdit{URL: "https://v3.example.com", Input: "https://V3.EXAMPLE.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://V3.EXAMPLE.com", got.DisplayURL(), "the same target spelled another way changes the spelling")
+	_, err = store.UpdateURL(ctx, owner.ID, "edit01", storage.URLEdit{URL: "https://v3.example.com", Input: "https://V3.EXAMPLE.com"})
+	require.NoError(t, err, "setting the same target changes nothing")
+
+	got, err = store.GetURL(ctx, "edit01")
+	require.NoError(t, err)
+	assert.Equal(t, "https://v3.example.com", got.URL)
+	assert.Equal(t, "https://V3.EXAMPLE.com", got.DisplayURL())
+	got, err = store.GetByURL(ctx, owner.ID, "https://v3.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "edit01", got.Code)
+	_, err = store.GetByURL(ctx, owner.ID, "https://v1.example.com")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "the old target is free again")
+
+	history, err := store.URLHistory(ctx, owner.ID, "edit01")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	for i, want := range []string{"https://v1.example.com", "https://v2.example.com", "https://v3.example.com"} {
+		assert.Equal(t, i+1, history[i].Version)
+		assert.Equal(t, want, history[i].URL)
+	}
+	for i, want := range []string{"https://V1.example.com", "", "https://V3.EXAMPLE.com"} {
+		assert.Equal(t, want, history[i].Input, "versions keep the submitted URL")
+	}
+	assert.True(t, history[0].ReplacedAt.After(before), "replaced versions keep the time: %v", history[0].ReplacedAt)
+	assert.True(t, history[2].ReplacedAt.IsZero(), "the current version is not replaced")
+
+	history, err = store.URLHistory(ctx, owner.ID, "taken1")
+	require.NoError(t, err)
+	assert.Len(t, history, 1, "a link never edited has only its current target")
+
+	require.NoError(t, store.DeleteUserURLs(ctx, owner.ID, []string{"edit01"}))
+	_, err = store.UpdateURL(ctx, owner.ID, "edit01", storage.URLEdit{URL: "https://v4.example.com"})
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+}
+
+func testRestore(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	other := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "back01", URL: "https://back.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "live02", URL: "https://live.example.com", UserID: owner.ID}))
+	before := time.Now().Add(-time.Minute)
+	require.NoError(t, store.DeleteUserURLs(ctx, owner.ID, []string{"back01"}))
+
+	restored, err := store.RestoreUserURLs(ctx, other.ID, []string{"back01"}, before)
+	require.NoError(t, err)
+	assert.Empty(t, restored, "only the owner may restore a link")
+	restored, err = store.RestoreUserURLs(ctx, owner.ID, []string{"back01"}, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, restored, "links deleted before the grace period stay deleted")
+
+	restored, err = store.RestoreUserURLs(ctx, owner.ID, []string{"back01", "live02", "missing"}, before)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"back01"}, restored, "only deleted links are restored")
+
+	got, err := store.GetURL(ctx, "back01")
+	require.NoError(t, err)
+	assert.Equal(t, "https://back.example.com", got.URL)
+	urls, err := store.GetURLsByUserID(ctx, owner.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	// a restored link can be deleted again
+	require.NoError(t, store.DeleteUserURLs(ctx, owner.ID, []string{"back01"}))
+	_, err = store.GetURL(ctx, "back01")
+	assert.ErrorIs(t, err, storage.ErrURLDeleted)
+}
+
+func testPurge(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "old001", URL: "https://old.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "keep01", URL: "https://kept.example.com", UserID: user.ID}))
+	_, err := store.UpdateURL(ctx, user.ID, "old001", storage.URLEdit{URL: "https://older.example.com"})
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"old001"}))
+
+	n, err := store.PurgeDeletedURLs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, n, "recently deleted links are kept")
+
+	n, err = store.PurgeDeletedURLs(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = store.GetURL(ctx, "old001")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	_, err = store.GetURL(ctx, "keep01")
+	assert.NoError(t, err, "live links are never purged")
+	restored, err := store.RestoreUserURLs(ctx, user.ID, []string{"old001"}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, restored, "purged links cannot be restored")
+
+	// the code and the URL are free again
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "old001", URL: "https://older.example.com", UserID: user.ID}))
+	history, err := store.URLHistory(ctx, user.ID, "old001")
+	require.NoError(t, err)
+	assert.Len(t, history, 1, "history is purged with the link")
+}
+
+func testURLsByUser(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	alice := createUser(t, store)
+	bob := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "alice1", URL: "https://alice1.example.com", UserID: alice.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "alice2", URL: "https://alice2.example.com", UserID: alice.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "bob001", URL: "https://bob.example.com", UserID: bob.ID}))
+
+	urls, err := store.GetURLsByUserID(ctx, alice.ID)
+	require.NoError(t, err)
+	codes := make([]string, 0, len(urls))
+	for _, u := range urls {
+		assert.Equal(t, alice.ID, u.UserID)
+		codes = append(codes, u.Code)
+	}
+	slices.Sort(codes)
+	assert.Equal(t, []string{"alice1", "alice2"}, codes)
+
+	nobody := createUser(t, store)
+	urls, err = store.GetURLsByUserID(ctx, nobody.ID)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func testUsers(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	first := createUser(t, store)
+	second := createUser(t, store)
+	assert.NotZero(t, first.ID)
+	assert.Greater(t, second.ID, first.ID, "user IDs must grow")
+
+	var ids []int
+	for user, err := range store.GetAllUsers(ctx) {
+		require.NoError(t, err)
+		ids = append(ids, user.ID)
+	}
+	assert.ElementsMatch(t, []int{first.ID, second.ID}, ids)
+
+	got, err := store.GetUser(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, second, got)
+
+	_, err = store.GetUser(ctx, second.ID+100)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testAllURLs(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	const total = 25
+	for i := range total {
+		u := storage.URL{Code: fmt.Sprintf("all%03d", i), URL: fmt.Sprintf("https://all%d.example.com", i), UserID: user.ID}
+		require.NoError(t, store.SaveURL(ctx, u))
+	}
+	require.NoError(t, store.DeleteUserURLs(ctx, user.ID, []string{"all000"}))
+
+	seen := make(map[string]bool)
+	for u, err := range store.AllURLs(ctx) {
+		require.NoError(t, err)
+		seen[u.Code] = true
+	}
+	assert.Len(t, seen, total, "export must include deleted links")
+
+	// more links than a server-side cursor fetches at once
+	const batch = 2500
+	urls := make([]storage.URL, batch)
+	for i := range urls {
+		urls[i] = storage.URL{Code: fmt.Sprintf("big%04d", i), URL: fmt.Sprintf("https://big%d.example.com", i), UserID: user.ID}
+	}
+	require.NoError(t, store.SaveBatchURL(ctx, urls))
+	clear(seen)
+	for u, err := range store.AllURLs(ctx) {
+		require.NoError(t, err)
+		assert.False(t, seen[u.Code], "%s is exported twice", u.Code)
+		seen[u.Code] = true
+	}
+	assert.Len(t, seen, total+batch, "export must not stop after the first chunk")
+
+	n := 0
+	for _, err := range store.AllURLs(ctx) {
+		require.NoError(t, err)
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, n, "iteration must stop when the consumer breaks")
+}
+
+func testConcurrentSave(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	const workers = 16
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// all workers race for the same URL, each also saves its own one
+			err := store.SaveURL(ctx, storage.URL{Code: fmt.Sprintf("race%02d", i), URL: "https://race.example.com"})
+			if err == nil {
+				created.Add(1)
+			} else {
+				assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+			}
+			own := storage.URL{Code: fmt.Sprintf("own%03d", i), URL: fmt.Sprintf("https://own%d.example.com", i)}
+			assert.NoError(t, store.SaveURL(ctx, own))
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, created.Load(), "exactly one save of the same URL must win")
+	for i := range workers {
+		_, err := store.GetURL(ctx, fmt.Sprintf("own%03d", i))
+		assert.NoError(t, err)
+	}
+}
+
+func testConcurrentUsers(t *testing.T, store storage.Storage) {
+	const workers = 16
+	ids := make(chan int, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := store.CreateUser(context.Background())
+			if assert.NoError(t, err) {
+				ids <- user.ID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "user ID %d handed out twice", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, workers)
+}
+
+func testLeaseIDs(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+
+	_, err := store.LeaseIDs(ctx, 0)
+	assert.ErrorIs(t, err, storage.ErrInvalidLeaseSize)
+
+	const workers = 8
+	type lease struct{ start, size int64 }
+	leases := make(chan lease, workers*2)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// mixed sizes must not overlap either
+			for _, size := range []int{1, 10 + i} {
+				start, err := store.LeaseIDs(ctx, size)
+				if assert.NoError(t, err) {
+					leases <- lease{start, int64(size)}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(leases)
+
+	taken := make(map[int64]bool)
+	for l := range leases {
+		assert.Positive(t, l.start)
+		for id := l.start; id < l.start+l.size; id++ {
+			assert.False(t, taken[id], "id %d leased twice", id)
+			taken[id] = true
+		}
+	}
+}
+
+func testClicks(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	other := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "stat01", URL: "https://stats.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "quiet1", URL: "https://quiet.example.com", UserID: owner.ID}))
+
+	day1 := time.Date(2025, 3, 1, 23, 30, 0, 0, time.UTC)
+	day2 := day1.Add(time.Hour)
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{Code: "stat01", At: day1, Referrer: "news.example.com", UserAgent: "curl", IP: "10.0.0.0", Country: "DE", Region: "DE-BE", ASN: 3320},
+		{Code: "stat01", At: day1, Referrer: "news.example.com", UserAgent: "curl", IP: "10.0.0.0", Country: "DE", Region: "DE-BE", ASN: 3320},
+		{Code: "stat01", At: day2.In(time.FixedZone("UTC+3", 3*60*60)), UserAgent: "firefox", IP: "10.0.0.0", Country: "DE", Region: "DE-HH", ASN: 3320},
+		{Code: "stat01", At: day2, Referrer: "news.example.com", UserAgent: "curl", IP: "192.168.1.0"},
+		{Code: "gone99", At: day2, UserAgent: "curl", IP: "10.0.0.0"},
+		{Code: "stat01", At: day2, Referrer: "t.me", UserAgent: "TelegramBot", IP: "10.0.0.0", Bot: true, Country: "NL", ASN: 62041},
+		{Code: "stat01", At: day2.Add(24 * time.Hour), UserAgent: "TelegramBot", IP: "10.0.0.0", Bot: true},
+	}), "clicks of missing links are dropped")
+
+	stats, err := store.URLStats(ctx, owner.ID, "stat01")
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.Total, "bots are not counted with people")
+	assert.Equal(t, 2, stats.Bots)
+	assert.Equal(t, 3, stats.Unique)
+	assert.Equal(t, []storage.StatCount{{Key: "news.example.com", Count: 3}, {Key: "", Count: 1}}, stats.Referrers)
+	assert.Equal(t, []storage.StatCount{{Key: "DE", Count: 3}, {Key: "", Count: 1}}, stats.Countries)
+	assert.Equal(t, []storage.StatCount{{Key: "DE-BE", Count: 2}, {Key: "", Count: 1}, {Key: "DE-HH", Count: 1}}, stats.Regions)
+	assert.Equal(t, []storage.StatCount{{Key: "3320", Count: 3}, {Key: "", Count: 1}}, stats.ASNs)
+	assert.Equal(t, []storage.StatCount{{Key: "2025-03-01", Count: 2}, {Key: "2025-03-02", Count: 2}}, stats.Days)
+	assert.Equal(t, []storage.StatCount{{Key: "2025-03-01", Count: 1}, {Key: "2025-03-02", Count: 2}}, stats.UniqueDays)
+	assert.Zero(t, stats.UniqueWeek, "the clicks are older than a week")
+
+	stats, err = store.URLStats(ctx, owner.ID, "quiet1")
+	require.NoError(t, err)
+	assert.Zero(t, stats.Total)
+	assert.Zero(t, stats.Bots)
+	assert.Empty(t, stats.Referrers)
+	assert.Empty(t, stats.Countries)
+
+	_, err = store.URLStats(ctx, other.ID, "stat01")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "only the owner may see the stats")
+	_, err = store.URLStats(ctx, owner.ID, "gone99")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+}
+
+func testRollups(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	other := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "roll01", URL: "https://roll1.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "roll02", URL: "https://roll2.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "roll03", URL: "https://roll3.example.com", UserID: other.ID}))
+
+	base := time.Date(2025, 3, 1, 10, 15, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return time.Date(2025, 3, 1, h, 0, 0, 0, time.UTC) }
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{Code: "roll01", At: base, IP: "10.0.0.0"},
+		{Code: "roll01", At: base, IP: "10.0.0.0", Bot: true},
+		{Code: "roll03", At: base, IP: "10.0.0.0"},
+	}))
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{Code: "roll01", At: base.Add(10 * time.Minute), IP: "10.0.0.0"},
+		{Code: "roll01", At: base.Add(time.Hour), IP: "10.0.0.0"},
+		{Code: "roll02", At: base.Add(2 * time.Hour), IP: "10.0.0.0"},
+	}), "later batches add up to the same buckets")
+
+	from, to := base.Add(-24*time.Hour), base.Add(24*time.Hour)
+	points, err := store.ClickSeries(ctx, owner.ID, "roll01", storage.IntervalHour, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SeriesPoint{{At: hour(10), Clicks: 2, Bots: 1}, {At: hour(11), Clicks: 1}}, points)
+
+	points, err = store.ClickSeries(ctx, owner.ID, "", storage.IntervalHour, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SeriesPoint{{At: hour(10), Clicks: 2, Bots: 1}, {At: hour(11), Clicks: 1}, {At: hour(12), Clicks: 1}}, points,
+		"the user's series sums all their links and only theirs")
+
+	points, err = store.ClickSeries(ctx, owner.ID, "", storage.IntervalDay, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SeriesPoint{{At: hour(0), Clicks: 4, Bots: 1}}, points)
+
+	points, err = store.ClickSeries(ctx, owner.ID, "", storage.IntervalHour, hour(11), hour(12))
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SeriesPoint{{At: hour(11), Clicks: 1}}, points, "to is exclusive")
+
+	_, err = store.ClickSeries(ctx, other.ID, "roll01", storage.IntervalHour, from, to)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound, "only the owner may see the series")
+
+	removed, err := store.DownsampleClicks(ctx, base.Add(90*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 5, removed)
+
+	points, err = store.ClickSeries(ctx, owner.ID, "", storage.IntervalHour, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SeriesPoint{{At: hour(11), Clicks: 1}, {At: hour(12), Clicks: 1}}, points,
+		"hourly buckets that ended before the cutoff are dropped")
+	points, err = store.ClickSeries(ctx, owner.ID, "", storage.IntervalDay, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.SeriesPoint{{At: hour(0), Clicks: 4, Bots: 1}}, points, "daily buckets are kept")
+
+	stats, err := store.URLStats(ctx, owner.ID, "roll01")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Total, "totals outlive raw clicks")
+	assert.Equal(t, 1, stats.Bots)
+	assert.Equal(t, 1, stats.Unique, "unique visitors outlive raw clicks")
+	assert.Equal(t, []storage.StatCount{{Key: "2025-03-01", Count: 3}}, stats.Days)
+}
+
+func testUniques(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "uniq01", URL: "https://uniq1.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "uniq02", URL: "https://uniq2.example.com", UserID: owner.ID}))
+
+	now := time.Now()
+	old := now.AddDate(0, 0, -30)
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{Code: "uniq01", At: old, IP: "10.0.0.0", UserAgent: "a"},
+		{Code: "uniq01", At: old, IP: "10.0.0.0", UserAgent: "b"},
+	}))
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{Code: "uniq01", At: now, IP: "10.0.0.0", UserAgent: "a"},
+		{Code: "uniq01", At: now, IP: "10.0.0.0", UserAgent: "c"},
+	}))
+	require.NoError(t, store.SaveClicks(ctx, []storage.Click{
+		{Code: "uniq01", At: now, IP: "10.0.0.0", UserAgent: "c"},
+		{Code: "uniq01", At: now, IP: "10.0.0.0", UserAgent: "Googlebot", Bot: true},
+	}), "visitors counted by earlier batches are not counted again")
+
+	stats, err := store.URLStats(ctx, owner.ID, "uniq01")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Unique)
+	assert.Equal(t, 2, stats.UniqueWeek)
+	assert.Equal(t, []storage.StatCount{
+		{Key: old.UTC().Format("2006-01-02"), Count: 2},
+		{Key: now.UTC().Format("2006-01-02"), Count: 2},
+	}, stats.UniqueDays)
+
+	// большие наборы сливаются с погрешностью в пределах нескольких процентов
+	var first, second []storage.Click
+	for i := range 2000 {
+		first = append(first, storage.Click{Code: "uniq02", At: now, IP: fmt.Sprintf("10.%d.%d.0", i/256, i%256)})
+		second = append(second, storage.Click{Code: "uniq02", At: now, IP: fmt.Sprintf("10.%d.%d.0", (i+1000)/256, (i+1000)%256)})
+	}
+	require.NoError(t, store.SaveClicks(ctx, first))
+	require.NoError(t, store.SaveClicks(ctx, second))
+	stats, err = store.URLStats(ctx, owner.ID, "uniq02")
+	require.NoError(t, err)
+	assert.InEpsilon(t, 3000, stats.Unique, 0.05)
+	assert.Equal(t, stats.Unique, stats.UniqueWeek)
+
+	_, err = store.DownsampleClicks(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	stats, err = store.URLStats(ctx, owner.ID, "uniq01")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Unique, "daily sketches outlive raw clicks")
+	assert.Len(t, stats.UniqueDays, 2)
+}
+
+func testTrending(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	owner := createUser(t, store)
+	other := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "hot001", URL: "https://hot1.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "hot002", URL: "https://hot2.example.com", UserID: owner.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "hot003", URL: "https://hot3.example.com", UserID: other.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "hot004", URL: "https://hot4.example.com", UserID: owner.ID}))
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	expired := now.Add(-24 * time.Hour)
+	// два экземпляра сервиса сбрасывают свои счётчики независимо
+	require.NoError(t, store.SaveTrending(ctx, []storage.TrendingCount{
+		{Code: "hot001", Minute: now.Add(-2 * time.Hour), Clicks: 10},
+		{Code: "hot001", Minute: now, Clicks: 2},
+		{Code: "hot002", Minute: now, Clicks: 3},
+		{Code: "hot004", Minute: now, Clicks: 1},
+		{Code: "gone99", Minute: now, Clicks: 5},
+	}, expired))
+	require.NoError(t, store.SaveTrending(ctx, []storage.TrendingCount{
+		{Code: "hot001", Minute: now, Clicks: 2},
+		{Code: "hot003", Minute: now, Clicks: 7},
+	}, expired), "counts of the same minute add up")
+	require.NoError(t, store.DeleteUserURLs(ctx, owner.ID, []string{"hot004"}))
+
+	top, err := store.TopLinks(ctx, owner.ID, now.Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.LinkCount{
+		{Code: "hot001", URL: "https://hot1.example.com", UserID: owner.ID, Clicks: 4},
+		{Code: "hot002", URL: "https://hot2.example.com", UserID: owner.ID, Clicks: 3},
+	}, top, "only the user's live links within the window")
+
+	top, err = store.TopLinks(ctx, owner.ID, now.Add(-24*time.Hour), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.LinkCount{{Code: "hot001", URL: "https://hot1.example.com", UserID: owner.ID, Clicks: 14}}, top)
+
+	top, err = store.TopLinks(ctx, 0, now.Add(-time.Hour), 10)
+	require.NoError(t, err)
+	if assert.Len(t, top, 3, "user 0 sees every user's links") {
+		assert.Equal(t, "hot003", top[0].Code)
+		assert.Equal(t, other.ID, top[0].UserID)
+	}
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "calm01", URL: "https://calm.example.com", UserID: owner.ID}))
+	counts, err := store.LinkCounts(ctx, []string{"hot001", "hot003", "hot004", "gone99", "calm01"}, now.Add(-time.Hour))
+	require.NoError(t, err)
+	slices.SortFunc(counts, func(a, b storage.LinkCount) int { return strings.Compare(a.Code, b.Code) })
+	assert.Equal(t, []storage.LinkCount{
+		{Code: "calm01", URL: "https://calm.example.com", UserID: owner.ID},
+		{Code: "hot001", URL: "https://hot1.example.com", UserID: owner.ID, Clicks: 4},
+		{Code: "hot003", URL: "https://hot3.example.com", UserID: other.ID, Clicks: 7},
+	}, counts, "deleted and unknown links are left out, quiet ones count zero")
+
+	require.NoError(t, store.SaveTrending(ctx, nil, now.Add(-time.Hour)))
+	top, err = store.TopLinks(ctx, owner.ID, now.Add(-24*time.Hour), 10)
+	require.NoError(t, err)
+	if assert.Len(t, top, 2) {
+		assert.Equal(t, 4, top[0].Clicks, "expired minutes are dropped")
+	}
+}
+
+// ScopedFactory returns a fresh, empty store that deduplicates URLs within scope
+type ScopedFactory func(t *testing.T, scope string) storage.Storage
+
+// RunDedupScopes checks the non-default deduplication scopes
+func RunDedupScopes(t *testing.T, newStore ScopedFactory) {
+	t.Run("PerUser", func(t *testing.T) {
+		testDedupPerUser(t, newStore(t, storage.DedupPerUser))
+	})
+	t.Run("None", func(t *testing.T) {
+		testDedupNone(t, newStore(t, storage.DedupNone))
+	})
+}
+
+func testDedupPerUser(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	alice, bob := createUser(t, store), createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "alice1", URL: "https://shared.example.com", UserID: alice.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "bob001", URL: "https://shared.example.com", UserID: bob.ID}),
+		"another user may shorten the same URL")
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "anon01", URL: "https://shared.example.com"}))
+
+	err := store.SaveURL(ctx, storage.URL{Code: "alice2", URL: "https://shared.example.com", UserID: alice.ID})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+	err = store.SaveBatchURL(ctx, []storage.URL{{Code: "bob002", URL: "https://shared.example.com", UserID: bob.ID}})
+	assert.ErrorIs(t, err, storage.ErrURLAlreadyExists)
+
+	got, err := store.GetByURL(ctx, alice.ID, "https://shared.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "alice1", got.Code)
+	got, err = store.GetByURL(ctx, bob.ID, "https://shared.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "bob001", got.Code)
+
+	// an edit moves the link into the new URL's namespace of its owner
+	_, err = store.UpdateURL(ctx, bob.ID, "bob001", storage.URLEdit{URL: "https://moved.example.com"})
+	require.NoError(t, err)
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "bob003", URL: "https://shared.example.com", UserID: bob.ID}))
+	_, err = store.UpdateURL(ctx, alice.ID, "alice1", storage.URLEdit{URL: "https://moved.example.com"})
+	assert.NoError(t, err, "the namespace of another user does not matter")
+}
+
+func testDedupNone(t *testing.T, store storage.Storage) {
+	ctx := context.Background()
+	user := createUser(t, store)
+
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "same01", URL: "https://same.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveURL(ctx, storage.URL{Code: "same02", URL: "https://same.example.com", UserID: user.ID}))
+	require.NoError(t, store.SaveBatchURL(ctx, []storage.URL{
+		{Code: "same03", URL: "https://same.example.com", UserID: user.ID},
+		{Code: "same04", URL: "https://same.example.com", UserID: user.ID},
+	}))
+
+	_, err := store.GetByURL(ctx, user.ID, "https://same.example.com")
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+
+	urls, err := store.GetURLsByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 4)
+}
@@ -5,28 +5,75 @@ package storage
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"iter"
+	"time"
 )
 
-// ErrURLAlreadyExists url is already saved in DB
-var ErrURLAlreadyExists = errors.New("url already exists")
+// URL code and original value
+// generate:reset
+type URL struct {
+	Code string
+	URL  string
+	// Input is the URL as submitted, kept for display when
+	// canonicalization changed it; empty otherwise
+	Input  string
+	UserID int
+	// ExpiresAt is the moment the link stops working; zero means never
+	ExpiresAt time.Time
+	// MaxClicks is how many redirects the link allows; zero means unlimited
+	MaxClicks int
+	// Clicks counts the redirects made through a link with MaxClicks
+	Clicks int
+	// RedirectType is the HTTP status redirects through the link answer
+	// with; zero means the server default
+	RedirectType int
+	isDeleted    bool
+	deletedAt    time.Time
+}
+
+// Expired reports whether the link has expired by now
+func (u URL) Expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt)
+}
 
-// ErrCodeAlreadyExists code is already taken
-var ErrCodeAlreadyExists = errors.New("url already taken")
+// DisplayURL returns the URL as the user submitted it
+func (u URL) DisplayURL() string {
+	if u.Input != "" {
+		return u.Input
+	}
+	return u.URL
+}
 
-// ErrURLDeleted code is already deleted
-var ErrURLDeleted = errors.New("url has deleted")
+// Exhausted reports whether the link has used up its clicks
+func (u URL) Exhausted() bool {
+	return u.MaxClicks > 0 && u.Clicks >= u.MaxClicks
+}
 
-// ErrNotImplemented not implemented
-var ErrNotImplemented = errors.New("not implemented")
+// deletedBefore reports whether the link was deleted before before
+func (u URL) deletedBefore(before time.Time) bool {
+	return u.isDeleted && u.deletedAt.Before(before)
+}
 
-// URL code and original value
-// generate:reset
-type URL struct {
-	Code      string
-	URL       string
-	UserID    int
-	isDeleted bool
+// check returns why the link can no longer be followed, if it cannot
+func (u URL) check(now time.Time) error {
+	if u.isDeleted {
+		return ErrURLDeleted
+	}
+	if u.Expired(now) {
+		return fmt.Errorf("url with code %s: %w", u.Code, ErrURLExpired)
+	}
+	if u.Exhausted() {
+		return fmt.Errorf("url with code %s: %w", u.Code, ErrURLExhausted)
+	}
+	return nil
+}
+
+// dead reports whether the link expired or used up its clicks. Dead links
+// keep their code but give up their dedup key, so the URL can be shortened
+// again.
+func (u URL) dead(now time.Time) bool {
+	return u.Expired(now) || u.Exhausted()
 }
 
 // User model
@@ -35,27 +82,89 @@ type User struct {
 	ID int
 }
 
+// URLVersion is one of the targets a link has pointed to
+// generate:reset
+type URLVersion struct {
+	Version int
+	URL     string
+	// Input is the URL as submitted, as in URL
+	Input string
+	// ReplacedAt is when the target was replaced; zero for the current one
+	ReplacedAt time.Time
+}
+
+// DisplayURL returns the target as the user submitted it
+func (v URLVersion) DisplayURL() string {
+	if v.Input != "" {
+		return v.Input
+	}
+	return v.URL
+}
+
+// URLEdit is a change of a link, as given to UpdateURL
+type URLEdit struct {
+	// URL is the new target; empty keeps the current one. The current
+	// target with another Input only changes the spelling.
+	URL string
+	// Input is the URL as the user submitted it when it differs from URL
+	Input string
+	// RedirectType, when set, is the new redirect status, zero for the
+	// server default
+	RedirectType *int
+}
+
 // URLStorage defines methods for saving and retrieving URLs
 type URLStorage interface {
 	SaveURL(ctx context.Context, u URL) error
 	GetURL(ctx context.Context, code string) (URL, error)
-	GetByURL(ctx context.Context, url string) (URL, error)
+	// FollowURL is GetURL for a redirect: it also spends one click of a
+	// link with MaxClicks, atomically, so concurrent redirects never
+	// exceed the limit
+	FollowURL(ctx context.Context, code string) (URL, error)
+	// GetByURL returns the link that keeps the user from shortening url
+	// again under the configured dedup scope. Expired and used up links
+	// keep nobody from it.
+	GetByURL(ctx context.Context, userID int, url string) (URL, error)
 	GetURLsByUserID(ctx context.Context, userID int) ([]URL, error)
-	AllURLs(ctx context.Context) ([]URL, error)
+	AllURLs(ctx context.Context) iter.Seq2[URL, error]
 	SaveBatchURL(ctx context.Context, urls []URL) error
 	DeleteUserURLs(ctx context.Context, userID int, codes []string) error
+	// UpdateURL applies edit to the user's link at once: either all of it
+	// or, on error, none. A replaced target is kept in the history. Links
+	// of other users are not found.
+	UpdateURL(ctx context.Context, userID int, code string, edit URLEdit) (URL, error)
+	// URLHistory returns every target of the user's link, oldest first;
+	// the last one is the current target
+	URLHistory(ctx context.Context, userID int, code string) ([]URLVersion, error)
+	// RestoreUserURLs undeletes the user's links deleted after since and
+	// returns the codes of the restored ones
+	RestoreUserURLs(ctx context.Context, userID int, codes []string, since time.Time) ([]string, error)
+	// PurgeDeletedURLs permanently removes links deleted before before,
+	// freeing their codes and URLs, and returns how many were removed
+	PurgeDeletedURLs(ctx context.Context, before time.Time) (int, error)
 }
 
 // UserStorage defines methods for user management
 type UserStorage interface {
 	CreateUser(ctx context.Context) (User, error)
-	GetAllUsers(ctx context.Context) ([]User, error)
+	GetUser(ctx context.Context, id int) (User, error)
+	GetAllUsers(ctx context.Context) iter.Seq2[User, error]
+}
+
+// IDLeaser hands out disjoint blocks of IDs that short codes are derived from
+type IDLeaser interface {
+	// LeaseIDs reserves size consecutive IDs and returns the first one.
+	// No other lease, in this or another instance, gets any of them.
+	LeaseIDs(ctx context.Context, size int) (int64, error)
 }
 
 // Storage defines methods
 type Storage interface {
 	URLStorage
 	UserStorage
+	ClickStorage
+	TrendingStorage
+	IDLeaser
 	Close() error
 	Ping(ctx context.Context) error
 }
@@ -1,23 +1,33 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
 	"os"
-	"strconv"
+	"path/filepath"
+	"time"
 
 	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
 	"go.uber.org/zap"
 )
 
+// savedURLItem is a URL in the legacy JSON array file format
 // generate:reset
 type savedURLItem struct {
 	UUID        string `json:"uuid"`
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
 	UserID      int    `json:"user_id"`
+	IsDeleted   bool   `json:"is_deleted,omitempty"`
 }
 
+// savedUserItem is a user in the legacy JSON array file format
 // generate:reset
 type savedUserItem struct {
 	UUID string `json:"uuid"`
@@ -26,88 +36,410 @@ type savedUserItem struct {
 
 const userFilePrefix = "user_"
 
-func loadFromFile(filePath string, data any) {
+// errTornRecord a record is cut off or unreadable
+var errTornRecord = errors.New("torn record")
+
+// userRestorer is implemented by storages that can recreate a user with a known ID
+type userRestorer interface {
+	restoreUser(user User)
+}
+
+// clickRestorer is implemented by storages that can replay a spent click
+type clickRestorer interface {
+	restoreClick(code string)
+}
+
+// historyKeeper is implemented by storages that keep link history in memory
+type historyKeeper interface {
+	restoreUpdate(code string, edit URLEdit, prev *URLVersion)
+	allHistory() iter.Seq2[string, []URLVersion]
+	restoreHistory(code string, versions []URLVersion)
+}
+
+// lifecycleKeeper is implemented by storages that can replay deletes,
+// restores and purges exactly as they happened
+type lifecycleKeeper interface {
+	markDeleted(userID int, codes []string, at time.Time)
+	restoreUserURLs(userID int, codes []string, since time.Time, commit func(restored []string) error) ([]string, error)
+	removeURLs(codes []string)
+}
+
+// clickKeeper is implemented by storages that keep clicks and their
+// rollups in memory
+type clickKeeper interface {
+	allClicks() iter.Seq2[string, []Click]
+	allRollups() iter.Seq2[string, []rollup]
+	restoreRollups(code string, rollups []rollup)
+	allSketches() iter.Seq2[string, []daySketch]
+	restoreSketches(code string, sketches []daySketch)
+}
+
+// trendingKeeper is implemented by storages that keep trending counters in
+// memory
+type trendingKeeper interface {
+	allTrending() iter.Seq2[string, []TrendingCount]
+}
+
+// leaseKeeper is implemented by storages that keep leased ID blocks in memory
+type leaseKeeper interface {
+	leasedUpTo() int64
+	restoreLease(next int64)
+}
+
+// LoadData restores store from the snapshot at filePath and replays the
+// write-ahead log written after it. A torn record at the end of the log,
+// left by a crash in the middle of a write, is cut off.
+// Snapshots in the legacy JSON array format are still accepted.
+func LoadData(filePath string, store Storage) error {
+	if err := loadSnapshot(filePath, store); err != nil {
+		return err
+	}
+	return replayLog(walPath(filePath), store)
+}
+
+func loadSnapshot(filePath string, store Storage) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return
+			return nil
 		}
-		logger.Log.Fatal("open file error", zap.Error(err))
+		return fmt.Errorf("open snapshot: %w", err)
 	}
 	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(data)
+	reader := bufio.NewReader(file)
+	if isLegacyFormat(reader) {
+		loadLegacy(reader, filePath, store)
+		return nil
+	}
+
+	if _, err := readRecords(reader, func(rec walRecord) { applyRecord(store, rec) }); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	return nil
+}
+
+func replayLog(path string, store Storage) error {
+	file, err := os.Open(path)
 	if err != nil {
-		logger.Log.Error("JSON decoding error", zap.Error(err))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("open wal: %w", err)
+	}
+	defer file.Close()
+
+	offset, err := readRecords(file, func(rec walRecord) { applyRecord(store, rec) })
+	if errors.Is(err, errTornRecord) {
+		logger.Log.Warn("cut torn wal tail", zap.Int64("offset", offset), zap.Error(err))
+		return os.Truncate(path, offset)
 	}
+	return err
 }
 
-// LoadData load data from file
-func LoadData(filePath string, store Storage) {
-	var savedData []savedURLItem
-	loadFromFile(filePath, &savedData)
-	for _, item := range savedData {
-		store.SaveURL(context.TODO(), URL{Code: item.ShortURL, URL: item.OriginalURL, UserID: item.UserID})
+// readRecords passes every complete JSONL record of r to fn and returns the
+// number of bytes they took. An unreadable last record is errTornRecord;
+// an unreadable record anywhere else is an error of its own.
+func readRecords(r io.Reader, fn func(walRecord)) (int64, error) {
+	reader := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				return offset, errTornRecord
+			}
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// only the last record can be torn by a crash; a bad record with
+			// more after it is corruption, and skipping it would lose writes
+			if onlySpaceLeft(reader) {
+				return offset, fmt.Errorf("%w: %v", errTornRecord, err)
+			}
+			return offset, fmt.Errorf("corrupt record at offset %d: %w", offset, err)
+		}
+		fn(rec)
+		offset += int64(len(line))
 	}
+}
 
-	var savedUsers []savedUserItem
-	loadFromFile(userFilePrefix+filePath, &savedUsers)
-	for _, item := range savedUsers {
-		store.(*MemoryStorage).Users[item.ID] = User{ID: item.ID}
+// onlySpaceLeft reports whether r has nothing but whitespace left
+func onlySpaceLeft(r *bufio.Reader) bool {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err == io.EOF
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return false
+		}
 	}
 }
 
-func saveDataToFile(filePath string, data any) {
-	file, err := os.Create(filePath)
-	if err != nil {
-		logger.Log.Error("file creation error", zap.Error(err))
-		return
+// applyRecord replays rec against store. Replaying a record twice is
+// harmless: repeated creates fail on uniqueness and are skipped.
+func applyRecord(store Storage, rec walRecord) {
+	ctx := context.TODO()
+	switch rec.Op {
+	case walOpCreate:
+		urls := make([]URL, 0, len(rec.URLs))
+		for _, u := range rec.URLs {
+			urls = append(urls, u.toURL())
+		}
+		if err := store.SaveBatchURL(ctx, urls); err != nil {
+			logger.Log.Debug("skip replayed urls", zap.Error(err))
+		}
+	case walOpDelete:
+		if k, ok := store.(lifecycleKeeper); ok && rec.At != nil {
+			k.markDeleted(rec.UserID, rec.Codes, *rec.At)
+		} else if err := store.DeleteUserURLs(ctx, rec.UserID, rec.Codes); err != nil {
+			logger.Log.Error("replay delete error", zap.Error(err))
+		}
+	case walOpRestore:
+		if k, ok := store.(lifecycleKeeper); ok {
+			if _, err := k.restoreUserURLs(rec.UserID, rec.Codes, time.Time{}, nil); err != nil {
+				logger.Log.Error("replay restore error", zap.Error(err))
+			}
+		}
+	case walOpPurge:
+		if k, ok := store.(lifecycleKeeper); ok {
+			k.removeURLs(rec.Codes)
+		}
+	case walOpUser:
+		if r, ok := store.(userRestorer); ok {
+			r.restoreUser(User{ID: rec.UserID})
+		}
+	case walOpLease:
+		if k, ok := store.(leaseKeeper); ok {
+			k.restoreLease(rec.NextID)
+		}
+	case walOpUpdate:
+		if k, ok := store.(historyKeeper); ok && len(rec.URLs) == 1 && len(rec.Versions) <= 1 {
+			u := rec.URLs[0]
+			edit := URLEdit{URL: u.URL, Input: u.Input, RedirectType: rec.RedirectType}
+			var prev *URLVersion
+			if len(rec.Versions) == 1 {
+				v := URLVersion(rec.Versions[0])
+				prev = &v
+			}
+			k.restoreUpdate(u.Code, edit, prev)
+		}
+	case walOpRedirect:
+		if k, ok := store.(historyKeeper); ok {
+			for _, u := range rec.URLs {
+				k.restoreUpdate(u.Code, URLEdit{RedirectType: &u.RedirectType}, nil)
+			}
+		}
+	case walOpHistory:
+		if k, ok := store.(historyKeeper); ok && len(rec.Codes) == 1 {
+			k.restoreHistory(rec.Codes[0], toURLVersions(rec.Versions))
+		}
+	case walOpClick:
+		if r, ok := store.(clickRestorer); ok {
+			for _, code := range rec.Codes {
+				r.restoreClick(code)
+			}
+		}
+	case walOpVisits:
+		if err := store.SaveClicks(ctx, toClicks(rec.Clicks)); err != nil {
+			logger.Log.Error("replay clicks error", zap.Error(err))
+		}
+	case walOpRollups:
+		if k, ok := store.(clickKeeper); ok && len(rec.Codes) == 1 {
+			k.restoreRollups(rec.Codes[0], toRollups(rec.Rollups))
+		}
+	case walOpSketches:
+		if k, ok := store.(clickKeeper); ok && len(rec.Codes) == 1 {
+			k.restoreSketches(rec.Codes[0], toDaySketches(rec.Codes[0], rec.Sketches))
+		}
+	case walOpTrending:
+		var expired time.Time
+		if rec.At != nil {
+			expired = *rec.At
+		}
+		if err := store.SaveTrending(ctx, toTrendingCounts(rec.Trending), expired); err != nil {
+			logger.Log.Error("replay trending error", zap.Error(err))
+		}
+	case walOpDownsample:
+		if rec.At == nil {
+			break
+		}
+		if _, err := store.DownsampleClicks(ctx, *rec.At); err != nil {
+			logger.Log.Error("replay downsample error", zap.Error(err))
+		}
+	default:
+		logger.Log.Warn("unknown wal record", zap.String("op", rec.Op))
 	}
-	defer file.Close()
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(data); err != nil {
-		logger.Log.Error("JSON encoding error", zap.Error(err))
+// isLegacyFormat reports whether the snapshot is a JSON array
+// written by earlier versions
+func isLegacyFormat(r *bufio.Reader) bool {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		r.UnreadByte()
+		return b == '['
 	}
 }
 
-// SaveData save data in file
-func SaveData(filePath string, store Storage) {
-	var saveURLData []savedURLItem
+func loadLegacy(r io.Reader, filePath string, store Storage) {
+	decodeArray(r, func(item savedURLItem) {
+		url := URL{Code: item.ShortURL, URL: item.OriginalURL, UserID: item.UserID, isDeleted: item.IsDeleted}
+		if item.IsDeleted {
+			// legacy deletions kept no time, so their retention starts now
+			url.deletedAt = time.Now()
+		}
+		if err := store.SaveURL(context.TODO(), url); err != nil {
+			logger.Log.Warn("skip saved url", zap.String("code", item.ShortURL), zap.Error(err))
+		}
+	})
 
-	urls, err := store.AllURLs(context.TODO())
+	restorer, ok := store.(userRestorer)
+	if !ok {
+		return
+	}
+	file, err := os.Open(userFilePrefix + filePath)
 	if err != nil {
-		logger.Log.Error("load urls error", zap.Error(err))
+		if !os.IsNotExist(err) {
+			logger.Log.Error("open file error", zap.Error(err))
+		}
+		return
+	}
+	defer file.Close()
+	decodeArray(file, func(item savedUserItem) {
+		restorer.restoreUser(User{ID: item.ID})
+	})
+}
+
+// decodeArray decodes a JSON array element by element,
+// so the whole file is never held in memory.
+func decodeArray[T any](r io.Reader, fn func(T)) {
+	decoder := json.NewDecoder(r)
+	if _, err := decoder.Token(); err != nil {
+		logger.Log.Error("JSON decoding error", zap.Error(err))
 		return
 	}
-	i := 1
-	for _, url := range urls {
-		item := savedURLItem{
-			UUID:        strconv.Itoa(i),
-			ShortURL:    url.Code,
-			OriginalURL: url.URL,
-			UserID:      url.UserID,
+	for decoder.More() {
+		var item T
+		if err := decoder.Decode(&item); err != nil {
+			logger.Log.Error("JSON decoding error", zap.Error(err))
+			return
+		}
+		fn(item)
+	}
+}
+
+// SaveData writes a snapshot of store to filePath: the lease position, then
+// one JSONL record per user, per URL, per link history, per link clicks,
+// per link rollups, per link visitor sketches and per link trending counts.
+// Rollups come after clicks and replace the counts that replaying the
+// clicks adds up.
+// Data goes to a temporary file that replaces the previous snapshot only
+// once it is fully on disk.
+func SaveData(filePath string, store Storage) error {
+	records := func(yield func(walRecord, error) bool) {
+		if k, ok := store.(leaseKeeper); ok {
+			if !yield(walRecord{Op: walOpLease, NextID: k.leasedUpTo()}, nil) {
+				return
+			}
+		}
+		for user, err := range store.GetAllUsers(context.TODO()) {
+			if !yield(walRecord{Op: walOpUser, UserID: user.ID}, err) || err != nil {
+				return
+			}
+		}
+		for url, err := range store.AllURLs(context.TODO()) {
+			if !yield(walRecord{Op: walOpCreate, URLs: []walURL{newWALURL(url)}}, err) || err != nil {
+				return
+			}
+		}
+		if k, ok := store.(historyKeeper); ok {
+			for code, versions := range k.allHistory() {
+				if !yield(walRecord{Op: walOpHistory, Codes: []string{code}, Versions: newWALVersions(versions)}, nil) {
+					return
+				}
+			}
+		}
+		if k, ok := store.(clickKeeper); ok {
+			for _, clicks := range k.allClicks() {
+				if !yield(walRecord{Op: walOpVisits, Clicks: newWALClicks(clicks)}, nil) {
+					return
+				}
+			}
+			for code, rollups := range k.allRollups() {
+				if !yield(walRecord{Op: walOpRollups, Codes: []string{code}, Rollups: newWALRollups(rollups)}, nil) {
+					return
+				}
+			}
+			for code, sketches := range k.allSketches() {
+				if !yield(walRecord{Op: walOpSketches, Codes: []string{code}, Sketches: newWALSketches(sketches)}, nil) {
+					return
+				}
+			}
+		}
+		if k, ok := store.(trendingKeeper); ok {
+			for _, counts := range k.allTrending() {
+				if !yield(walRecord{Op: walOpTrending, Trending: newWALTrending(counts)}, nil) {
+					return
+				}
+			}
 		}
-		saveURLData = append(saveURLData, item)
-		i++
 	}
-	saveDataToFile(filePath, saveURLData)
+	return writeSnapshot(filePath, records)
+}
 
-	// Save users
-	var saveUserData []savedUserItem
-	users, err := store.GetAllUsers(context.TODO())
+func writeSnapshot(filePath string, records iter.Seq2[walRecord, error]) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
 	if err != nil {
-		logger.Log.Error("load users error", zap.Error(err))
-		return
+		return fmt.Errorf("file creation error: %w", err)
 	}
-	for _, user := range users {
-		item := savedUserItem{
-			UUID: strconv.Itoa(user.ID),
-			ID:   user.ID,
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for rec, err := range records {
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(rec); err != nil {
+			return fmt.Errorf("JSON encoding error: %w", err)
 		}
-		saveUserData = append(saveUserData, item)
 	}
-	saveDataToFile(userFilePrefix+filePath, saveUserData)
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(filePath))
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
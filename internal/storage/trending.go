@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+)
+
+// TrendingCount is the number of redirects through a link in one minute
+// generate:reset
+type TrendingCount struct {
+	Code string
+	// Minute is the UTC start of the minute
+	Minute time.Time
+	Clicks int
+}
+
+// LinkCount is the number of redirects through a link within a window
+// generate:reset
+type LinkCount struct {
+	Code   string
+	URL    string
+	UserID int
+	Clicks int
+}
+
+// TrendingStorage keeps per-minute redirect counters that every instance
+// adds its own counts to, so the leaderboard covers all of them
+type TrendingStorage interface {
+	// SaveTrending adds counts to the counters of existing links and drops
+	// the counters of minutes before expired
+	SaveTrending(ctx context.Context, counts []TrendingCount, expired time.Time) error
+	// TopLinks returns up to limit links of the user, or of every user when
+	// userID is 0, with the most redirects since since, most first, then by
+	// code. Deleted links are left out.
+	TopLinks(ctx context.Context, userID int, since time.Time, limit int) ([]LinkCount, error)
+	// LinkCounts returns the redirects since since of the links with
+	// codes, in no particular order. Deleted and unknown links are left
+	// out; links without redirects count zero.
+	LinkCounts(ctx context.Context, codes []string, since time.Time) ([]LinkCount, error)
+}
+
+// sortLinkCounts orders counts the way TopLinks promises and keeps the
+// first limit of them
+func sortLinkCounts(counts []LinkCount, limit int) []LinkCount {
+	slices.SortFunc(counts, func(a, b LinkCount) int {
+		return cmp.Or(cmp.Compare(b.Clicks, a.Clicks), cmp.Compare(a.Code, b.Code))
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
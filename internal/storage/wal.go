@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Quickaxe-Martina/link_shortening_service/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Sync policies of the write-ahead log
+const (
+	// SyncAlways fsyncs the log after every record
+	SyncAlways = "always"
+	// SyncInterval fsyncs the log periodically in the background
+	SyncInterval = "interval"
+	// SyncNever leaves flushing to the operating system
+	SyncNever = "never"
+)
+
+// Operations recorded in the write-ahead log and in snapshots
+const (
+	walOpCreate = "create"
+	walOpDelete = "delete"
+	walOpUser   = "user"
+	walOpLease  = "lease"
+	walOpClick  = "click"
+	// walOpRestore undeletes links, walOpPurge removes them for good
+	walOpRestore = "restore"
+	walOpPurge   = "purge"
+	// walOpUpdate changes the target and/or the redirect status of a link;
+	// walOpHistory, written only to snapshots, restores the previous
+	// targets of one link
+	walOpUpdate  = "update"
+	walOpHistory = "history"
+	// walOpRedirect sets the redirect status of a link. It is no longer
+	// written, walOpUpdate carries the status, but older logs replay it.
+	walOpRedirect = "redirect"
+	// walOpVisits records clicks for analytics, unlike walOpClick, which
+	// spends the clicks of a limited link
+	walOpVisits = "visits"
+	// walOpRollups, written only to snapshots, restores the click rollups of
+	// one link; walOpDownsample drops raw clicks and hourly rollups older
+	// than At
+	walOpRollups    = "rollups"
+	walOpDownsample = "downsample"
+	// walOpSketches, written only to snapshots, restores the daily visitor
+	// sketches of one link
+	walOpSketches = "sketches"
+	// walOpTrending adds trending counts and drops the ones of minutes
+	// before At, if set
+	walOpTrending = "trending"
+)
+
+// walRecord is a single JSONL line of the write-ahead log or of a snapshot.
+// A create record carries every URL of one SaveBatchURL call, so a batch is
+// persisted with a single write and replayed atomically.
+// generate:reset
+type walRecord struct {
+	Op       string       `json:"op"`
+	URLs     []walURL     `json:"urls,omitempty"`
+	UserID   int          `json:"user_id,omitempty"`
+	Codes    []string     `json:"codes,omitempty"`
+	NextID   int64        `json:"next_id,omitempty"`
+	Versions []walVersion `json:"versions,omitempty"`
+	Clicks   []walClick   `json:"clicks,omitempty"`
+	Rollups  []walRollup  `json:"rollups,omitempty"`
+	Sketches []walSketch  `json:"sketches,omitempty"`
+	Trending []walTrend   `json:"trending,omitempty"`
+	// RedirectType is the status an update sets, left out when it keeps
+	// the current one
+	RedirectType *int `json:"redirect_type,omitempty"`
+	// At is when a delete happened; retention is counted from it.
+	// For downsampling and trending counts it is the cutoff.
+	At *time.Time `json:"at,omitempty"`
+}
+
+// walURL is a URL as stored in the write-ahead log
+// generate:reset
+type walURL struct {
+	Code      string     `json:"code"`
+	URL       string     `json:"url"`
+	Input     string     `json:"input,omitempty"`
+	UserID    int        `json:"user_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks int        `json:"max_clicks,omitempty"`
+	Clicks    int        `json:"clicks,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// RedirectType is left out for links that use the server default
+	RedirectType int `json:"redirect_type,omitempty"`
+}
+
+func newWALURL(u URL) walURL {
+	w := walURL{Code: u.Code, URL: u.URL, Input: u.Input, UserID: u.UserID, MaxClicks: u.MaxClicks, Clicks: u.Clicks, Deleted: u.isDeleted, RedirectType: u.RedirectType}
+	if !u.ExpiresAt.IsZero() {
+		w.ExpiresAt = &u.ExpiresAt
+	}
+	if u.isDeleted {
+		w.DeletedAt = &u.deletedAt
+	}
+	return w
+}
+
+func (w walURL) toURL() URL {
+	u := URL{Code: w.Code, URL: w.URL, Input: w.Input, UserID: w.UserID, MaxClicks: w.MaxClicks, Clicks: w.Clicks, RedirectType: w.RedirectType, isDeleted: w.Deleted}
+	if w.ExpiresAt != nil {
+		u.ExpiresAt = *w.ExpiresAt
+	}
+	if w.Deleted {
+		// links deleted before deletion times were kept start their retention now
+		u.deletedAt = time.Now()
+		if w.DeletedAt != nil {
+			u.deletedAt = *w.DeletedAt
+		}
+	}
+	return u
+}
+
+// walVersion is a previous target of a link as stored in the write-ahead log
+// generate:reset
+type walVersion struct {
+	Version    int       `json:"version"`
+	URL        string    `json:"url"`
+	Input      string    `json:"input,omitempty"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+func newWALVersions(versions []URLVersion) []walVersion {
+	ws := make([]walVersion, 0, len(versions))
+	for _, v := range versions {
+		ws = append(ws, walVersion(v))
+	}
+	return ws
+}
+
+func toURLVersions(ws []walVersion) []URLVersion {
+	versions := make([]URLVersion, 0, len(ws))
+	for _, w := range ws {
+		versions = append(versions, URLVersion(w))
+	}
+	return versions
+}
+
+// walClick is a click as stored in the write-ahead log
+// generate:reset
+type walClick struct {
+	Code      string    `json:"code"`
+	At        time.Time `json:"at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Bot       bool      `json:"bot,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	ASN       int       `json:"asn,omitempty"`
+}
+
+func newWALClicks(clicks []Click) []walClick {
+	ws := make([]walClick, 0, len(clicks))
+	for _, c := range clicks {
+		ws = append(ws, walClick(c))
+	}
+	return ws
+}
+
+func toClicks(ws []walClick) []Click {
+	clicks := make([]Click, 0, len(ws))
+	for _, w := range ws {
+		clicks = append(clicks, Click(w))
+	}
+	return clicks
+}
+
+// walRollup is a click rollup as stored in the write-ahead log
+// generate:reset
+type walRollup struct {
+	Code     string    `json:"code"`
+	Interval string    `json:"interval"`
+	Start    time.Time `json:"start"`
+	Clicks   int       `json:"clicks"`
+	Bots     int       `json:"bots,omitempty"`
+}
+
+func newWALRollups(rollups []rollup) []walRollup {
+	ws := make([]walRollup, 0, len(rollups))
+	for _, r := range rollups {
+		ws = append(ws, walRollup(r))
+	}
+	return ws
+}
+
+func toRollups(ws []walRollup) []rollup {
+	rollups := make([]rollup, 0, len(ws))
+	for _, w := range ws {
+		rollups = append(rollups, rollup(w))
+	}
+	return rollups
+}
+
+// walSketch is a daily visitor sketch as stored in the write-ahead log
+// generate:reset
+type walSketch struct {
+	Day    time.Time `json:"day"`
+	Sketch []byte    `json:"sketch"`
+}
+
+func newWALSketches(sketches []daySketch) []walSketch {
+	ws := make([]walSketch, 0, len(sketches))
+	for _, d := range sketches {
+		ws = append(ws, walSketch{Day: d.Day, Sketch: d.Sketch.marshal()})
+	}
+	return ws
+}
+
+// toDaySketches decodes the sketches of the link with code, skipping
+// malformed ones
+func toDaySketches(code string, ws []walSketch) []daySketch {
+	sketches := make([]daySketch, 0, len(ws))
+	for _, w := range ws {
+		s, err := unmarshalSketch(w.Sketch)
+		if err != nil {
+			logger.Log.Warn("skip saved sketch", zap.String("code", code), zap.Error(err))
+			continue
+		}
+		sketches = append(sketches, daySketch{Code: code, Day: w.Day, Sketch: s})
+	}
+	return sketches
+}
+
+// walTrend is a trending count as stored in the write-ahead log
+// generate:reset
+type walTrend struct {
+	Code   string    `json:"code"`
+	Minute time.Time `json:"minute"`
+	Clicks int       `json:"clicks"`
+}
+
+func newWALTrending(counts []TrendingCount) []walTrend {
+	ws := make([]walTrend, 0, len(counts))
+	for _, c := range counts {
+		ws = append(ws, walTrend(c))
+	}
+	return ws
+}
+
+func toTrendingCounts(ws []walTrend) []TrendingCount {
+	counts := make([]TrendingCount, 0, len(ws))
+	for _, w := range ws {
+		counts = append(counts, TrendingCount(w))
+	}
+	return counts
+}
+
+// walPath returns the log file that belongs to the snapshot at filePath
+func walPath(filePath string) string {
+	return filePath + ".wal"
+}
+
+// walWriter appends records to the write-ahead log.
+// Every record reaches the operating system with its own write call,
+// so a crashed process loses nothing; the sync policy only decides how
+// much a power loss can take.
+type walWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	policy string
+	dirty  bool
+}
+
+func openWAL(path string, policy string) (*walWriter, error) {
+	switch policy {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("unknown sync policy %q", policy)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	return &walWriter{file: file, policy: policy}, nil
+}
+
+// append writes rec as one line of the log
+func (w *walWriter) append(rec walRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(b); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+	if w.policy == SyncAlways {
+		return w.file.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+// sync flushes the log to disk if anything was written since the last sync
+func (w *walWriter) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+// truncate drops all records once they are covered by a snapshot
+func (w *walWriter) truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+func (w *walWriter) close() error {
+	if err := w.sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
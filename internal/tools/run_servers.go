@@ -19,6 +19,10 @@ func shutdown(
 	pprofServer *http.Server,
 	store storage.Storage,
 	deleteWorker *repository.DeleteURLsWorkers,
+	purgeWorker *repository.PurgeWorker,
+	downsampleWorker *repository.DownsampleWorker,
+	clickWorkers *repository.ClickWorkers,
+	trending *repository.TrendingCounter,
 	audit *repository.AuditPublisher,
 ) error {
 	logger.Log.Info("shutdown signal received")
@@ -31,6 +35,10 @@ func shutdown(
 	_ = pprofServer.Shutdown(ctx)
 
 	deleteWorker.Stop()
+	purgeWorker.Stop()
+	downsampleWorker.Stop()
+	clickWorkers.Stop()
+	trending.Stop()
 	audit.Stop()
 	store.Close()
 
@@ -46,6 +54,10 @@ func RunServers(
 	pprofServer *http.Server,
 	store storage.Storage,
 	deleteWorker *repository.DeleteURLsWorkers,
+	purgeWorker *repository.PurgeWorker,
+	downsampleWorker *repository.DownsampleWorker,
+	clickWorkers *repository.ClickWorkers,
+	trending *repository.TrendingCounter,
 	audit *repository.AuditPublisher,
 ) {
 	g, gCtx := errgroup.WithContext(ctx)
@@ -68,7 +80,7 @@ func RunServers(
 
 	g.Go(func() error {
 		<-gCtx.Done()
-		return shutdown(cfg, httpServer, pprofServer, store, deleteWorker, audit)
+		return shutdown(cfg, httpServer, pprofServer, store, deleteWorker, purgeWorker, downsampleWorker, clickWorkers, trending, audit)
 	})
 
 	if err := g.Wait(); err != nil {
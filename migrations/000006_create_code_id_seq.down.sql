@@ -0,0 +1 @@
+DROP SEQUENCE IF EXISTS code_id_seq;
@@ -0,0 +1 @@
+CREATE SEQUENCE IF NOT EXISTS code_id_seq;
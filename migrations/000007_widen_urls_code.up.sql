@@ -0,0 +1,2 @@
+ALTER TABLE urls
+ALTER COLUMN code TYPE VARCHAR(64);
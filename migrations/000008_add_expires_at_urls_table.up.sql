@@ -0,0 +1,2 @@
+ALTER TABLE urls
+ADD COLUMN expires_at TIMESTAMPTZ NULL DEFAULT NULL;
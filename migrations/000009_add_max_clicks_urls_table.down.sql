@@ -0,0 +1,3 @@
+ALTER TABLE urls
+DROP COLUMN clicks,
+DROP COLUMN max_clicks;
@@ -0,0 +1,3 @@
+ALTER TABLE urls
+ADD COLUMN max_clicks INT NULL DEFAULT NULL,
+ADD COLUMN clicks INT NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+CREATE TABLE url_history (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL REFERENCES urls(code) ON DELETE CASCADE,
+    version INT NOT NULL,
+    url TEXT NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_url_history_version ON url_history(code, version);
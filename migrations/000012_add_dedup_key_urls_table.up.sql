@@ -0,0 +1,11 @@
+-- Uniqueness of original URLs moves from url itself to dedup_key, which the
+-- service fills according to the dedup scope: the URL for the global scope,
+-- user ID and URL for the per-user one, NULL when links are not deduplicated.
+ALTER TABLE urls
+ADD COLUMN dedup_key TEXT NULL DEFAULT NULL;
+
+UPDATE urls SET dedup_key = url;
+
+DROP INDEX IF EXISTS idx_urls_url;
+CREATE INDEX idx_urls_url ON urls(url);
+CREATE UNIQUE INDEX idx_urls_dedup_key ON urls(dedup_key);
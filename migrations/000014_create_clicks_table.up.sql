@@ -0,0 +1,10 @@
+CREATE TABLE clicks (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL REFERENCES urls(code) ON DELETE CASCADE,
+    ts TIMESTAMPTZ NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_clicks_code_ts ON clicks(code, ts);
@@ -0,0 +1,17 @@
+CREATE TABLE click_rollups (
+    code VARCHAR(64) NOT NULL REFERENCES urls(code) ON DELETE CASCADE,
+    bucket_interval TEXT NOT NULL,
+    bucket_start TIMESTAMPTZ NOT NULL,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (code, bucket_interval, bucket_start)
+);
+
+CREATE INDEX idx_clicks_ts ON clicks(ts);
+
+INSERT INTO click_rollups (code, bucket_interval, bucket_start, clicks)
+SELECT code, 'hour', date_trunc('hour', ts AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', COUNT(*)
+FROM clicks GROUP BY 1, 3;
+
+INSERT INTO click_rollups (code, bucket_interval, bucket_start, clicks)
+SELECT code, 'day', date_trunc('day', ts AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', COUNT(*)
+FROM clicks GROUP BY 1, 3;
@@ -0,0 +1,2 @@
+ALTER TABLE click_rollups DROP COLUMN IF EXISTS bots;
+ALTER TABLE clicks DROP COLUMN IF EXISTS is_bot;
@@ -0,0 +1,3 @@
+ALTER TABLE clicks DROP COLUMN IF EXISTS asn;
+ALTER TABLE clicks DROP COLUMN IF EXISTS region;
+ALTER TABLE clicks DROP COLUMN IF EXISTS country;
@@ -0,0 +1,3 @@
+ALTER TABLE clicks ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE clicks ADD COLUMN region TEXT NOT NULL DEFAULT '';
+ALTER TABLE clicks ADD COLUMN asn INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE urls
+ADD COLUMN redirect_type INT NULL DEFAULT NULL;
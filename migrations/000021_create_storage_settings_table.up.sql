@@ -0,0 +1,9 @@
+-- Settings the stored data was written for. dedup_scope is the scope the
+-- dedup keys were computed for; the service recomputes them when it starts
+-- with another one. The dedup_key migration filled in global keys.
+CREATE TABLE storage_settings (
+    name VARCHAR(64) PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+INSERT INTO storage_settings (name, value) VALUES ('dedup_scope', 'global');
@@ -0,0 +1,2 @@
+ALTER TABLE url_history
+DROP COLUMN input_url;
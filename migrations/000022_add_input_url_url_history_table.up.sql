@@ -0,0 +1,2 @@
+ALTER TABLE url_history
+ADD COLUMN input_url TEXT NULL DEFAULT NULL;
@@ -0,0 +1,13 @@
+-- SQLite cannot drop a column that takes part in a foreign key,
+-- so the table is rebuilt without it
+CREATE TABLE urls_without_user (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code VARCHAR(10) NOT NULL,
+    url TEXT NOT NULL
+);
+INSERT INTO urls_without_user (id, code, url) SELECT id, code, url FROM urls;
+DROP TABLE urls;
+ALTER TABLE urls_without_user RENAME TO urls;
+
+CREATE UNIQUE INDEX idx_urls_code ON urls(code);
+CREATE UNIQUE INDEX idx_urls_url ON urls(url);
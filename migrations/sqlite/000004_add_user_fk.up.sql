@@ -0,0 +1,3 @@
+ALTER TABLE urls
+ADD COLUMN user_id INTEGER NULL DEFAULT NULL
+REFERENCES users(id) ON DELETE SET NULL;
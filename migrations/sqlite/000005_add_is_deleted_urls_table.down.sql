@@ -0,0 +1,2 @@
+ALTER TABLE urls
+DROP COLUMN is_deleted;
@@ -0,0 +1,2 @@
+ALTER TABLE urls
+ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
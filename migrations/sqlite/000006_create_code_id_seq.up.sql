@@ -0,0 +1,7 @@
+-- SQLite has no sequences: a single row counter plays the same role
+CREATE TABLE code_id_lease (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    next_id INTEGER NOT NULL
+);
+
+INSERT INTO code_id_lease (id, next_id) VALUES (1, 1);
@@ -0,0 +1,4 @@
+ALTER TABLE urls
+DROP COLUMN clicks;
+ALTER TABLE urls
+DROP COLUMN max_clicks;
@@ -0,0 +1,4 @@
+ALTER TABLE urls
+ADD COLUMN max_clicks INTEGER NULL DEFAULT NULL;
+ALTER TABLE urls
+ADD COLUMN clicks INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+CREATE TABLE url_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code VARCHAR(64) NOT NULL REFERENCES urls(code) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    replaced_at DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_url_history_version ON url_history(code, version);
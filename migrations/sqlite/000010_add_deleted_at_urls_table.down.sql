@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_urls_deleted_at;
+
+ALTER TABLE urls
+DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+ALTER TABLE urls
+ADD COLUMN deleted_at DATETIME NULL DEFAULT NULL;
+
+-- links deleted before the column existed start their retention now
+UPDATE urls SET deleted_at = CURRENT_TIMESTAMP WHERE is_deleted = TRUE;
+
+CREATE INDEX idx_urls_deleted_at ON urls(deleted_at) WHERE is_deleted = TRUE;
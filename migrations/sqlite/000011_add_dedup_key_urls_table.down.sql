@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_urls_dedup_key;
+DROP INDEX IF EXISTS idx_urls_url;
+CREATE UNIQUE INDEX idx_urls_url ON urls(url);
+
+ALTER TABLE urls
+DROP COLUMN dedup_key;
@@ -0,0 +1,2 @@
+ALTER TABLE urls
+ADD COLUMN input_url TEXT NULL DEFAULT NULL;
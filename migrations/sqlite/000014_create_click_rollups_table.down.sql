@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_clicks_ts;
+DROP TABLE IF EXISTS click_rollups;
@@ -0,0 +1,17 @@
+CREATE TABLE click_rollups (
+    code VARCHAR(64) NOT NULL REFERENCES urls(code) ON DELETE CASCADE,
+    bucket_interval TEXT NOT NULL,
+    bucket_start DATETIME NOT NULL,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (code, bucket_interval, bucket_start)
+);
+
+CREATE INDEX idx_clicks_ts ON clicks(ts);
+
+INSERT INTO click_rollups (code, bucket_interval, bucket_start, clicks)
+SELECT code, 'hour', strftime('%Y-%m-%d %H:00:00.000', ts), COUNT(*)
+FROM clicks GROUP BY 1, 3;
+
+INSERT INTO click_rollups (code, bucket_interval, bucket_start, clicks)
+SELECT code, 'day', strftime('%Y-%m-%d 00:00:00.000', ts), COUNT(*)
+FROM clicks GROUP BY 1, 3;
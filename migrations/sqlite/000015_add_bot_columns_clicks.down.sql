@@ -0,0 +1,2 @@
+ALTER TABLE click_rollups DROP COLUMN bots;
+ALTER TABLE clicks DROP COLUMN is_bot;
@@ -0,0 +1,2 @@
+ALTER TABLE clicks ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_rollups ADD COLUMN bots INTEGER NOT NULL DEFAULT 0;
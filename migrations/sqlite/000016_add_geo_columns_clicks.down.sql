@@ -0,0 +1,3 @@
+ALTER TABLE clicks DROP COLUMN asn;
+ALTER TABLE clicks DROP COLUMN region;
+ALTER TABLE clicks DROP COLUMN country;
@@ -0,0 +1,8 @@
+-- Daily HyperLogLog sketches of distinct visitors. Sketches are built by the
+-- service, so clicks saved before this migration are not counted in them.
+CREATE TABLE click_sketches (
+    code VARCHAR(64) NOT NULL REFERENCES urls(code) ON DELETE CASCADE,
+    day DATETIME NOT NULL,
+    sketch BLOB NOT NULL,
+    PRIMARY KEY (code, day)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_click_trending_minute;
+DROP TABLE IF EXISTS click_trending;
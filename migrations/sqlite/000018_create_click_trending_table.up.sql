@@ -0,0 +1,10 @@
+-- Per-minute redirect counters for the trending leaderboard. Every instance
+-- adds its counts to the same rows; rows older than a day are dropped.
+CREATE TABLE click_trending (
+    code VARCHAR(64) NOT NULL REFERENCES urls(code) ON DELETE CASCADE,
+    minute DATETIME NOT NULL,
+    clicks INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (code, minute)
+);
+
+CREATE INDEX idx_click_trending_minute ON click_trending(minute);